@@ -0,0 +1,13 @@
+package cachestore
+
+import "time"
+
+// IdempotencyStore keeps entries of httpx.IdempotencyWithConfig, implemented by httpx on memkv, redis and dbc
+type IdempotencyStore interface {
+	// SetNX stores value only if key is absent, returns whether stored
+	SetNX(key string, value []byte, ttl time.Duration) (bool, error)
+	// Get returns nil if key is absent
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+}
@@ -18,3 +18,14 @@ type ConsistentRecord interface {
 	Record
 	GetPrimaryName() string
 }
+
+// TaggedRecord groups records under tags, so that all records sharing a tag
+// can be invalidated with one call
+type TaggedRecord interface {
+	Record
+	GetTags() []string
+}
+
+func UniqTagKey(tag string) string {
+	return "tag_" + tag
+}
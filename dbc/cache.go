@@ -0,0 +1,403 @@
+package dbc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/madlabx/pkgx/cachestore"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/log"
+	"github.com/madlabx/pkgx/memkv"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// RowCacheIf stores rows cached by DbClient, *redis.Client implements it, see NewMemkvRowCache for memkv.
+// Get should return an error if the record is missing or expired
+type RowCacheIf interface {
+	Get(r cachestore.Record) error
+	Set(r cachestore.Record, expireAfterInSec int64) error
+	Delete(r cachestore.Record) error
+	DeleteWithKeyPrefix(r cachestore.Record) error
+}
+
+type TableCacheConf struct {
+	Table string
+	Ttl   int64 `vx_default:"300"` //in sec
+}
+
+// rowCache 旁路缓存, 行按 表名+主键 缓存, 唯一索引查询缓存 条件->主键 的映射.
+// 通过gorm回调在create/update/delete后失效: 能从model取得主键时删除对应行, 否则删除整张表的缓存.
+// Exec/Raw执行的sql不会触发失效
+type rowCache struct {
+	store RowCacheIf
+	ttls  map[string]int64
+}
+
+const rowCacheTable = "dbc"
+
+// cachedRow value is expireAt in big endian followed by the gob encoded row
+type cachedRow struct {
+	key      string
+	data     []byte
+	expireAt int64
+}
+
+func (r *cachedRow) GetKey() string {
+	return r.key
+}
+
+func (r *cachedRow) GetValue() string {
+	buf := make([]byte, 8, 8+len(r.data))
+	binary.BigEndian.PutUint64(buf, uint64(r.expireAt))
+	return string(append(buf, r.data...))
+}
+
+func (r *cachedRow) Unmarshal(s string) error {
+	if len(s) < 8 {
+		return errors.Errorf("invalid cached row, key:%v", r.key)
+	}
+	r.expireAt = int64(binary.BigEndian.Uint64([]byte(s[:8])))
+	r.data = []byte(s[8:])
+	return nil
+}
+
+func (r *cachedRow) SetExpireAt(expireAt int64) {
+	r.expireAt = expireAt
+}
+
+func (r *cachedRow) GetExpireAt() int64 {
+	return r.expireAt
+}
+
+func (r *cachedRow) TableName() string {
+	return rowCacheTable
+}
+
+func (r *cachedRow) Clone() cachestore.Record {
+	n := *r
+	return &n
+}
+
+func (r *cachedRow) GetPrimaryName() string {
+	return "key"
+}
+
+func rowKey(table string, pk any) *cachedRow {
+	return &cachedRow{key: table + ":" + fmt.Sprint(pk)}
+}
+
+func uniqKey(table, cond string) *cachedRow {
+	sum := sha1.Sum([]byte(cond))
+	return &cachedRow{key: table + ":uniq:" + hex.EncodeToString(sum[:])}
+}
+
+func tableKeyPrefix(table string) *cachedRow {
+	return &cachedRow{key: table + ":"}
+}
+
+type memkvRowCache struct {
+	c *memkv.Cache
+}
+
+// NewMemkvRowCache uses c as the storage of rows cached by DbClient, c should be created without db
+func NewMemkvRowCache(c *memkv.Cache) RowCacheIf {
+	return &memkvRowCache{c: c}
+}
+
+func (m *memkvRowCache) Get(r cachestore.Record) error {
+	_, err := m.c.Get(r)
+	return err
+}
+
+func (m *memkvRowCache) Set(r cachestore.Record, expireAfterInSec int64) error {
+	return m.c.Set(r, expireAfterInSec)
+}
+
+func (m *memkvRowCache) Delete(r cachestore.Record) error {
+	if err := m.c.Delete(r); err != nil && !errors.Is(err, memkv.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (m *memkvRowCache) DeleteWithKeyPrefix(r cachestore.Record) error {
+	return m.c.DeleteWithKeyPrefix(r.(cachestore.ConsistentRecord))
+}
+
+// EnableCache caches rows of tables read by GetByPrimary, FindUniq and ListByPrimaryKeys in store.
+// Rows are invalidated by mutations through this client, or expire after ttl of the table
+func (c *DbClient) EnableCache(store RowCacheIf, confs ...TableCacheConf) error {
+	if c.cache != nil {
+		return errors.New("cache already enabled")
+	}
+
+	rc := &rowCache{store: store, ttls: make(map[string]int64, len(confs))}
+	for _, conf := range confs {
+		if conf.Table == "" || conf.Ttl <= 0 {
+			return errors.Errorf("invalid table cache conf:%+v", conf)
+		}
+		rc.ttls[conf.Table] = conf.Ttl
+	}
+
+	cb := c.db.Callback()
+	for _, err := range []error{
+		cb.Create().After("gorm:create").Register("dbc:invalidate_cache", rc.invalidate),
+		cb.Update().After("gorm:update").Register("dbc:invalidate_cache", rc.invalidate),
+		cb.Delete().After("gorm:delete").Register("dbc:invalidate_cache", rc.invalidate),
+	} {
+		if err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	c.cache = rc
+	return nil
+}
+
+// cacheFor returns the schema of dest if the table of dest is cached.
+// Clients with pending conditions, e.g. created by Where, bypass the cache
+func (c *DbClient) cacheFor(dest any) *schema.Schema {
+	if c.cache == nil || len(c.db.Statement.Clauses) > 0 {
+		return nil
+	}
+
+	stmt := &gorm.Statement{DB: c.db}
+	if err := stmt.Parse(dest); err != nil {
+		return nil
+	}
+
+	if _, ok := c.cache.ttls[stmt.Schema.Table]; !ok || stmt.Schema.PrioritizedPrimaryField == nil {
+		return nil
+	}
+
+	return stmt.Schema
+}
+
+// getRow fills dest with the cached row, dest is a pointer to struct
+func (rc *rowCache) getRow(table string, pk any, dest any) bool {
+	r := rowKey(table, pk)
+	if err := rc.store.Get(r); err != nil {
+		return false
+	}
+
+	row := reflect.New(reflect.TypeOf(dest).Elem())
+	if err := gob.NewDecoder(bytes.NewReader(r.data)).Decode(row.Interface()); err != nil {
+		log.Errorf("Failed to decode cached row, key:%v, err:%v", r.key, err)
+		return false
+	}
+
+	reflect.ValueOf(dest).Elem().Set(row.Elem())
+	return true
+}
+
+func (rc *rowCache) setRow(table string, pk any, row any) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(row); err != nil {
+		log.Errorf("Failed to encode row, table:%v, err:%v", table, err)
+		return
+	}
+
+	r := rowKey(table, pk)
+	r.data = buf.Bytes()
+	if err := rc.store.Set(r, rc.ttls[table]); err != nil {
+		log.Errorf("Failed to cache row, key:%v, err:%v", r.key, err)
+	}
+}
+
+func (rc *rowCache) invalidate(db *gorm.DB) {
+	sch := db.Statement.Schema
+	if sch == nil {
+		return
+	}
+	if _, ok := rc.ttls[sch.Table]; !ok {
+		return
+	}
+
+	pks := primaryKeys(db.Statement.Context, sch, db.Statement.ReflectValue)
+	if len(pks) == 0 {
+		// rows affected are unknown
+		if err := rc.store.DeleteWithKeyPrefix(tableKeyPrefix(sch.Table)); err != nil {
+			log.Errorf("Failed to invalidate cached table, table:%v, err:%v", sch.Table, err)
+		}
+		return
+	}
+
+	for _, pk := range pks {
+		if err := rc.store.Delete(rowKey(sch.Table, pk)); err != nil {
+			log.Errorf("Failed to invalidate cached row, table:%v, pk:%v, err:%v", sch.Table, pk, err)
+		}
+	}
+}
+
+// primaryKeys returns non-zero primary keys of a struct or a slice of structs
+func primaryKeys(ctx context.Context, sch *schema.Schema, rv reflect.Value) []any {
+	field := sch.PrioritizedPrimaryField
+	if field == nil || !rv.IsValid() {
+		return nil
+	}
+
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Struct:
+		if pk, zero := field.ValueOf(ctx, rv); !zero {
+			return []any{pk}
+		}
+	case reflect.Slice, reflect.Array:
+		var pks []any
+		for i := 0; i < rv.Len(); i++ {
+			elem := reflect.Indirect(rv.Index(i))
+			if elem.Kind() != reflect.Struct {
+				return nil
+			}
+			pk, zero := field.ValueOf(ctx, elem)
+			if zero {
+				return nil
+			}
+			pks = append(pks, pk)
+		}
+		return pks
+	default:
+	}
+
+	return nil
+}
+
+// uniqCond serializes non-zero fields of filter, which is the condition of FindUniq
+func uniqCond(ctx context.Context, sch *schema.Schema, filter reflect.Value) string {
+	var sb strings.Builder
+	for _, f := range sch.Fields {
+		if f.DBName == "" {
+			continue
+		}
+		if v, zero := f.ValueOf(ctx, filter); !zero {
+			fmt.Fprintf(&sb, "%s=%#v;", f.DBName, v)
+		}
+	}
+	return sb.String()
+}
+
+// matchUniq checks non-zero fields of filter equal to those of row
+func matchUniq(ctx context.Context, sch *schema.Schema, filter, row reflect.Value) bool {
+	for _, f := range sch.Fields {
+		if f.DBName == "" {
+			continue
+		}
+		fv, zero := f.ValueOf(ctx, filter)
+		if zero {
+			continue
+		}
+		if rv, _ := f.ValueOf(ctx, row); !reflect.DeepEqual(fv, rv) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *DbClient) getByPrimaryCached(sch *schema.Schema, dest any, id any) error {
+	if c.cache.getRow(sch.Table, id, dest) {
+		return nil
+	}
+
+	if err := c.getByPrimary(dest, id); err != nil {
+		return err
+	}
+
+	c.cache.setRow(sch.Table, id, dest)
+	return nil
+}
+
+func (c *DbClient) findUniqCached(sch *schema.Schema, filterAndDest any) error {
+	ctx := c.db.Statement.Context
+	filter := reflect.ValueOf(filterAndDest).Elem()
+	mapping := uniqKey(sch.Table, uniqCond(ctx, sch, filter))
+
+	if err := c.cache.store.Get(mapping); err == nil {
+		row := reflect.New(filter.Type())
+		if c.cache.getRow(sch.Table, string(mapping.data), row.Interface()) && matchUniq(ctx, sch, filter, row.Elem()) {
+			filter.Set(row.Elem())
+			return nil
+		}
+	}
+
+	if err := c.findUniq(filterAndDest); err != nil {
+		return err
+	}
+
+	pk, zero := sch.PrioritizedPrimaryField.ValueOf(ctx, filter)
+	if zero {
+		return nil
+	}
+	c.cache.setRow(sch.Table, pk, filterAndDest)
+
+	mapping.data = []byte(fmt.Sprint(pk))
+	if err := c.cache.store.Set(mapping, c.cache.ttls[sch.Table]); err != nil {
+		log.Errorf("Failed to cache unique key, table:%v, err:%v", sch.Table, err)
+	}
+
+	return nil
+}
+
+func (c *DbClient) listByPrimaryKeysCached(sch *schema.Schema, dest any, keys any) error {
+	var (
+		ctx      = c.db.Statement.Context
+		out      = reflect.ValueOf(dest).Elem()
+		elemType = out.Type().Elem()
+		isPtr    = elemType.Kind() == reflect.Pointer
+		structT  = elemType
+		keysV    = reflect.ValueOf(keys)
+		misses   = reflect.MakeSlice(keysV.Type(), 0, keysV.Len())
+		found    = make(map[string]reflect.Value, keysV.Len())
+	)
+	if isPtr {
+		structT = elemType.Elem()
+	}
+
+	for i := 0; i < keysV.Len(); i++ {
+		row := reflect.New(structT)
+		if c.cache.getRow(sch.Table, keysV.Index(i).Interface(), row.Interface()) {
+			found[fmt.Sprint(keysV.Index(i).Interface())] = row
+		} else {
+			misses = reflect.Append(misses, keysV.Index(i))
+		}
+	}
+
+	if misses.Len() > 0 {
+		rows := reflect.New(reflect.SliceOf(structT))
+		if err := c.db.Find(rows.Interface(), misses.Interface()).Error; err != nil {
+			return err
+		}
+		for i := 0; i < rows.Elem().Len(); i++ {
+			row := rows.Elem().Index(i).Addr()
+			pk, _ := sch.PrioritizedPrimaryField.ValueOf(ctx, row.Elem())
+			c.cache.setRow(sch.Table, pk, row.Interface())
+			found[fmt.Sprint(pk)] = row
+		}
+	}
+
+	// in the order of keys
+	result := reflect.MakeSlice(out.Type(), 0, len(found))
+	for i := 0; i < keysV.Len(); i++ {
+		key := fmt.Sprint(keysV.Index(i).Interface())
+		row, ok := found[key]
+		if !ok {
+			continue
+		}
+		delete(found, key)
+		if isPtr {
+			result = reflect.Append(result, row)
+		} else {
+			result = reflect.Append(result, row.Elem())
+		}
+	}
+	out.Set(result)
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package dbc
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/madlabx/pkgx/errcode"
+	"github.com/madlabx/pkgx/memkv"
+	"github.com/stretchr/testify/require"
+)
+
+func newCachedDb(t *testing.T) (*DbClient, *memkv.Cache) {
+	db, err := NewDbClient(context.Background(),
+		SqlConfig{
+			Log:    LogConfig{Level: "error"},
+			Type:   "sqllite",
+			Dbname: filepath.Join(t.TempDir(), "cache.db"),
+		},
+		&TestUserInfo{})
+	require.Nil(t, err)
+
+	mc := memkv.NewCache(context.Background(), nil, memkv.CacheConf{})
+	require.Nil(t, db.EnableCache(NewMemkvRowCache(mc), TableCacheConf{Table: "test_user_info", Ttl: 60}))
+	return db, mc
+}
+
+func cachedRowOf(mc *memkv.Cache, pk any) bool {
+	_, err := mc.Get(rowKey("test_user_info", pk))
+	return err == nil
+}
+
+func TestCacheGetByPrimary(t *testing.T) {
+	db, mc := newCachedDb(t)
+
+	u := &TestUserInfo{Name: "alice", Password: "secret"}
+	require.Nil(t, db.Save(u))
+
+	got := &TestUserInfo{}
+	require.Nil(t, db.GetByPrimary(got, u.Id))
+	require.Equal(t, "secret", got.Password)
+	require.True(t, cachedRowOf(mc, u.Id))
+
+	// served by cache, even if changed behind DbClient
+	require.Nil(t, db.DB().Exec("UPDATE test_user_info SET password = ? WHERE id = ?", "raw", u.Id).Error)
+	got = &TestUserInfo{}
+	require.Nil(t, db.GetByPrimary(got, u.Id))
+	require.Equal(t, "secret", got.Password)
+
+	// mutations through DbClient invalidate the row
+	u.Password = "new"
+	require.Nil(t, db.Save(u))
+	require.False(t, cachedRowOf(mc, u.Id))
+	got = &TestUserInfo{}
+	require.Nil(t, db.GetByPrimary(got, u.Id))
+	require.Equal(t, "new", got.Password)
+
+	require.Nil(t, db.Update(&TestUserInfo{Id: u.Id}, "password", "updated"))
+	got = &TestUserInfo{}
+	require.Nil(t, db.GetByPrimary(got, u.Id))
+	require.Equal(t, "updated", got.Password)
+
+	require.Nil(t, db.Delete(&TestUserInfo{Id: u.Id}))
+	require.True(t, errcode.IsNotFound(db.GetByPrimary(&TestUserInfo{}, u.Id)))
+}
+
+func TestCacheFindUniq(t *testing.T) {
+	db, mc := newCachedDb(t)
+
+	require.Nil(t, db.Save(&TestUserInfo{Name: "alice", CloudUserId: 1}))
+	require.Nil(t, db.Save(&TestUserInfo{Name: "bob", CloudUserId: 2}))
+
+	got := &TestUserInfo{Name: "bob"}
+	require.Nil(t, db.FindUniq(got))
+	require.Equal(t, uint64(2), got.CloudUserId)
+	require.True(t, cachedRowOf(mc, got.Id))
+
+	// served by cache
+	require.Nil(t, db.DB().Exec("UPDATE test_user_info SET cloud_user_id = 20 WHERE id = ?", got.Id).Error)
+	got2 := &TestUserInfo{Name: "bob"}
+	require.Nil(t, db.FindUniq(got2))
+	require.Equal(t, *got, *got2)
+
+	// the cached row no longer matches the filter after rename
+	require.Nil(t, db.UpdatesOmitZero(&TestUserInfo{Id: got.Id}, &TestUserInfo{Name: "bobby"}))
+	require.Nil(t, db.Save(&TestUserInfo{Name: "bob", CloudUserId: 3}))
+	got3 := &TestUserInfo{Name: "bob"}
+	require.Nil(t, db.FindUniq(got3))
+	require.Equal(t, uint64(3), got3.CloudUserId)
+
+	// conditions without primary key invalidate the whole table
+	require.Nil(t, db.UpdatesOmitZero(&TestUserInfo{Name: "bob"}, &TestUserInfo{CloudUserId: 4}))
+	require.False(t, cachedRowOf(mc, got3.Id))
+	got4 := &TestUserInfo{Name: "bob"}
+	require.Nil(t, db.FindUniq(got4))
+	require.Equal(t, uint64(4), got4.CloudUserId)
+}
+
+func TestCacheListByPrimaryKeys(t *testing.T) {
+	db, mc := newCachedDb(t)
+
+	for _, name := range []string{"a", "b", "c"} {
+		require.Nil(t, db.Save(&TestUserInfo{Name: name}))
+	}
+	require.Nil(t, db.GetByPrimary(&TestUserInfo{}, 2))
+	require.True(t, cachedRowOf(mc, 2))
+
+	var users []TestUserInfo
+	require.Nil(t, db.ListByPrimaryKeys(&users, []uint64{3, 2, 1, 9, 3}))
+	require.Equal(t, 3, len(users))
+	require.Equal(t, []string{"c", "b", "a"}, []string{users[0].Name, users[1].Name, users[2].Name})
+	require.True(t, cachedRowOf(mc, 1))
+	require.True(t, cachedRowOf(mc, 3))
+
+	var ptrs []*TestUserInfo
+	require.Nil(t, db.ListByPrimaryKeys(&ptrs, []uint64{1, 2}))
+	require.Equal(t, "a", ptrs[0].Name)
+	require.Equal(t, "b", ptrs[1].Name)
+
+	// clients with pending conditions bypass the cache
+	require.True(t, errcode.IsNotFound(db.Where("name = ?", "x").GetByPrimary(&TestUserInfo{}, 1)))
+}
@@ -37,6 +37,8 @@ type DbClient struct {
 	// ext db fields
 	extDbPrefix   string
 	initCompleted bool
+
+	cache *rowCache
 }
 
 type LogContent struct {
@@ -309,6 +311,15 @@ func (c *DbClient) DB() *gorm.DB {
 	return c.db
 }
 
+// Ping checks the connection, usable as httpx.ReadyChecker
+func (c *DbClient) Ping(ctx context.Context) error {
+	sqlDb, err := c.db.DB()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	return errors.Wrap(sqlDb.PingContext(ctx))
+}
+
 func (c *DbClient) RawCmd(sql string) ([]map[string]any, error) {
 	rows, err := c.db.Raw(sql).Rows()
 	if err != nil {
@@ -516,7 +527,7 @@ func (c *DbClient) ListWithKeyPrefix(dest any, filter any, keyFieldName, keyPref
 		return errors.New("dest should be pointer to slice")
 	}
 
-	err := c.db.Model(filter).Find(dest, keyFieldName+" like ?", keyPrefix+"%").Error
+	err := c.db.Model(filter).Find(dest, keyFieldName+" LIKE ? ESCAPE ?", likePrefix(keyPrefix), likeEscape).Error
 	if err != nil {
 		return errors.Wrap(err)
 	}
@@ -542,6 +553,26 @@ func (c *DbClient) ListWithKeyPrefix(dest any, filter any, keyFieldName, keyPref
 	return nil
 }
 
+// DeleteWithKeyPrefix deletes all records in the table of filter whose keyFieldName starts with keyPrefix
+func (c *DbClient) DeleteWithKeyPrefix(filter any, keyFieldName, keyPrefix string) error {
+	if reflect.TypeOf(filter).Kind() != reflect.Pointer {
+		return errors.New("filter should be pointer")
+	}
+
+	// use an empty model, or gorm appends the non-zero primary key of filter to conditions
+	model := reflect.New(reflect.TypeOf(filter).Elem()).Interface()
+	return errors.Wrap(c.db.Delete(model, keyFieldName+" LIKE ? ESCAPE ?", likePrefix(keyPrefix), likeEscape).Error)
+}
+
+const likeEscape = `\`
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likePrefix matches strings starting with prefix literally, by LIKE ... ESCAPE likeEscape
+func likePrefix(prefix string) string {
+	return likeEscaper.Replace(prefix) + "%"
+}
+
 func ParsePrimaryKey(in any) (string, bool) {
 	v := reflect.ValueOf(in)
 	t := reflect.TypeOf(in)
@@ -578,6 +609,15 @@ func ParsePrimaryKey(in any) (string, bool) {
 }
 
 func (c *DbClient) GetByPrimary(dest any, id any) error {
+	if reflect.TypeOf(dest).Kind() == reflect.Pointer && reflect.TypeOf(dest).Elem().Kind() == reflect.Struct {
+		if sch := c.cacheFor(dest); sch != nil {
+			return c.getByPrimaryCached(sch, dest, id)
+		}
+	}
+	return c.getByPrimary(dest, id)
+}
+
+func (c *DbClient) getByPrimary(dest any, id any) error {
 	err := c.db.First(dest, id).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return errcode.ErrObjectNotExist()
@@ -589,6 +629,9 @@ func (c *DbClient) ListByPrimaryKeys(dest any, keys any) error {
 	if reflect.TypeOf(keys).Kind() != reflect.Slice {
 		return errors.New("keys should be slice")
 	}
+	if sch := c.cacheFor(dest); sch != nil {
+		return c.listByPrimaryKeysCached(sch, dest, keys)
+	}
 	return c.db.Find(dest, keys).Error
 }
 
@@ -663,6 +706,13 @@ func (c *DbClient) ReActivate(in any) error {
 
 // WARMING: zero in filterAndDest is omited
 func (c *DbClient) FindUniq(filterAndDest any) error {
+	if sch := c.cacheFor(filterAndDest); sch != nil {
+		return c.findUniqCached(sch, filterAndDest)
+	}
+	return c.findUniq(filterAndDest)
+}
+
+func (c *DbClient) findUniq(filterAndDest any) error {
 	err := c.Where(filterAndDest).First(filterAndDest)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return errcode.ErrObjectNotExist()
@@ -75,6 +75,8 @@ func (r *RefreshTokenMock) SetExpireAt(expireInSec int64) {
 
 func (r *RefreshTokenMock) TableName() string { return "refresh_token_mock_d11" }
 
+func (r *RefreshTokenMock) GetPrimaryName() string { return "key" }
+
 func (r *RefreshTokenMock) save(dbc *DbClient) error {
 	return dbc.Save(r)
 }
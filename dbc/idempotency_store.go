@@ -0,0 +1,85 @@
+package dbc
+
+import (
+	"time"
+
+	"github.com/madlabx/pkgx/cachestore"
+	"github.com/madlabx/pkgx/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var _ cachestore.IdempotencyStore = (*idempotencyStore)(nil)
+
+// IdempotencyRecord entry of httpx.IdempotencyWithConfig stored in db
+type IdempotencyRecord struct {
+	Key      string `gorm:"column:key;primaryKey;size:255"`
+	Value    []byte `gorm:"column:value"`
+	ExpireAt int64  `gorm:"column:expire_at;index"` //unix ms
+}
+
+func (r *IdempotencyRecord) TableName() string { return "idempotency_record" }
+
+type idempotencyStore struct {
+	c *DbClient
+}
+
+// NewIdempotencyStore keeps idempotency entries in table idempotency_record, the table is created if absent.
+// Expired rows are deleted when the key is reused, call DeleteExpiredIdempotency to purge the others
+func NewIdempotencyStore(c *DbClient) (cachestore.IdempotencyStore, error) {
+	if err := c.initTable(&IdempotencyRecord{}); err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return &idempotencyStore{c: c}, nil
+}
+
+// keyIs and expiredAt are quoted by the dialector, "key" is reserved in mysql
+func keyIs(key string) clause.Expression {
+	return clause.Eq{Column: clause.Column{Name: "key"}, Value: key}
+}
+
+func expiredAt(now time.Time) clause.Expression {
+	return clause.Lt{Column: clause.Column{Name: "expire_at"}, Value: now.UnixMilli()}
+}
+
+func (s *idempotencyStore) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	var stored bool
+	err := s.c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(keyIs(key)).Where(expiredAt(now)).Delete(&IdempotencyRecord{}).Error; err != nil {
+			return err
+		}
+
+		ret := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&IdempotencyRecord{Key: key, Value: value, ExpireAt: now.Add(ttl).UnixMilli()})
+		stored = ret.RowsAffected == 1
+		return ret.Error
+	})
+
+	return stored, errors.Wrap(err)
+}
+
+func (s *idempotencyStore) Get(key string) ([]byte, error) {
+	r := &IdempotencyRecord{}
+	err := s.c.db.Where(keyIs(key)).Not(expiredAt(time.Now())).Take(r).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return r.Value, nil
+}
+
+func (s *idempotencyStore) Set(key string, value []byte, ttl time.Duration) error {
+	return errors.Wrap(s.c.db.Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&IdempotencyRecord{Key: key, Value: value, ExpireAt: time.Now().Add(ttl).UnixMilli()}).Error)
+}
+
+func (s *idempotencyStore) Delete(key string) error {
+	return errors.Wrap(s.c.db.Where(keyIs(key)).Delete(&IdempotencyRecord{}).Error)
+}
+
+// DeleteExpiredIdempotency purges expired rows of idempotency_record
+func (c *DbClient) DeleteExpiredIdempotency() error {
+	return errors.Wrap(c.db.Where(expiredAt(time.Now())).Delete(&IdempotencyRecord{}).Error)
+}
@@ -0,0 +1,85 @@
+package dbc
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	db, err := NewDbClient(context.Background(), SqlConfig{
+		Log:    LogConfig{Level: "error"},
+		Type:   "sqllite",
+		Dbname: filepath.Join(t.TempDir(), "idempotency.db"),
+	})
+	require.Nil(t, err)
+
+	s, err := NewIdempotencyStore(db)
+	require.Nil(t, err)
+
+	ok, err := s.SetNX("k1", []byte("pending"), time.Minute)
+	require.Nil(t, err)
+	require.True(t, ok)
+	ok, err = s.SetNX("k1", []byte("other"), time.Minute)
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	require.Nil(t, s.Set("k1", []byte("done"), time.Minute))
+	v, err := s.Get("k1")
+	require.Nil(t, err)
+	require.Equal(t, "done", string(v))
+
+	// expired entries are absent and can be taken again
+	require.Nil(t, s.Set("k2", []byte("done"), -time.Second))
+	v, err = s.Get("k2")
+	require.Nil(t, err)
+	require.Nil(t, v)
+	ok, err = s.SetNX("k2", []byte("pending"), time.Minute)
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	require.Nil(t, s.Delete("k1"))
+	v, err = s.Get("k1")
+	require.Nil(t, err)
+	require.Nil(t, v)
+	require.Nil(t, db.DeleteExpiredIdempotency())
+}
+
+type sqlRecorder []string
+
+func (r *sqlRecorder) Printf(format string, args ...any) {
+	*r = append(*r, fmt.Sprint(args...))
+}
+
+// queries are quoted by the dialector, runs without a postgres server by DryRun
+func TestIdempotencyStorePostgresQuery(t *testing.T) {
+	var sqls sqlRecorder
+	db, err := gorm.Open(postgres.Open("host=127.0.0.1 user=none dbname=none"), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+		Logger:                 logger.New(&sqls, logger.Config{LogLevel: logger.Info}),
+	})
+	require.Nil(t, err)
+	s := &idempotencyStore{c: &DbClient{db: db}}
+
+	_, err = s.Get("k1")
+	require.Nil(t, err)
+	require.Nil(t, s.Delete("k1"))
+	require.Nil(t, s.c.DeleteExpiredIdempotency())
+
+	require.Len(t, sqls, 3)
+	require.Contains(t, sqls[0], `WHERE "key" = 'k1' AND "expire_at" >= `)
+	require.Contains(t, sqls[1], `DELETE FROM "idempotency_record" WHERE "key" = 'k1'`)
+	require.Contains(t, sqls[2], `DELETE FROM "idempotency_record" WHERE "expire_at" < `)
+	for _, sql := range sqls {
+		require.NotContains(t, sql, "`")
+	}
+}
@@ -135,3 +135,40 @@ func (s *TestPSqlSuite) TestGet_MissCache_MissDB() {
 	ok := errors.Is(errcode.ErrObjectNotExist(), err)
 	s.True(ok)
 }
+
+func (s *TestPSqlSuite) TestDelete() {
+	rt := &RefreshTokenMock{IKey: "key4", IValue: "value4"}
+	s.R.Nil(s.cache.Set(rt, 5))
+
+	s.R.Nil(s.cache.Delete(&RefreshTokenMock{IKey: "key4"}))
+
+	_, err := s.cache.Get(&RefreshTokenMock{IKey: "key4"})
+	s.True(errors.Is(errcode.ErrObjectNotExist(), err))
+}
+
+func (s *TestPSqlSuite) TestDeleteWithKeyPrefix() {
+	for _, k := range []string{"dp_1", "dp_2", "other_dp_1", "dpx1"} {
+		s.R.Nil(s.cache.Set(&RefreshTokenMock{IKey: k, IValue: k}, 5))
+	}
+	defer func() {
+		_ = s.cache.Delete(&RefreshTokenMock{IKey: "other_dp_1"})
+		_ = s.cache.Delete(&RefreshTokenMock{IKey: "dpx1"})
+	}()
+
+	s.R.Nil(s.cache.DeleteWithKeyPrefix(&RefreshTokenMock{IKey: "dp_"}))
+
+	_, err := s.cache.Get(&RefreshTokenMock{IKey: "dp_1"})
+	s.True(errors.Is(errcode.ErrObjectNotExist(), err))
+	_, err = s.cache.Get(&RefreshTokenMock{IKey: "dp_2"})
+	s.True(errors.Is(errcode.ErrObjectNotExist(), err))
+
+	r, err := s.cache.Get(&RefreshTokenMock{IKey: "other_dp_1"})
+	s.R.Nil(err)
+	s.Equal("other_dp_1", r.GetValue())
+
+	// _ is not a wildcard, in memory or in db
+	s.cache.Clear()
+	r, err = s.cache.Get(&RefreshTokenMock{IKey: "dpx1"})
+	s.R.Nil(err)
+	s.Equal("dpx1", r.GetValue())
+}
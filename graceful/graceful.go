@@ -17,6 +17,14 @@ type GracefulService interface {
 	Name() string
 }
 
+// GracefulServiceWithTimeout takes longer than defaultQuitTimeout to stop, WaitToQuit waits for the longest one
+type GracefulServiceWithTimeout interface {
+	GracefulService
+	StopTimeout() time.Duration
+}
+
+const defaultQuitTimeout = 5 * time.Second
+
 type Graceful struct {
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -72,7 +80,13 @@ func (gc *Graceful) WaitToQuit(gss ...GracefulService) {
 	<-gc.ctx.Done()
 	log.Errorf("WaitToQuit")
 
-	quitCtx, quitCtxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	timeout := defaultQuitTimeout
+	for _, gs := range gss {
+		if st, ok := gs.(GracefulServiceWithTimeout); ok {
+			timeout = max(timeout, st.StopTimeout())
+		}
+	}
+	quitCtx, quitCtxCancel := context.WithTimeout(context.Background(), timeout)
 
 	go func() {
 		log.Infof("Start to stop services")
@@ -80,7 +94,7 @@ func (gc *Graceful) WaitToQuit(gss ...GracefulService) {
 		for _, gs := range gss {
 			wg.Add(1)
 			go func(s GracefulService) {
-				log.IgnoreErrf(s.Stop(), s.Name())
+				log.IgnoreErrf(s.Stop(), "stop %v", s.Name())
 				wg.Done()
 			}(gs)
 		}
@@ -0,0 +1,426 @@
+package httpx
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/madlabx/pkgx/tracex"
+)
+
+type AccessLogEncoding string
+
+const (
+	AccessLogJson   AccessLogEncoding = "json"
+	AccessLogLogfmt AccessLogEncoding = "logfmt"
+
+	RedactedValue   = "REDACTED"
+	TruncatedMarker = "...(truncated)"
+)
+
+// fields of the structured access log, in the order written
+const (
+	FieldTime       = "time"
+	FieldId         = "id"
+	FieldTraceId    = "trace_id"
+	FieldSpanId     = "span_id"
+	FieldRemoteIp   = "remote_ip"
+	FieldHost       = "host"
+	FieldMethod     = "method"
+	FieldUri        = "uri" // query params are redacted as well
+	FieldPath       = "path"
+	FieldRoute      = "route"
+	FieldProtocol   = "protocol"
+	FieldReferer    = "referer"
+	FieldUserAgent  = "user_agent"
+	FieldStatus     = "status"
+	FieldError      = "error"
+	FieldLatencyMs  = "latency_ms"
+	FieldBytesIn    = "bytes_in"
+	FieldBytesOut   = "bytes_out"
+	FieldPrincipal  = "principal"
+	FieldAuthMethod = "auth_method"
+	FieldHeaders    = "headers" // request headers, headers.<name> in logfmt
+	FieldQuery      = "query"   // query params, query.<name> in logfmt
+	FieldBodyIn     = "body_in"
+	FieldBodyOut    = "body_out" // only if OutBodyFilter allows
+)
+
+var (
+	AccessLogFields = []string{FieldTime, FieldId, FieldTraceId, FieldSpanId, FieldRemoteIp, FieldHost, FieldMethod,
+		FieldUri, FieldPath, FieldRoute, FieldProtocol, FieldReferer, FieldUserAgent, FieldStatus, FieldError,
+		FieldLatencyMs, FieldBytesIn, FieldBytesOut, FieldPrincipal, FieldAuthMethod, FieldHeaders, FieldQuery,
+		FieldBodyIn, FieldBodyOut}
+
+	DefaultAccessLogFields = []string{FieldTime, FieldId, FieldTraceId, FieldRemoteIp, FieldHost, FieldMethod,
+		FieldUri, FieldRoute, FieldUserAgent, FieldStatus, FieldError, FieldLatencyMs, FieldBytesIn, FieldBytesOut,
+		FieldPrincipal}
+
+	DefaultRedactedHeaders     = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key", HeaderHmacSign}
+	DefaultRedactedQueryParams = []string{"token", "access_token", "password", "api_key", HmacParamSign}
+)
+
+// RedactConfig values of names in Deny are replaced by RedactedValue, so are names not in Allow if Allow is set.
+// Names are case-insensitive
+type RedactConfig struct {
+	Allow []string
+	Deny  []string // DefaultRedactedHeaders or DefaultRedactedQueryParams if nil
+}
+
+type redactor struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+func newRedactor(conf RedactConfig, defaultDeny []string) *redactor {
+	if conf.Deny == nil {
+		conf.Deny = defaultDeny
+	}
+	r := &redactor{deny: make(map[string]bool, len(conf.Deny))}
+	for _, name := range conf.Deny {
+		r.deny[strings.ToLower(name)] = true
+	}
+	if len(conf.Allow) > 0 {
+		r.allow = make(map[string]bool, len(conf.Allow))
+		for _, name := range conf.Allow {
+			r.allow[strings.ToLower(name)] = true
+		}
+	}
+	return r
+}
+
+func (r *redactor) redacted(name string) bool {
+	name = strings.ToLower(name)
+	return r.deny[name] || (r.allow != nil && !r.allow[name])
+}
+
+func (r *redactor) values(vs map[string][]string) map[string]string {
+	m := make(map[string]string, len(vs))
+	for name, v := range vs {
+		if r.redacted(name) {
+			m[name] = RedactedValue
+		} else {
+			m[name] = strings.Join(v, ",")
+		}
+	}
+	return m
+}
+
+// SamplingRule logs Ratio of requests with status in [StatusMin, StatusMax]
+type SamplingRule struct {
+	StatusMin int
+	StatusMax int
+	Ratio     float64
+}
+
+type StructuredLogConfig struct {
+	// Encoding json or logfmt, the Format templates are used if empty, logfmt if unknown
+	Encoding AccessLogEncoding `vx_default:""`
+	// Fields selected from AccessLogFields, DefaultAccessLogFields if empty
+	Fields  []string
+	Headers RedactConfig
+	Query   RedactConfig
+	MaxBody int `vx_default:"1024"` //in bytes, longer bodies end with TruncatedMarker
+	// Sampling the first matched rule applies, requests are all logged if no rule matches,
+	// e.g. [{200,299,0.01}] logs 1% of 2xx and all others
+	Sampling []SamplingRule
+}
+
+func (conf *StructuredLogConfig) sampled(status int) bool {
+	for _, rule := range conf.Sampling {
+		if status >= rule.StatusMin && status <= rule.StatusMax {
+			return rule.Ratio >= 1 || rand.Float64() < rule.Ratio
+		}
+	}
+	return true
+}
+
+// logEncoder writes fields in order, strings are escaped for json or logfmt
+type logEncoder struct {
+	buf      *bytes.Buffer
+	encoding AccessLogEncoding
+	n        int
+}
+
+func (e *logEncoder) key(k string) {
+	if e.encoding == AccessLogJson {
+		if e.n > 0 {
+			e.buf.WriteByte(',')
+		}
+		writeJsonString(e.buf, k)
+		e.buf.WriteByte(':')
+	} else {
+		if e.n > 0 {
+			e.buf.WriteByte(' ')
+		}
+		e.buf.WriteString(k)
+		e.buf.WriteByte('=')
+	}
+	e.n++
+}
+
+func (e *logEncoder) str(k, v string) {
+	e.key(k)
+	if e.encoding == AccessLogJson {
+		writeJsonString(e.buf, v)
+	} else {
+		writeLogfmtValue(e.buf, v)
+	}
+}
+
+func (e *logEncoder) num(k, v string) {
+	e.key(k)
+	e.buf.WriteString(v)
+}
+
+func (e *logEncoder) strMap(k string, m map[string]string) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	if e.encoding != AccessLogJson {
+		for _, name := range names {
+			e.str(k+"."+logfmtKey(name), m[name])
+		}
+		return
+	}
+	e.key(k)
+	e.buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		writeJsonString(e.buf, name)
+		e.buf.WriteByte(':')
+		writeJsonString(e.buf, m[name])
+	}
+	e.buf.WriteByte('}')
+}
+
+func writeJsonString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
+
+// logfmtKey percent-encodes bytes other than [A-Za-z0-9_.-], names from clients could forge fields otherwise
+func logfmtKey(name string) string {
+	safe := func(c byte) bool {
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-'
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; safe(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte("0123456789ABCDEF"[c>>4])
+			b.WriteByte("0123456789ABCDEF"[c&0xf])
+		}
+	}
+	return b.String()
+}
+
+func writeLogfmtValue(buf *bytes.Buffer, s string) {
+	if s == "" || strings.ContainsFunc(s, func(r rune) bool { return r <= ' ' || r == '=' || r == '"' || r == 0x7f }) {
+		buf.WriteString(strconv.Quote(s))
+		return
+	}
+	buf.WriteString(s)
+}
+
+func truncateBody(body []byte, truncated bool) string {
+	if truncated {
+		return string(body) + TruncatedMarker
+	}
+	return string(bytes.TrimSuffix(body, []byte("\n")))
+}
+
+// peekRequestBody reads at most limit bytes, and restores the body for handlers
+func peekRequestBody(c echo.Context, limit int) ([]byte, bool) {
+	req := c.Request()
+	if req.Body == nil || !isPrintableTextContent(req.Header.Get(echo.HeaderContentType)) {
+		return nil, false
+	}
+	head, _ := io.ReadAll(io.LimitReader(req.Body, int64(limit)+1))
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), req.Body), req.Body}
+
+	if len(head) > limit {
+		return head[:limit], true
+	}
+	return head, false
+}
+
+func structuredLogger(config LoggerConfig) echo.MiddlewareFunc {
+	sc := config.Structured
+	if len(sc.Fields) == 0 {
+		sc.Fields = DefaultAccessLogFields
+	}
+	if sc.MaxBody <= 0 {
+		sc.MaxBody = 1024
+	}
+	selected := make(map[string]bool, len(sc.Fields))
+	for _, f := range sc.Fields {
+		selected[f] = true
+	}
+	headers := newRedactor(sc.Headers, DefaultRedactedHeaders)
+	query := newRedactor(sc.Query, DefaultRedactedQueryParams)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			start := time.Now()
+			var (
+				bodyIn          []byte
+				bodyInTruncated bool
+				respBody        *limitBuffer
+			)
+			if selected[FieldBodyIn] {
+				bodyIn, bodyInTruncated = peekRequestBody(c, sc.MaxBody)
+			}
+			if selected[FieldBodyOut] && config.OutBodyFilter(c) {
+				respBody = newLimitBuffer(int64(sc.MaxBody))
+				c.Response().Writer = &bodyDumpResponseWriter{
+					Writer:         io.MultiWriter(c.Response().Writer, respBody),
+					ResponseWriter: c.Response().Writer,
+				}
+			}
+
+			handlerErr := next(c)
+			if handlerErr != nil {
+				c.Error(handlerErr)
+			}
+
+			req, res := c.Request(), c.Response()
+			if !sc.sampled(res.Status) {
+				return nil
+			}
+
+			buf := config.pool.Get().(*bytes.Buffer)
+			defer config.pool.Put(buf)
+			buf.Reset()
+			enc := &logEncoder{buf: buf, encoding: sc.Encoding}
+			if sc.Encoding == AccessLogJson {
+				buf.WriteByte('{')
+			}
+
+			span := tracex.SpanContextFromContext(req.Context())
+			for _, f := range AccessLogFields {
+				if !selected[f] {
+					continue
+				}
+				switch f {
+				case FieldTime:
+					enc.str(f, start.Format(time.RFC3339Nano))
+				case FieldId:
+					id := req.Header.Get(echo.HeaderXRequestID)
+					if id == "" {
+						id = res.Header().Get(echo.HeaderXRequestID)
+					}
+					enc.str(f, id)
+				case FieldTraceId:
+					if span.IsValid() {
+						enc.str(f, span.TraceId.String())
+					} else {
+						enc.str(f, "")
+					}
+				case FieldSpanId:
+					if span.IsValid() {
+						enc.str(f, span.SpanId.String())
+					} else {
+						enc.str(f, "")
+					}
+				case FieldRemoteIp:
+					enc.str(f, c.RealIP())
+				case FieldHost:
+					enc.str(f, req.Host)
+				case FieldMethod:
+					enc.str(f, req.Method)
+				case FieldUri:
+					uri := req.URL.Path
+					if req.URL.RawQuery != "" {
+						q := req.URL.Query()
+						for name := range q {
+							if query.redacted(name) {
+								q[name] = []string{RedactedValue}
+							}
+						}
+						uri += "?" + q.Encode()
+					}
+					enc.str(f, uri)
+				case FieldPath:
+					enc.str(f, req.URL.Path)
+				case FieldRoute:
+					route := c.Path()
+					if isNotFound(c) {
+						route = routeUnmatched
+					}
+					enc.str(f, route)
+				case FieldProtocol:
+					enc.str(f, req.Proto)
+				case FieldReferer:
+					enc.str(f, req.Referer())
+				case FieldUserAgent:
+					enc.str(f, req.UserAgent())
+				case FieldStatus:
+					enc.num(f, strconv.Itoa(res.Status))
+				case FieldError:
+					if handlerErr != nil {
+						enc.str(f, handlerErr.Error())
+					} else {
+						enc.str(f, "")
+					}
+				case FieldLatencyMs:
+					enc.num(f, strconv.FormatFloat(float64(time.Since(start).Microseconds())/1000, 'f', -1, 64))
+				case FieldBytesIn:
+					enc.num(f, strconv.FormatInt(max(req.ContentLength, 0), 10))
+				case FieldBytesOut:
+					enc.num(f, strconv.FormatInt(res.Size, 10))
+				case FieldPrincipal, FieldAuthMethod:
+					v := ""
+					if p := GetPrincipal(c); p != nil {
+						v = p.Id
+						if f == FieldAuthMethod {
+							v = p.Method
+						}
+					}
+					enc.str(f, v)
+				case FieldHeaders:
+					enc.strMap(f, headers.values(req.Header))
+				case FieldQuery:
+					enc.strMap(f, query.values(url.Values(c.QueryParams())))
+				case FieldBodyIn:
+					enc.str(f, truncateBody(bodyIn, bodyInTruncated))
+				case FieldBodyOut:
+					if respBody != nil && isPrintableTextContent(res.Header().Get(echo.HeaderContentType)) {
+						enc.str(f, truncateBody(respBody.Bytes(), res.Size > int64(sc.MaxBody)))
+					} else {
+						enc.str(f, "")
+					}
+				}
+			}
+
+			if sc.Encoding == AccessLogJson {
+				buf.WriteByte('}')
+			}
+			buf.WriteByte('\n')
+			_, err = config.Output.Write(buf.Bytes())
+			return err
+		}
+	}
+}
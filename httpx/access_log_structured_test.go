@@ -0,0 +1,107 @@
+package httpx
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasttemplate"
+)
+
+func TestStructuredAccessLog(t *testing.T) {
+	out := &bytes.Buffer{}
+	e := echo.New()
+	e.Use(LoggerWithConfig(LoggerConfig{
+		OutBodyFilter: func(echo.Context) bool { return true },
+		Output:        out,
+		Structured: StructuredLogConfig{
+			Encoding: AccessLogJson,
+			Fields:   []string{FieldMethod, FieldUri, FieldRoute, FieldStatus, FieldError, FieldHeaders, FieldQuery, FieldBodyIn, FieldBodyOut},
+			Headers:  RedactConfig{Allow: []string{"User-Agent", "Authorization", "X-Note"}},
+			MaxBody:  8,
+			Sampling: []SamplingRule{{StatusMin: 200, StatusMax: 299, Ratio: 0}},
+		},
+	}))
+	e.POST("/v1/echo/:id", func(c echo.Context) error {
+		body, _ := io.ReadAll(c.Request().Body)
+		if c.Param("id") == "fail" {
+			return echo.NewHTTPError(http.StatusBadGateway, "upstream \"down\"")
+		}
+		return c.JSONBlob(http.StatusOK, body)
+	})
+
+	serve := func(target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer secret")
+		req.Header.Set("X-Note", "line1\nline2")
+		req.Header.Set("X-Other", "hidden")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 2xx are not sampled, and the body is restored for the handler
+	rec := serve("/v1/echo/1", `{"name":"abcdefghij"}`)
+	require.Equal(t, `{"name":"abcdefghij"}`, rec.Body.String())
+	require.Empty(t, out.String())
+
+	rec = serve("/v1/echo/fail?token=t0k&page=2", `{"name":"abcdefghij"}`)
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+
+	entry := map[string]any{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entry), out.String())
+	require.Equal(t, "POST", entry[FieldMethod])
+	require.Equal(t, "/v1/echo/fail?page=2&token="+RedactedValue, entry[FieldUri])
+	require.Equal(t, "/v1/echo/:id", entry[FieldRoute])
+	require.EqualValues(t, 502, entry[FieldStatus])
+	require.Contains(t, entry[FieldError], `upstream "down"`)
+	// denied by default even if allowed
+	require.Equal(t, map[string]any{
+		"Authorization": RedactedValue,
+		"Content-Type":  RedactedValue,
+		"X-Note":        "line1\nline2",
+		"X-Other":       RedactedValue,
+	}, entry[FieldHeaders])
+	require.Equal(t, map[string]any{"page": "2", "token": RedactedValue}, entry[FieldQuery])
+	require.Equal(t, `{"name":`+TruncatedMarker, entry[FieldBodyIn])
+	require.Equal(t, `{"messag`+TruncatedMarker, entry[FieldBodyOut])
+}
+
+func TestStructuredAccessLogLogfmt(t *testing.T) {
+	out := &bytes.Buffer{}
+	e := echo.New()
+	e.Use(LoggerWithConfig(LoggerConfig{
+		Output: out,
+		Structured: StructuredLogConfig{
+			Encoding: AccessLogLogfmt,
+			Fields:   []string{FieldMethod, FieldPath, FieldStatus, FieldUserAgent, FieldQuery, FieldBodyOut},
+		},
+	}))
+	e.GET("/ping", func(c echo.Context) error { return c.String(http.StatusOK, "pong") })
+
+	req := httptest.NewRequest(http.MethodGet, "/ping?password=x&q=a+b", nil)
+	req.Header.Set("User-Agent", `agent "v1"`)
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	require.Equal(t, `method=GET path=/ping user_agent="agent \"v1\"" status=200 query.password=REDACTED query.q="a b" body_out=""`+"\n", out.String())
+
+	// names of params can not forge fields
+	out.Reset()
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ping?a%3D1%20status=200", nil))
+	require.Equal(t, `method=GET path=/ping user_agent="" status=200 query.a%3D1%20status=200 body_out=""`+"\n", out.String())
+}
+
+func TestDefaultLoggerFormatIsJson(t *testing.T) {
+	for _, format := range []string{DefaultLoggerConfig.FormatBefore, DefaultLoggerConfig.FormatAfter} {
+		s := fasttemplate.New(format, "${", "}").ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
+			return w.Write([]byte("1"))
+		})
+		require.True(t, json.Valid([]byte(s)), s)
+	}
+}
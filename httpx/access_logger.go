@@ -16,6 +16,7 @@ import (
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	"github.com/labstack/gommon/color"
+	"github.com/madlabx/pkgx/tracex"
 	"github.com/valyala/fasttemplate"
 )
 
@@ -68,6 +69,10 @@ type (
 		// - form:<NAME>
 		// - body_in (request body)
 		// - body_out (response body)   , should also define OutBodyFilter to log only necessary.
+		// - principal (Id of the authenticated Principal)
+		// - auth_method
+		// - trace_id (W3C trace context, see TracingWithConfig)
+		// - span_id
 		//
 		// Example "${remote_ip} ${status}"
 		//
@@ -79,6 +84,10 @@ type (
 		// Optional. Default value DefaultLoggerConfig.CustomTimeFormat.
 		CustomTimeFormat string `yaml:"custom_time_format"`
 
+		// Structured writes one entry of a fixed schema after the handler instead of the templates,
+		// if Structured.Encoding is set. Timing is ignored then
+		Structured StructuredLogConfig `yaml:"structured"`
+
 		// Output is a writer where logs in JSON format are written.
 		// Optional. Default value os.Stdout.
 		Output io.Writer
@@ -98,7 +107,7 @@ var (
 		OutBodyFilter: DefaultOutBodyFilter,
 		FormatBefore: `{"time":"${time_rfc3339_nano}","id":"${id}","remote_ip":"${remote_ip}",` +
 			`"host":"${host}","method":"${method}","uri":"${uri}","user_agent":"${user_agent}",` +
-			`,"bytes_in":${bytes_in}`,
+			`"bytes_in":${bytes_in}}`,
 		FormatAfter: `{"time":"${time_rfc3339_nano}","id":"${id}","remote_ip":"${remote_ip}",` +
 			`"host":"${host}","method":"${method}","uri":"${uri}","user_agent":"${user_agent}",` +
 			`"status":${status},"error":"${error}","latency":${latency},"latency_human":"${latency_human}"` +
@@ -137,6 +146,10 @@ func LoggerWithConfig(config LoggerConfig) echo.MiddlewareFunc {
 		config.FormatAfter = DefaultLoggerConfig.FormatAfter
 	}
 
+	if config.OutBodyFilter == nil {
+		config.OutBodyFilter = DefaultLoggerConfig.OutBodyFilter
+	}
+
 	if config.Output == nil {
 		config.Output = DefaultLoggerConfig.Output
 	}
@@ -166,6 +179,10 @@ func LoggerWithConfig(config LoggerConfig) echo.MiddlewareFunc {
 		},
 	}
 
+	if config.Structured.Encoding != "" {
+		return structuredLogger(config)
+	}
+
 	loggingRequestBody := func(c echo.Context, bytesIn int64) string {
 		if bytesIn > 0 && bytesIn <= config.bodyBufferSize &&
 			isPrintableTextContent(c.Request().Header.Get(echo.HeaderContentType)) {
@@ -272,6 +289,26 @@ func LoggerWithConfig(config LoggerConfig) echo.MiddlewareFunc {
 					return buf.WriteString(strconv.FormatInt(res.Size, 10))
 				case "body_out":
 					return buf.WriteString(loggingResponseBody(c, doPrintBodyOut, res.Size, respBody.Bytes()))
+				case "principal":
+					if p := GetPrincipal(c); p != nil {
+						return buf.WriteString(p.Id)
+					}
+					return 0, nil
+				case "auth_method":
+					if p := GetPrincipal(c); p != nil {
+						return buf.WriteString(p.Method)
+					}
+					return 0, nil
+				case "trace_id":
+					if sc := tracex.SpanContextFromContext(c.Request().Context()); sc.IsValid() {
+						return buf.WriteString(sc.TraceId.String())
+					}
+					return 0, nil
+				case "span_id":
+					if sc := tracex.SpanContextFromContext(c.Request().Context()); sc.IsValid() {
+						return buf.WriteString(sc.SpanId.String())
+					}
+					return 0, nil
 				case "status":
 					n := res.Status
 					s := config.colorer.Green(n)
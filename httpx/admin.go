@@ -0,0 +1,266 @@
+package httpx
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/madlabx/pkgx/buildcontext"
+	"github.com/madlabx/pkgx/log"
+)
+
+// AdminConfig admin routes of ApiGateway under Prefix:
+//
+//   - GET  /healthz    liveness
+//   - GET  /readyz     readiness, aggregated from ReadyCheckers
+//   - GET  /version    buildcontext.BuildInfo
+//   - GET  /routes     routes in JSON
+//   - GET  /loglevel   levels of the main and access loggers
+//   - PUT  /loglevel   switch level by {"logger":"main","level":"debug"}
+//   - GET  /metrics    expvar in JSON
+//   - GET  /debug/pprof/*
+//
+// Serve them on an internal listener by ListenerConfig.Prefixes, or protect them by Middlewares.
+// PUT /loglevel is mounted only if either is done
+type AdminConfig struct {
+	Prefix       string                `vx_default:"/admin"`
+	Pprof        bool                  `vx_default:"false"`
+	Expvar       bool                  `vx_default:"false"`
+	LogLevel     bool                  `vx_default:"false"` // allow switching log level
+	CheckTimeout int                   `vx_default:"3"`     //in sec, timeout of each ReadyChecker
+	Middlewares  []echo.MiddlewareFunc `json:"-"`
+}
+
+// ReadyChecker returns nil if the dependency is ready, e.g. dbc.DbClient.Ping, redis.Client.Ping
+type ReadyChecker func(ctx context.Context) error
+
+type readyCheck struct {
+	name    string
+	checker ReadyChecker
+}
+
+type ReadyCheckResult struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Latency int64  `json:"latency_ms"`
+}
+
+type ReadyResult struct {
+	Status string                      `json:"status"`
+	Checks map[string]ReadyCheckResult `json:"checks,omitempty"`
+}
+
+const (
+	StatusOk   = "ok"
+	StatusFail = "fail"
+)
+
+var startTime = time.Now()
+
+// EnableAdmin registers the admin route group at Run, not support dynamic change
+func (agw *ApiGateway) EnableAdmin(conf AdminConfig) {
+	agw.admin = &conf
+}
+
+// AddReadyChecker adds a checker of /readyz, checkers run concurrently
+func (agw *ApiGateway) AddReadyChecker(name string, checker ReadyChecker) {
+	agw.readyMutex.Lock()
+	defer agw.readyMutex.Unlock()
+	agw.readyChecks = append(agw.readyChecks, readyCheck{name: name, checker: checker})
+}
+
+// CheckReady runs all ready checkers
+func (agw *ApiGateway) CheckReady(ctx context.Context, timeout time.Duration) *ReadyResult {
+	agw.readyMutex.Lock()
+	checks := append([]readyCheck(nil), agw.readyChecks...)
+	agw.readyMutex.Unlock()
+
+	result := &ReadyResult{Status: StatusOk, Checks: make(map[string]ReadyCheckResult, len(checks))}
+	if agw.IsDraining() {
+		result.Status = StatusFail
+		result.Checks["drain"] = ReadyCheckResult{Status: StatusFail, Error: "draining"}
+	}
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+	)
+	for _, rc := range checks {
+		wg.Add(1)
+		go func(rc readyCheck) {
+			defer wg.Done()
+
+			cctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := rc.checker(cctx)
+
+			r := ReadyCheckResult{Status: StatusOk, Latency: time.Since(start).Milliseconds()}
+			if err != nil {
+				r.Status, r.Error = StatusFail, err.Error()
+			}
+			mutex.Lock()
+			result.Checks[rc.name] = r
+			if err != nil {
+				result.Status = StatusFail
+			}
+			mutex.Unlock()
+		}(rc)
+	}
+	wg.Wait()
+
+	return result
+}
+
+type RouteInfo struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Name   string `json:"name"`
+}
+
+// RoutesInfo routes sorted by path and method
+func (agw *ApiGateway) RoutesInfo() []RouteInfo {
+	routes := agw.Echo.Routes()
+	infos := make([]RouteInfo, 0, len(routes))
+	for _, r := range routes {
+		infos = append(infos, RouteInfo{Method: r.Method, Path: r.Path, Name: r.Name})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Path != infos[j].Path {
+			return infos[i].Path < infos[j].Path
+		}
+		return infos[i].Method < infos[j].Method
+	})
+	return infos
+}
+
+var publishOnce sync.Once
+
+func publishExpvar() {
+	publishOnce.Do(func() {
+		expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+		expvar.Publish("uptime_sec", expvar.Func(func() any { return int64(time.Since(startTime).Seconds()) }))
+		expvar.Publish("build", expvar.Func(func() any { return buildcontext.Get() }))
+	})
+}
+
+func (agw *ApiGateway) registerAdmin() {
+	conf := agw.admin
+	if conf.Prefix == "" {
+		conf.Prefix = "/admin"
+	}
+	if conf.CheckTimeout <= 0 {
+		conf.CheckTimeout = 3
+	}
+
+	g := agw.Echo.Group(conf.Prefix, conf.Middlewares...)
+	g.GET("/healthz", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": StatusOk})
+	})
+	g.GET("/readyz", func(c echo.Context) error {
+		result := agw.CheckReady(c.Request().Context(), time.Duration(conf.CheckTimeout)*time.Second)
+		if result.Status != StatusOk {
+			return c.JSON(http.StatusServiceUnavailable, result)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+	g.GET("/version", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, buildcontext.Get())
+	})
+	g.GET("/routes", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, agw.RoutesInfo())
+	})
+
+	g.GET("/loglevel", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{
+			"main":   log.GetLevel().String(),
+			"access": agw.Logger.GetLevel().String(),
+		})
+	})
+	if conf.LogLevel {
+		if len(conf.Middlewares) > 0 || agw.dedicatedListener(conf.Prefix) {
+			g.PUT("/loglevel", agw.handleSetLogLevel)
+		} else {
+			log.Warnf("PUT %v/loglevel is not mounted, serve admin on a dedicated listener or protect it by Middlewares", conf.Prefix)
+		}
+	}
+
+	if conf.Expvar {
+		publishExpvar()
+		g.GET("/metrics", echo.WrapHandler(expvar.Handler()))
+	}
+
+	if conf.Pprof {
+		g.GET("/debug/pprof/", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+		g.GET("/debug/pprof/cmdline", echo.WrapHandler(http.HandlerFunc(pprof.Cmdline)))
+		g.GET("/debug/pprof/profile", echo.WrapHandler(http.HandlerFunc(pprof.Profile)))
+		g.GET("/debug/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+		g.POST("/debug/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+		g.GET("/debug/pprof/trace", echo.WrapHandler(http.HandlerFunc(pprof.Trace)))
+		// pprof.Index serves named profiles by the last element of path
+		g.GET("/debug/pprof/:name", func(c echo.Context) error {
+			pprof.Handler(c.Param("name")).ServeHTTP(c.Response(), c.Request())
+			return nil
+		})
+	}
+}
+
+// dedicatedListener whether prefix is served only by listeners limited by Prefixes,
+// a listener without Prefixes, or the default one added by Run, serves all paths
+func (agw *ApiGateway) dedicatedListener(prefix string) bool {
+	served := false
+	for _, l := range agw.listeners {
+		if len(l.conf.Prefixes) == 0 {
+			return false
+		}
+		for _, p := range l.conf.Prefixes {
+			p = strings.TrimSuffix(p, "/")
+			if prefix == p || strings.HasPrefix(prefix, p+"/") {
+				served = true
+			}
+		}
+	}
+	return served
+}
+
+type logLevelReq struct {
+	Logger string `json:"logger"` // main or access, main by default
+	Level  string `json:"level"`
+}
+
+func (agw *ApiGateway) handleSetLogLevel(c echo.Context) error {
+	req := &logLevelReq{}
+	if err := c.Bind(req); err != nil {
+		return SendResp(c, echo.NewHTTPError(http.StatusBadRequest, err.Error()))
+	}
+
+	if req.Logger == "" {
+		req.Logger = "main"
+	}
+	var (
+		level string
+		err   error
+	)
+	switch req.Logger {
+	case "main":
+		err = log.SetLevelStr(req.Level)
+		level = log.GetLevel().String()
+	case "access":
+		err = log.SetLoggerLevel(agw.Logger, req.Level)
+		level = agw.Logger.GetLevel().String()
+	default:
+		return SendResp(c, echo.NewHTTPError(http.StatusBadRequest, "unknown logger "+req.Logger))
+	}
+	if err != nil {
+		return SendResp(c, echo.NewHTTPError(http.StatusBadRequest, err.Error()))
+	}
+
+	log.Warnf("Log level of %v logger is switched to %v by %v", req.Logger, level, GetRealIp(c.Request()))
+	return c.JSON(http.StatusOK, map[string]string{"logger": req.Logger, "level": level})
+}
@@ -0,0 +1,127 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/madlabx/pkgx/buildcontext"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/log"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func newAdminGateway(t *testing.T) *ApiGateway {
+	agw, err := NewApiGateway(context.Background(), "", "", "test", &LogConfig{Level: "error"}, nil)
+	require.Nil(t, err)
+	guard := func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	agw.EnableAdmin(AdminConfig{Prefix: "/admin", Pprof: true, Expvar: true, LogLevel: true, Middlewares: []echo.MiddlewareFunc{guard}})
+	agw.GET("/v1/users/:id", func(c echo.Context) error { return nil })
+	agw.registerAdmin()
+	return agw
+}
+
+func serveAdmin(agw *ApiGateway, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	agw.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminReadyz(t *testing.T) {
+	agw := newAdminGateway(t)
+
+	require.Equal(t, http.StatusOK, serveAdmin(agw, http.MethodGet, "/admin/healthz", "").Code)
+	rec := serveAdmin(agw, http.MethodGet, "/admin/readyz", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	agw.AddReadyChecker("db", func(ctx context.Context) error { return nil })
+	agw.AddReadyChecker("redis", func(ctx context.Context) error { return errors.New("connection refused") })
+	agw.AddReadyChecker("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	agw.admin.CheckTimeout = 1
+
+	rec = serveAdmin(agw, http.MethodGet, "/admin/readyz", "")
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	result := &ReadyResult{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), result))
+	require.Equal(t, StatusFail, result.Status)
+	require.Equal(t, StatusOk, result.Checks["db"].Status)
+	require.Equal(t, "connection refused", result.Checks["redis"].Error)
+	require.Equal(t, StatusFail, result.Checks["slow"].Status)
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	agw := newAdminGateway(t)
+
+	rec := serveAdmin(agw, http.MethodGet, "/admin/version", "")
+	bi := &buildcontext.BuildInfo{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), bi))
+	require.Equal(t, buildcontext.Version, bi.Version)
+
+	rec = serveAdmin(agw, http.MethodGet, "/admin/routes", "")
+	var routes []RouteInfo
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &routes))
+	paths := map[string]string{}
+	for _, r := range routes {
+		paths[r.Path] = r.Method
+	}
+	require.Equal(t, http.MethodGet, paths["/v1/users/:id"])
+	require.Equal(t, http.MethodGet, paths["/admin/healthz"])
+
+	defer logrus.SetLevel(logrus.GetLevel())
+	rec = serveAdmin(agw, http.MethodPut, "/admin/loglevel", `{"level":"debug"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+	rec = serveAdmin(agw, http.MethodPut, "/admin/loglevel", `{"logger":"access","level":"warn"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, logrus.WarnLevel, agw.Logger.GetLevel())
+	rec = serveAdmin(agw, http.MethodPut, "/admin/loglevel", `{"level":"loud"}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serveAdmin(agw, http.MethodGet, "/admin/metrics", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"goroutines"`)
+
+	rec = serveAdmin(agw, http.MethodGet, "/admin/debug/pprof/goroutine?debug=1", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "goroutine profile")
+}
+
+func TestAdminLogLevelUnprotected(t *testing.T) {
+	newGateway := func(listeners ...ListenerConfig) *ApiGateway {
+		agw, err := NewApiGateway(context.Background(), "", "", "test", &LogConfig{Level: "error"}, nil)
+		require.Nil(t, err)
+		for _, l := range listeners {
+			require.Nil(t, agw.AddListener(l))
+		}
+		agw.EnableAdmin(AdminConfig{LogLevel: true})
+		agw.registerAdmin()
+		return agw
+	}
+
+	// served by the default listener
+	agw := newGateway()
+	require.Equal(t, http.StatusMethodNotAllowed, serveAdmin(agw, http.MethodPut, "/admin/loglevel", `{"level":"warn"}`).Code)
+	require.Equal(t, http.StatusOK, serveAdmin(agw, http.MethodGet, "/admin/loglevel", "").Code)
+	require.Equal(t, http.StatusNotFound, serveAdmin(agw, http.MethodGet, "/admin/metrics", "").Code)
+
+	// also served by a public listener
+	agw = newGateway(ListenerConfig{Name: "public", Addr: ":0"}, ListenerConfig{Name: "admin", Addr: ":0", Prefixes: []string{"/admin"}})
+	require.Equal(t, http.StatusMethodNotAllowed, serveAdmin(agw, http.MethodPut, "/admin/loglevel", `{"level":"warn"}`).Code)
+
+	defer log.SetLevel(log.GetLevel())
+	agw = newGateway(ListenerConfig{Name: "public", Addr: ":0", Prefixes: []string{"/api"}}, ListenerConfig{Name: "admin", Addr: ":0", Prefixes: []string{"/admin/"}})
+	rec := serveAdmin(agw, http.MethodPut, "/admin/loglevel", `{"level":"warn"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"warning"`)
+	require.Equal(t, logrus.WarnLevel, log.GetLevel())
+}
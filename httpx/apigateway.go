@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
@@ -52,16 +52,23 @@ type LogConfig struct {
 	// - form:<NAME>
 	// - body_in (request body)
 	// - body_out (response body)
+	// - principal (Id of the authenticated Principal)
+	// - auth_method
+	// - trace_id
+	// - span_id
 	//ContentFormatBefore string `vx_default:"${time_custom} BEF ${method} ${uri} ${host} ${remote_ip} ${bytes_in}"`
 	ContentFormatBefore string
 	//ContentFormatAfter  string `vx_default:"${time_custom} AFT ${status} ${method} ${latency_human} ${uri} ${host} ${remote_ip} ${bytes_in} ${bytes_out} ${error}"`
 	ContentFormatAfter string
+	// Structured replaces the ContentFormat templates by JSON or logfmt entries if Structured.Encoding is set
+	Structured StructuredLogConfig
 }
 
 type HandlerOnIdempotentErrFunc func(c echo.Context, requestId string) error
 
 type ApiGateway struct {
-	ctx context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
 	*echo.Echo
 	addr                     string
 	port                     string
@@ -76,20 +83,40 @@ type ApiGateway struct {
 	idempotentNameHeader     string
 	idempotentNameQueryParam string
 	idempotentKeyCache       *memkv.Cache
+	idempotentSnapshot       memkv.SnapshotConf
+	idempotency              *IdempotencyConfig
+	security                 echo.MiddlewareFunc
+	auth                     *AuthConfig
+	admin                    *AdminConfig
+	metrics                  *HttpMetrics
+	tracing                  *TracingConfig
+	limits                   *LimitsConfig
+	compress                 *CompressConfig
+	timeouts                 TimeoutConfig
+	drainConf                DrainConfig
+	tracker                  *connTracker
+	readyMutex               sync.Mutex
+	readyChecks              []readyCheck
+	listenMutex              sync.Mutex
+	listeners                []*listener
 	onIdempotenceCheckError  HandlerOnIdempotentErrFunc
 }
 
 func NewApiGateway(pCtx context.Context, addr, port, name string, lc *LogConfig, logFormat logrus.Formatter) (*ApiGateway, error) {
-	ctx, _ :=context.WithCancel(pCtx),
+	ctx, cancel := context.WithCancel(pCtx)
 	agw := &ApiGateway{
 		addr:         addr,
 		port:         port,
 		name:         name,
 		ctx:          ctx,
+		cancel:       cancel,
 		Echo:         echo.New(),
 		LogConf:      lc,
 		EntryFormat:  logFormat,
 		isIdempotent: false,
+		timeouts:     DefaultTimeoutConfig,
+		drainConf:    DefaultDrainConfig,
+		tracker:      newConnTracker(),
 	}
 
 	//if lc == nil, log to log.StandardLogger
@@ -109,6 +136,33 @@ func (agw *ApiGateway) EnableIdempotentCheck(idempotentNameInHeader, idempotentN
 	agw.onIdempotenceCheckError = fn
 }
 
+// EnableIdempotency replays responses to retries with the same idempotency key, see IdempotencyWithConfig.
+// Entries are kept in memkv if conf.Store is nil, not support dynamic change
+func (agw *ApiGateway) EnableIdempotency(conf IdempotencyConfig) {
+	agw.idempotency = &conf
+}
+
+// SetIdempotentSnapshot keeps idempotent keys across restarts by a snapshot file, must be called before Run
+func (agw *ApiGateway) SetIdempotentSnapshot(conf memkv.SnapshotConf) {
+	agw.idempotentSnapshot = conf
+}
+
+// EnableAuth authenticates all requests after the access log, so that the principal is logged.
+// Use AuthWithConfig on route groups instead for different auth per group
+func (agw *ApiGateway) EnableAuth(conf AuthConfig) {
+	agw.auth = &conf
+}
+
+// SetSecurity replaces DefaultSecurityConfig, must be called before Run
+func (agw *ApiGateway) SetSecurity(conf SecurityConfig) error {
+	m, err := SecurityWithConfig(conf)
+	if err != nil {
+		return err
+	}
+	agw.security = m
+	return nil
+}
+
 func (agw *ApiGateway) SetLoggerSkipper(s middleware.Skipper) {
 	agw.loggerSkipper = s
 }
@@ -122,20 +176,37 @@ func (agw *ApiGateway) Name() string {
 }
 
 func (agw *ApiGateway) enableIdempotence() {
-	agw.idempotentKeyCache = memkv.NewCache(agw.ctx, nil, memkv.CacheConf{})
+	agw.idempotentKeyCache = memkv.NewCache(agw.ctx, nil, memkv.CacheConf{Snapshot: agw.idempotentSnapshot})
 }
 
 func (agw *ApiGateway) Run() error {
-	if agw.isIdempotent {
+	if agw.isIdempotent || (agw.idempotency != nil && agw.idempotency.Store == nil) {
 		agw.enableIdempotence()
 	}
+	if agw.idempotency != nil && agw.idempotency.Store == nil {
+		agw.idempotency.Store = NewMemkvIdempotencyStore(agw.idempotentKeyCache)
+	}
+
+	if agw.admin != nil {
+		agw.registerAdmin()
+	}
+	if agw.metrics != nil {
+		agw.GET(agw.metrics.conf.Path, agw.metrics.Handler())
+	}
 
 	agw.configEcho()
 	return agw.startEcho(fmt.Sprintf("%s:%s", agw.addr, agw.port))
 }
 
+// Stop drains requests by DrainConfig, see SetDrain
 func (agw *ApiGateway) Stop() error {
-	return agw.shutdownEcho()
+	err := agw.shutdownEcho()
+	if agw.idempotentKeyCache != nil {
+		// save the last snapshot if enabled
+		log.IgnoreErrf(agw.idempotentKeyCache.Stop(), "stop idempotent key cache")
+	}
+	agw.cancel()
+	return err
 }
 
 func (agw *ApiGateway) initAccessLog() error {
@@ -220,6 +291,25 @@ func (agw *ApiGateway) configEcho() {
 		bodyFilter = agw.bodyLoggerSkipper
 	}
 
+	// outermost, so that latency covers all middlewares
+	if agw.metrics != nil {
+		e.Use(agw.metrics.Middleware())
+	}
+
+	// before the access log, so that trace ids are logged
+	if agw.tracing != nil {
+		e.Use(TracingWithConfig(*agw.tracing))
+	}
+
+	// before the access log and anything reading bodies
+	if agw.limits != nil {
+		e.Use(LimitsWithConfig(*agw.limits))
+	}
+	// before the access log, so that body_out is logged uncompressed
+	if agw.compress != nil {
+		e.Use(CompressWithConfig(*agw.compress))
+	}
+
 	if agw.isIdempotent {
 		e.Use(agw.idempotenceCheck)
 	}
@@ -233,34 +323,37 @@ func (agw *ApiGateway) configEcho() {
 		bodyBufferSize:   agw.LogConf.BodyBufferSize,
 		Timing:           agw.LogConf.Timing,
 		Skipper:          agw.loggerSkipper,
+		Structured:       agw.LogConf.Structured,
 	}))
 
-	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins:     []string{"*"},
-		ExposeHeaders:    []string{"*"},
-		AllowMethods:     []string{"*"},
-		AllowHeaders:     []string{"*"},
-		AllowCredentials: true,
-		//AllowMethods: []string{Echo.GET, Echo.PUT, Echo.POST, Echo.DELETE},
-	}))
+	// before auth, so that preflight requests are answered without credentials
+	if agw.security == nil {
+		agw.security, _ = SecurityWithConfig(DefaultSecurityConfig)
+	}
+	e.Use(agw.security)
+
+	if agw.auth != nil {
+		e.Use(AuthWithConfig(*agw.auth))
+	}
 
-	//TODO 检查是否可以恢复。不注释回无法下载css
-	//e.Use(func(next Echo.HandlerFunc) Echo.HandlerFunc {
-	//	return func(c Echo.Context) error {
-	//		c.Response().Header().Set("Content-Security-Policy", `default-src 'self'; style-src 'unsafe-inline';`)
-	//		return next(c)
-	//	}
-	//})
+	// after the access log, so that replays are logged
+	if agw.idempotency != nil {
+		e.Use(IdempotencyWithConfig(*agw.idempotency))
+	}
 }
 
 func (agw *ApiGateway) startEcho(addr string) error {
-	return agw.Echo.Start(addr)
+	if len(agw.listeners) == 0 {
+		if err := agw.AddListener(ListenerConfig{Name: "default", Addr: addr}); err != nil {
+			return err
+		}
+	}
+	return agw.serve()
 }
 
 func (agw *ApiGateway) shutdownEcho() error {
-	ctx, cancel := context.WithTimeout(agw.ctx, 5*time.Second)
-	defer cancel()
-	return agw.Echo.Shutdown(ctx)
+	_, err := agw.drain()
+	return err
 }
 
 func (agw *ApiGateway) RoutesToString() string {
@@ -0,0 +1,107 @@
+package httpx
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/log"
+)
+
+const (
+	ContextKeyPrincipal = "principal"
+
+	AuthMethodJwt    = "jwt"
+	AuthMethodApiKey = "apikey"
+	AuthMethodHmac   = "hmac"
+)
+
+// Principal the authenticated caller
+type Principal struct {
+	Id       string // sub of jwt, owner of api key, or owner of hmac access key
+	Method   string // AuthMethodJwt, AuthMethodApiKey or AuthMethodHmac
+	ClientId string
+	Scopes   []string
+	Claims   map[string]any // claims of jwt
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPrincipal returns nil if not authenticated
+func GetPrincipal(c echo.Context) *Principal {
+	p, _ := c.Get(ContextKeyPrincipal).(*Principal)
+	return p
+}
+
+func SetPrincipal(c echo.Context, p *Principal) {
+	c.Set(ContextKeyPrincipal, p)
+}
+
+// Authenticator verifies credentials of the request.
+// It returns ErrNoCredentials if the request carries no credentials of its kind, so that the next one is tried
+type Authenticator interface {
+	Authenticate(c echo.Context) (*Principal, error)
+}
+
+type AuthenticatorFunc func(c echo.Context) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(c echo.Context) (*Principal, error) { return f(c) }
+
+type AuthConfig struct {
+	Skipper        middleware.Skipper
+	Authenticators []Authenticator // tried in order
+	// Optional lets requests without any credentials pass without principal, invalid credentials are still rejected
+	Optional bool
+	// OnError renders the failure, 401 by default
+	OnError func(c echo.Context, err error) error
+}
+
+// AuthWithConfig stores the principal in echo context, see GetPrincipal
+func AuthWithConfig(conf AuthConfig) echo.MiddlewareFunc {
+	if len(conf.Authenticators) == 0 {
+		panic("httpx: auth middleware requires authenticators")
+	}
+	if conf.Skipper == nil {
+		conf.Skipper = middleware.DefaultSkipper
+	}
+	if conf.OnError == nil {
+		conf.OnError = func(c echo.Context, err error) error {
+			return SendResp(c, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized"))
+		}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if conf.Skipper(c) {
+				return next(c)
+			}
+
+			for _, a := range conf.Authenticators {
+				p, err := a.Authenticate(c)
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				}
+				if err != nil {
+					log.Debugf("Failed to authenticate, uri:%v, err:%v", c.Request().RequestURI, err)
+					return conf.OnError(c, err)
+				}
+
+				SetPrincipal(c, p)
+				return next(c)
+			}
+
+			if conf.Optional {
+				return next(c)
+			}
+			return conf.OnError(c, ErrNoCredentials)
+		}
+	}
+}
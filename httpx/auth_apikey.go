@@ -0,0 +1,58 @@
+package httpx
+
+import (
+	"crypto/sha256"
+
+	"github.com/labstack/echo"
+	"github.com/madlabx/pkgx/errors"
+)
+
+type ApiKey struct {
+	Key    string `json:"-"`
+	Id     string // owner of the key, Principal.Id
+	Scopes []string
+}
+
+type ApiKeyConfig struct {
+	Header     string `vx_default:"X-Api-Key"`
+	QueryParam string
+	Keys       []ApiKey
+}
+
+type apiKeyAuthenticator struct {
+	conf ApiKeyConfig
+	keys map[[sha256.Size]byte]*ApiKey
+}
+
+// NewApiKeyAuthenticator static api keys, keys are looked up by their hash to not leak by timing
+func NewApiKeyAuthenticator(conf ApiKeyConfig) (Authenticator, error) {
+	if conf.Header == "" {
+		conf.Header = "X-Api-Key"
+	}
+
+	a := &apiKeyAuthenticator{conf: conf, keys: make(map[[sha256.Size]byte]*ApiKey, len(conf.Keys))}
+	for i := range conf.Keys {
+		k := &conf.Keys[i]
+		if k.Key == "" {
+			return nil, errors.Errorf("empty api key of %q", k.Id)
+		}
+		a.keys[sha256.Sum256([]byte(k.Key))] = k
+	}
+	return a, nil
+}
+
+func (a *apiKeyAuthenticator) Authenticate(c echo.Context) (*Principal, error) {
+	key := c.Request().Header.Get(a.conf.Header)
+	if key == "" && a.conf.QueryParam != "" {
+		key = c.QueryParam(a.conf.QueryParam)
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	k, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, errors.Wrapf(ErrInvalidCredentials, "unknown api key")
+	}
+	return &Principal{Id: k.Id, Method: AuthMethodApiKey, Scopes: k.Scopes}, nil
+}
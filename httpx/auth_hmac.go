@@ -0,0 +1,222 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/memkv"
+	"github.com/madlabx/pkgx/utils"
+)
+
+// names of hmac parameters, carried by query params, or by headers X-Access-Key, X-Timestamp, X-Nonce and X-Sign
+const (
+	HmacParamAccessKey = "AccessKey"
+	HmacParamTimestamp = "Timestamp" // unix seconds
+	HmacParamNonce     = "Nonce"
+	HmacParamBodyHash  = "BodyHash" // hex of sha256 of the body, set if the body is not empty
+	HmacParamSign      = "Sign"     // hex of hmac-sha256 of the sign string
+
+	HeaderHmacAccessKey = "X-Access-Key"
+	HeaderHmacTimestamp = "X-Timestamp"
+	HeaderHmacNonce     = "X-Nonce"
+	HeaderHmacSign      = "X-Sign"
+)
+
+// NonceStore remembers nonces within ttl, IdempotencyStore implementations are usable as well
+type NonceStore interface {
+	SetNX(key string, value []byte, ttl time.Duration) (bool, error)
+}
+
+type HmacCredential struct {
+	AccessKey string
+	Secret    string `json:"-"`
+	Id        string // owner of the access key, Principal.Id
+	Scopes    []string
+}
+
+type HmacConfig struct {
+	Credentials []HmacCredential
+	// Lookup finds credentials not in Credentials, e.g. from db, returns nil if not found
+	Lookup  func(accessKey string) (*HmacCredential, error) `json:"-"`
+	MaxSkew int                                             `vx_default:"300"`      //in sec, max difference between Timestamp and now
+	MaxBody int64                                           `vx_default:"10485760"` //in bytes, larger bodies are rejected
+	// Nonces kept for 2*MaxSkew, in memkv by default, use redis to share among instances
+	Nonces NonceStore `json:"-"`
+}
+
+type hmacAuthenticator struct {
+	conf        HmacConfig
+	credentials map[string]*HmacCredential
+}
+
+// NewHmacAuthenticator verifies requests signed by SignHmacRequest or HmacSign.
+// The sign string is "METHOD\nPATH\n" followed by utils.BuildSignStringForQueryParams of the query params
+// and hmac parameters except Sign, so that a request is bound to its method, path, query and body
+func NewHmacAuthenticator(ctx context.Context, conf HmacConfig) (Authenticator, error) {
+	if conf.MaxSkew <= 0 {
+		conf.MaxSkew = 300
+	}
+	if conf.MaxBody <= 0 {
+		conf.MaxBody = 10 << 20
+	}
+	if conf.Nonces == nil {
+		conf.Nonces = NewMemkvIdempotencyStore(memkv.NewCache(ctx, nil, memkv.CacheConf{}))
+	}
+
+	a := &hmacAuthenticator{conf: conf, credentials: make(map[string]*HmacCredential, len(conf.Credentials))}
+	for i := range conf.Credentials {
+		cred := &conf.Credentials[i]
+		if cred.AccessKey == "" || cred.Secret == "" {
+			return nil, errors.Errorf("empty access key or secret of %q", cred.Id)
+		}
+		a.credentials[cred.AccessKey] = cred
+	}
+	return a, nil
+}
+
+// hmacParams merges hmac parameters in headers into query params
+func hmacParams(req *http.Request) url.Values {
+	params := req.URL.Query()
+	for name, header := range map[string]string{
+		HmacParamAccessKey: HeaderHmacAccessKey,
+		HmacParamTimestamp: HeaderHmacTimestamp,
+		HmacParamNonce:     HeaderHmacNonce,
+		HmacParamSign:      HeaderHmacSign,
+	} {
+		if v := req.Header.Get(header); v != "" {
+			params.Set(name, v)
+		}
+	}
+	return params
+}
+
+func hmacSignString(method, path string, params map[string][]string) string {
+	return method + "\n" + path + "\n" + utils.BuildSignStringForQueryParams(params)
+}
+
+func hmacHex(secret, s string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (a *hmacAuthenticator) credential(accessKey string) (*HmacCredential, error) {
+	if cred, ok := a.credentials[accessKey]; ok {
+		return cred, nil
+	}
+	if a.conf.Lookup != nil {
+		return a.conf.Lookup(accessKey)
+	}
+	return nil, nil
+}
+
+func (a *hmacAuthenticator) Authenticate(c echo.Context) (*Principal, error) {
+	req := c.Request()
+	params := hmacParams(req)
+	accessKey, sign := params.Get(HmacParamAccessKey), params.Get(HmacParamSign)
+	if accessKey == "" || sign == "" {
+		return nil, ErrNoCredentials
+	}
+
+	ts, err := strconv.ParseInt(params.Get(HmacParamTimestamp), 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidCredentials, "invalid timestamp")
+	}
+	if skew := time.Now().Unix() - ts; skew > int64(a.conf.MaxSkew) || -skew > int64(a.conf.MaxSkew) {
+		return nil, errors.Wrapf(ErrInvalidCredentials, "timestamp out of range, skew:%vs", skew)
+	}
+	nonce := params.Get(HmacParamNonce)
+	if nonce == "" {
+		return nil, errors.Wrapf(ErrInvalidCredentials, "missing nonce")
+	}
+
+	cred, err := a.credential(accessKey)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	if cred == nil {
+		return nil, errors.Wrapf(ErrInvalidCredentials, "unknown access key:%v", accessKey)
+	}
+
+	if req.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(req.Body, a.conf.MaxBody+1))
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		if int64(len(body)) > a.conf.MaxBody {
+			return nil, errors.Wrapf(ErrInvalidCredentials, "body too large to verify")
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		if len(body) > 0 {
+			sum := sha256.Sum256(body)
+			params.Set(HmacParamBodyHash, hex.EncodeToString(sum[:]))
+		}
+	}
+
+	expected := hmacHex(cred.Secret, hmacSignString(req.Method, req.URL.Path, params))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(sign))) {
+		return nil, errors.Wrapf(ErrInvalidCredentials, "signature mismatch")
+	}
+
+	// only verified requests consume nonces, so that forged requests can not block the real one
+	fresh, err := a.conf.Nonces.SetNX("nonce_"+accessKey+"_"+nonce, []byte{}, 2*time.Duration(a.conf.MaxSkew)*time.Second)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	if !fresh {
+		return nil, errors.Wrapf(ErrReplayedRequest, "nonce:%v", nonce)
+	}
+
+	return &Principal{Id: cred.Id, Method: AuthMethodHmac, ClientId: accessKey, Scopes: cred.Scopes}, nil
+}
+
+// SignHmacRequest sets hmac headers of req, the body is read and restored
+func SignHmacRequest(req *http.Request, accessKey, secret string) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return errors.Wrap(err)
+	}
+
+	params := req.URL.Query()
+	params.Set(HmacParamAccessKey, accessKey)
+	params.Set(HmacParamTimestamp, strconv.FormatInt(time.Now().Unix(), 10))
+	params.Set(HmacParamNonce, hex.EncodeToString(nonce))
+
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		if len(body) > 0 {
+			sum := sha256.Sum256(body)
+			params.Set(HmacParamBodyHash, hex.EncodeToString(sum[:]))
+		}
+	}
+
+	req.Header.Set(HeaderHmacAccessKey, accessKey)
+	req.Header.Set(HeaderHmacTimestamp, params.Get(HmacParamTimestamp))
+	req.Header.Set(HeaderHmacNonce, params.Get(HmacParamNonce))
+	req.Header.Set(HeaderHmacSign, hmacHex(secret, hmacSignString(req.Method, req.URL.Path, params)))
+	return nil
+}
+
+// HmacSign signs a struct of query params by utils.GetSignString, for requests without body.
+// r should contain AccessKey, Timestamp and Nonce, the result is sent as Sign
+func HmacSign(secret, method, path string, r any) string {
+	return hmacHex(secret, method+"\n"+path+"\n"+utils.GetSignString(r))
+}
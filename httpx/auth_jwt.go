@@ -0,0 +1,422 @@
+package httpx
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/log"
+)
+
+type JwtConfig struct {
+	// Algorithms allowed, derived from the keys configured if empty
+	Algorithms []string
+	// Secret of HS256/HS384/HS512
+	Secret string `json:"-"`
+	// PublicKeyFile PEM of a RSA or EC public key, used for tokens without kid
+	PublicKeyFile string
+	// JwksFile or JwksUrl provides keys by kid
+	JwksFile    string
+	JwksUrl     string
+	JwksRefresh int    `vx_default:"300"` //in sec, unknown kid triggers a refresh at most every 10s as well
+	Issuer      string // checked if not empty
+	Audience    string // checked if not empty
+	Leeway      int    `vx_default:"60"`    //in sec, tolerance of exp and nbf, 0 uses 60, negative no tolerance
+	AllowNoExp  bool   `vx_default:"false"` // accept tokens without exp, which never expire
+	// Header carries "Bearer <token>"
+	Header        string `vx_default:"Authorization"`
+	QueryParam    string
+	SubjectClaim  string `vx_default:"sub"`
+	ClientIdClaim string `vx_default:"client_id"`
+	ScopeClaim    string `vx_default:"scope"` // space separated string or array
+}
+
+type jwtAuthenticator struct {
+	conf      JwtConfig
+	parser    *jwt.Parser
+	publicKey any
+	jwks      *jwksCache
+}
+
+// NewJwtAuthenticator keys of JwksUrl are refreshed until ctx is done
+func NewJwtAuthenticator(ctx context.Context, conf JwtConfig) (Authenticator, error) {
+	if conf.Header == "" {
+		conf.Header = echo.HeaderAuthorization
+	}
+	if conf.SubjectClaim == "" {
+		conf.SubjectClaim = "sub"
+	}
+	if conf.ClientIdClaim == "" {
+		conf.ClientIdClaim = "client_id"
+	}
+	if conf.ScopeClaim == "" {
+		conf.ScopeClaim = "scope"
+	}
+	if conf.Leeway == 0 {
+		conf.Leeway = 60
+	}
+	conf.Leeway = max(conf.Leeway, 0)
+
+	a := &jwtAuthenticator{conf: conf}
+	if conf.PublicKeyFile != "" {
+		data, err := os.ReadFile(conf.PublicKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		if a.publicKey, err = parsePublicKeyPem(data); err != nil {
+			return nil, errors.Wrapf(err, "PublicKeyFile:%v", conf.PublicKeyFile)
+		}
+	}
+	if conf.JwksFile != "" || conf.JwksUrl != "" {
+		a.jwks = newJwksCache(conf)
+		if err := a.jwks.refresh(); err != nil {
+			return nil, err
+		}
+		go a.jwks.refreshLoop(ctx)
+	}
+
+	algorithms := conf.Algorithms
+	if len(algorithms) == 0 {
+		if conf.Secret != "" {
+			algorithms = append(algorithms, "HS256", "HS384", "HS512")
+		}
+		if a.publicKey != nil || a.jwks != nil {
+			algorithms = append(algorithms, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+		}
+	}
+	if len(algorithms) == 0 {
+		return nil, errors.New("no key of jwt configured")
+	}
+
+	// claims are validated with leeway by validateClaims
+	a.parser = jwt.NewParser(jwt.WithValidMethods(algorithms), jwt.WithoutClaimsValidation())
+	return a, nil
+}
+
+func parsePublicKeyPem(data []byte) (any, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return cert.PublicKey, nil
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, ErrUnsupportedJwtKey
+}
+
+func (a *jwtAuthenticator) token(c echo.Context) string {
+	if auth := c.Request().Header.Get(a.conf.Header); auth != "" {
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+			return strings.TrimSpace(auth[7:])
+		}
+		return ""
+	}
+	if a.conf.QueryParam != "" {
+		return c.QueryParam(a.conf.QueryParam)
+	}
+	return ""
+}
+
+func (a *jwtAuthenticator) keyFunc(token *jwt.Token) (any, error) {
+	alg := token.Method.Alg()
+	if strings.HasPrefix(alg, "HS") {
+		// never verify HS with a public key
+		if a.conf.Secret == "" {
+			return nil, ErrUnsupportedJwtKey
+		}
+		return []byte(a.conf.Secret), nil
+	}
+
+	if kid, _ := token.Header["kid"].(string); kid != "" && a.jwks != nil {
+		return a.jwks.get(kid)
+	}
+	if a.publicKey != nil {
+		return a.publicKey, nil
+	}
+	return nil, ErrUnsupportedJwtKey
+}
+
+func (a *jwtAuthenticator) Authenticate(c echo.Context) (*Principal, error) {
+	raw := a.token(c)
+	if raw == "" {
+		return nil, ErrNoCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(raw, claims, a.keyFunc); err != nil {
+		return nil, errors.Wrapf(ErrInvalidCredentials, "%v", err)
+	}
+	if err := a.validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	p := &Principal{Method: AuthMethodJwt, Claims: claims}
+	p.Id, _ = claims[a.conf.SubjectClaim].(string)
+	p.ClientId, _ = claims[a.conf.ClientIdClaim].(string)
+	switch v := claims[a.conf.ScopeClaim].(type) {
+	case string:
+		p.Scopes = strings.Fields(v)
+	case []any:
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				p.Scopes = append(p.Scopes, s)
+			}
+		}
+	}
+	return p, nil
+}
+
+func (a *jwtAuthenticator) validateClaims(claims jwt.MapClaims) error {
+	now := time.Now().Unix()
+	leeway := int64(a.conf.Leeway)
+
+	exp, ok := claimInt(claims, "exp")
+	if !ok && !a.conf.AllowNoExp {
+		return errors.Wrapf(ErrInvalidCredentials, "token has no exp")
+	}
+	if ok && now > exp+leeway {
+		return errors.Wrapf(ErrInvalidCredentials, "token is expired")
+	}
+	if nbf, ok := claimInt(claims, "nbf"); ok && now+leeway < nbf {
+		return errors.Wrapf(ErrInvalidCredentials, "token is not valid yet")
+	}
+	if iss, _ := claims["iss"].(string); a.conf.Issuer != "" && iss != a.conf.Issuer {
+		return errors.Wrapf(ErrInvalidCredentials, "invalid issuer")
+	}
+	if a.conf.Audience != "" && !verifyAudience(claims["aud"], a.conf.Audience) {
+		return errors.Wrapf(ErrInvalidCredentials, "invalid audience")
+	}
+	return nil
+}
+
+func claimInt(claims jwt.MapClaims, name string) (int64, bool) {
+	switch v := claims[name].(type) {
+	case float64:
+		return int64(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func verifyAudience(aud any, expected string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == expected
+	case []any:
+		for _, a := range v {
+			if a == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const jwksMinRefetch = 10 * time.Second
+
+// jwksCache keys of a JWKS file or url by kid
+type jwksCache struct {
+	conf   JwtConfig
+	client *http.Client
+
+	mutex     sync.RWMutex
+	keys      map[string]any
+	lastFetch time.Time
+}
+
+type jsonWebKey struct {
+	Kty string   `json:"kty"`
+	Kid string   `json:"kid"`
+	Use string   `json:"use"`
+	N   string   `json:"n"`
+	E   string   `json:"e"`
+	Crv string   `json:"crv"`
+	X   string   `json:"x"`
+	Y   string   `json:"y"`
+	X5c []string `json:"x5c"`
+}
+
+func newJwksCache(conf JwtConfig) *jwksCache {
+	return &jwksCache{conf: conf, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (jc *jwksCache) get(kid string) (any, error) {
+	jc.mutex.RLock()
+	key, ok := jc.keys[kid]
+	stale := time.Since(jc.lastFetch) > jwksMinRefetch
+	jc.mutex.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	// keys may be rotated
+	if stale {
+		if err := jc.refresh(); err != nil {
+			log.Errorf("Failed to refresh jwks, err:%v", err)
+		}
+		jc.mutex.RLock()
+		key, ok = jc.keys[kid]
+		jc.mutex.RUnlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, errors.Wrapf(ErrUnsupportedJwtKey, "unknown kid:%v", kid)
+}
+
+func (jc *jwksCache) fetch() ([]byte, error) {
+	if jc.conf.JwksFile != "" {
+		data, err := os.ReadFile(jc.conf.JwksFile)
+		return data, errors.Wrap(err)
+	}
+
+	resp, err := jc.client.Get(jc.conf.JwksUrl)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("fetch jwks from %v, status:%v", jc.conf.JwksUrl, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return data, errors.Wrap(err)
+}
+
+func (jc *jwksCache) refresh() error {
+	jc.mutex.Lock()
+	jc.lastFetch = time.Now()
+	jc.mutex.Unlock()
+
+	data, err := jc.fetch()
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &set); err != nil {
+		return errors.Wrap(err)
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Warnf("Skip jwk, kid:%v, err:%v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	jc.mutex.Lock()
+	jc.keys = keys
+	jc.mutex.Unlock()
+	return nil
+}
+
+func (jc *jwksCache) refreshLoop(ctx context.Context) {
+	if jc.conf.JwksRefresh <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(jc.conf.JwksRefresh) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// keep the old keys on failure
+			if err := jc.refresh(); err != nil {
+				log.Errorf("Failed to refresh jwks, err:%v", err)
+			}
+		}
+	}
+}
+
+func decodeB64Int(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k *jsonWebKey) publicKey() (any, error) {
+	if len(k.X5c) > 0 {
+		der, err := base64.StdEncoding.DecodeString(k.X5c[0])
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		return cert.PublicKey, nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeB64Int(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeB64Int(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Wrapf(ErrUnsupportedJwtKey, "crv:%v", k.Crv)
+		}
+		x, err := decodeB64Int(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeB64Int(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, errors.Wrapf(ErrUnsupportedJwtKey, "kty:%v", k.Kty)
+}
@@ -0,0 +1,229 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/require"
+)
+
+func newAuthEcho(t *testing.T, optional bool, authenticators ...Authenticator) (*echo.Echo, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	e := echo.New()
+	e.Use(LoggerWithConfig(LoggerConfig{
+		Output:        out,
+		OutBodyFilter: DefaultOutBodyFilter,
+		Timing:        AccessLogAfterRun,
+		FormatAfter:   "${status} ${auth_method} ${principal}",
+	}))
+	e.Use(AuthWithConfig(AuthConfig{Authenticators: authenticators, Optional: optional}))
+	e.Any("/whoami", func(c echo.Context) error {
+		p := GetPrincipal(c)
+		if p == nil {
+			return c.String(http.StatusOK, "anonymous")
+		}
+		return c.String(http.StatusOK, p.Method+":"+p.Id+":"+strings.Join(p.Scopes, ","))
+	})
+	return e, out
+}
+
+func serveAuth(e *echo.Echo, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func bearer(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	return req
+}
+
+func TestJwtAuthenticator(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "EC", "kid": "k1", "use": "sig", "crv": "P-256",
+		"x": base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+		"y": base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+	}}})
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	require.Nil(t, os.WriteFile(jwksFile, jwks, 0600))
+
+	a, err := NewJwtAuthenticator(context.Background(), JwtConfig{
+		Secret:   "s3cret",
+		JwksFile: jwksFile,
+		Issuer:   "pkgx",
+		Audience: "api",
+	})
+	require.Nil(t, err)
+	e, out := newAuthEcho(t, false, a)
+
+	claims := func(exp time.Duration) jwt.MapClaims {
+		return jwt.MapClaims{"sub": "u1", "iss": "pkgx", "aud": []string{"api"}, "scope": "read write", "exp": time.Now().Add(exp).Unix()}
+	}
+	hs, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(time.Minute)).SignedString([]byte("s3cret"))
+	rec := serveAuth(e, bearer(hs))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "jwt:u1:read,write", rec.Body.String())
+	require.Equal(t, "200 jwt u1\n", out.String())
+
+	es := jwt.NewWithClaims(jwt.SigningMethodES256, claims(time.Minute))
+	es.Header["kid"] = "k1"
+	signed, err := es.SignedString(ecKey)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, serveAuth(e, bearer(signed)).Code)
+
+	// expired within leeway is accepted
+	hs, _ = jwt.NewWithClaims(jwt.SigningMethodHS256, claims(-30*time.Second)).SignedString([]byte("s3cret"))
+	require.Equal(t, http.StatusOK, serveAuth(e, bearer(hs)).Code)
+
+	for _, bad := range []string{
+		func() string {
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(-2*time.Minute)).SignedString([]byte("s3cret"))
+			return s
+		}(),
+		func() string {
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(time.Minute)).SignedString([]byte("other"))
+			return s
+		}(),
+		func() string {
+			c := claims(time.Minute)
+			c["aud"] = "others"
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte("s3cret"))
+			return s
+		}(),
+		func() string {
+			c := claims(time.Minute)
+			delete(c, "exp")
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte("s3cret"))
+			return s
+		}(),
+		func() string {
+			c := claims(time.Minute)
+			c["iss"] = "others"
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte("s3cret"))
+			return s
+		}(),
+		func() string {
+			// alg none is never allowed
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims(time.Minute)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+			return s
+		}(),
+		func() string {
+			tk := jwt.NewWithClaims(jwt.SigningMethodES256, claims(time.Minute))
+			tk.Header["kid"] = "unknown"
+			s, _ := tk.SignedString(ecKey)
+			return s
+		}(),
+		"not-a-token",
+	} {
+		require.Equal(t, http.StatusUnauthorized, serveAuth(e, bearer(bad)).Code, bad)
+	}
+
+	// no credentials
+	require.Equal(t, http.StatusUnauthorized, serveAuth(e, httptest.NewRequest(http.MethodGet, "/whoami", nil)).Code)
+}
+
+func TestApiKeyAuthenticatorChain(t *testing.T) {
+	keys, err := NewApiKeyAuthenticator(ApiKeyConfig{QueryParam: "api_key", Keys: []ApiKey{{Key: "k-123", Id: "svc-a", Scopes: []string{"read"}}}})
+	require.Nil(t, err)
+	jwtAuth, err := NewJwtAuthenticator(context.Background(), JwtConfig{Secret: "s3cret"})
+	require.Nil(t, err)
+	e, _ := newAuthEcho(t, true, jwtAuth, keys)
+
+	req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+	req.Header.Set("X-Api-Key", "k-123")
+	rec := serveAuth(e, req)
+	require.Equal(t, "apikey:svc-a:read", rec.Body.String())
+
+	rec = serveAuth(e, httptest.NewRequest(http.MethodGet, "/whoami?api_key=k-123", nil))
+	require.Equal(t, "apikey:svc-a:read", rec.Body.String())
+
+	// invalid credentials are rejected even if optional
+	req = httptest.NewRequest(http.MethodGet, "/whoami", nil)
+	req.Header.Set("X-Api-Key", "k-456")
+	require.Equal(t, http.StatusUnauthorized, serveAuth(e, req).Code)
+
+	rec = serveAuth(e, httptest.NewRequest(http.MethodGet, "/whoami", nil))
+	require.Equal(t, "anonymous", rec.Body.String())
+
+	// exp is required even if the config is not loaded with vx_default
+	noExp, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "u1"}).SignedString([]byte("s3cret"))
+	require.Equal(t, http.StatusUnauthorized, serveAuth(e, bearer(noExp)).Code)
+	allowNoExp, err := NewJwtAuthenticator(context.Background(), JwtConfig{Secret: "s3cret", AllowNoExp: true})
+	require.Nil(t, err)
+	e, _ = newAuthEcho(t, true, allowNoExp)
+	require.Equal(t, "jwt:u1:", serveAuth(e, bearer(noExp)).Body.String())
+
+	_, err = NewApiKeyAuthenticator(ApiKeyConfig{Keys: []ApiKey{{Id: "empty"}}})
+	require.NotNil(t, err)
+}
+
+func TestHmacAuthenticator(t *testing.T) {
+	a, err := NewHmacAuthenticator(context.Background(), HmacConfig{
+		Credentials: []HmacCredential{{AccessKey: "ak1", Secret: "sk1", Id: "tenant-1"}},
+		MaxSkew:     60,
+	})
+	require.Nil(t, err)
+	e, _ := newAuthEcho(t, false, a)
+
+	newReq := func(body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/whoami?b=2&a=1", strings.NewReader(body))
+		require.Nil(t, SignHmacRequest(req, "ak1", "sk1"))
+		return req
+	}
+
+	req := newReq(`{"x":1}`)
+	replay := req.Clone(context.Background())
+	replay.Body = http.NoBody
+	rec := serveAuth(e, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "hmac:tenant-1:", rec.Body.String())
+
+	// nonce is consumed
+	replay.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"x":1}`)).Body
+	require.Equal(t, http.StatusUnauthorized, serveAuth(e, replay).Code)
+
+	// tampered body
+	req = newReq(`{"x":1}`)
+	req.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"x":2}`)).Body
+	require.Equal(t, http.StatusUnauthorized, serveAuth(e, req).Code)
+
+	// tampered query
+	req = newReq("")
+	req.URL.RawQuery = "a=1&b=3"
+	require.Equal(t, http.StatusUnauthorized, serveAuth(e, req).Code)
+
+	// out of skew
+	req = newReq("")
+	req.Header.Set(HeaderHmacTimestamp, strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10))
+	require.Equal(t, http.StatusUnauthorized, serveAuth(e, req).Code)
+
+	// params in query signed by HmacSign
+	type query struct {
+		AccessKey string
+		Timestamp string
+		Nonce     string
+		Sign      string
+	}
+	q := &query{AccessKey: "ak1", Timestamp: strconv.FormatInt(time.Now().Unix(), 10), Nonce: "n1"}
+	q.Sign = HmacSign("sk1", http.MethodGet, "/whoami", q)
+	rec = serveAuth(e, httptest.NewRequest(http.MethodGet, "/whoami?AccessKey=ak1&Timestamp="+q.Timestamp+"&Nonce=n1&Sign="+q.Sign, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+}
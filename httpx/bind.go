@@ -60,20 +60,26 @@ func BindAndValidate(c echo.Context, i any) error {
 	hp.queryParams = c.QueryParams()
 	hp.headers = c.Request().Header
 
-	if c.Request().ContentLength > 0 &&
+	// ContentLength is -1 for chunked or decompressed bodies
+	if c.Request().ContentLength != 0 &&
 		strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
 		// Request
 		var reqBody []byte
-		if c.Request().Body != nil { // Read
-			reqBody, _ = io.ReadAll(c.Request().Body)
+		if c.Request().Body != nil { // Read, limited by LimitsWithConfig
+			var err error
+			if reqBody, err = io.ReadAll(c.Request().Body); err != nil {
+				return errors.Wrap(BodyReadError(err))
+			}
 		}
 
 		c.Request().Body = io.NopCloser(bytes.NewBuffer(reqBody)) // Reset
 
-		decoder := json.NewDecoder(bytes.NewBuffer(reqBody))
-		decoder.UseNumber()
-		if err := decoder.Decode(&hp.bodyMap); err != nil {
-			return errors.Wrap(err)
+		if len(reqBody) > 0 {
+			decoder := json.NewDecoder(bytes.NewBuffer(reqBody))
+			decoder.UseNumber()
+			if err := decoder.Decode(&hp.bodyMap); err != nil {
+				return errors.Wrap(err)
+			}
 		}
 	}
 
@@ -0,0 +1,246 @@
+package httpx
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+// EncoderWriter compresses a response, Flush is called for streaming responses like SSE
+type EncoderWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Encoder creates a compressor of a response Content-Encoding
+type Encoder func(w io.Writer, level int) (EncoderWriter, error)
+
+var encoders = map[string]Encoder{
+	"gzip":    func(w io.Writer, level int) (EncoderWriter, error) { return gzip.NewWriterLevel(w, level) },
+	"deflate": func(w io.Writer, level int) (EncoderWriter, error) { return zlib.NewWriterLevel(w, level) },
+	"zstd": func(w io.Writer, level int) (EncoderWriter, error) {
+		// level of gzip is mapped to the closest of zstd, the window is kept within what decoders of HTTP support
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(zstdMaxWindow)}
+		if level != flate.DefaultCompression {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	},
+}
+
+// RegisterEncoder adds or replaces a response Content-Encoding.
+// The encoding is negotiated only if listed in CompressConfig.Encodings
+func RegisterEncoder(encoding string, e Encoder) {
+	codecMutex.Lock()
+	defer codecMutex.Unlock()
+	encoders[strings.ToLower(encoding)] = e
+}
+
+func getEncoder(encoding string) Encoder {
+	codecMutex.RLock()
+	defer codecMutex.RUnlock()
+	return encoders[encoding]
+}
+
+type CompressConfig struct {
+	Skipper middleware.Skipper `json:"-"`
+	// Encodings in order of preference among those accepted with the same q
+	Encodings []string `vx_default:"gzip,deflate,zstd"`
+	Level     int      `vx_default:"-1"`   // of the encoder, -1 is the default of each encoder
+	MinLength int      `vx_default:"1024"` //in bytes, smaller responses are not compressed
+}
+
+// negotiateEncoding picks the accepted encoding of the highest q, "" if none
+func negotiateEncoding(acceptEncoding string, supported []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qs := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		qs[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range supported {
+		q, ok := qs[enc]
+		if !ok {
+			q, ok = qs["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// CompressWithConfig compresses responses by Accept-Encoding, responses already encoded are untouched
+func CompressWithConfig(conf CompressConfig) echo.MiddlewareFunc {
+	if conf.Skipper == nil {
+		conf.Skipper = middleware.DefaultSkipper
+	}
+	if len(conf.Encodings) == 0 {
+		conf.Encodings = []string{"gzip", "deflate", "zstd"}
+	}
+	if conf.Level == 0 {
+		conf.Level = flate.DefaultCompression
+	}
+	if conf.MinLength < 0 {
+		conf.MinLength = 0
+	}
+	for i, enc := range conf.Encodings {
+		conf.Encodings[i] = strings.ToLower(enc)
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if conf.Skipper(c) {
+				return next(c)
+			}
+
+			res := c.Response()
+			res.Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+			encoding := negotiateEncoding(c.Request().Header.Get(echo.HeaderAcceptEncoding), conf.Encodings)
+			if encoding == "" || c.Request().Method == http.MethodHead {
+				return next(c)
+			}
+			encoder := getEncoder(encoding)
+			if encoder == nil {
+				return next(c)
+			}
+
+			cw := &compressWriter{ResponseWriter: res.Writer, encoding: encoding, encoder: encoder, conf: &conf}
+			res.Writer = cw
+			defer func() {
+				_ = cw.finish()
+				res.Writer = cw.ResponseWriter
+			}()
+			return next(c)
+		}
+	}
+}
+
+// compressWriter buffers up to MinLength bytes to decide whether to compress
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	encoder  Encoder
+	conf     *CompressConfig
+
+	status  int
+	buf     bytes.Buffer
+	ew      EncoderWriter
+	decided bool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	// written when the encoding is decided
+	w.status = code
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.buf.Write(b)
+		if w.buf.Len() < w.conf.MinLength {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.ew != nil {
+		return w.ew.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide writes the header and the buffered data, compressed if large is set and the response is not encoded
+func (w *compressWriter) decide(large bool) error {
+	w.decided = true
+	h := w.Header()
+	compress := large && h.Get(echo.HeaderContentEncoding) == "" &&
+		w.status != http.StatusNoContent && w.status != http.StatusNotModified && w.status >= http.StatusOK
+	if compress {
+		ew, err := w.encoder(w.ResponseWriter, w.conf.Level)
+		if err != nil {
+			return err
+		}
+		w.ew = ew
+		h.Set(echo.HeaderContentEncoding, w.encoding)
+		h.Del(echo.HeaderContentLength)
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.ew != nil {
+		_, err = w.ew.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+func (w *compressWriter) finish() error {
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.ew != nil {
+		return w.ew.Close()
+	}
+	return nil
+}
+
+// Flush streams the response, the encoding is decided by data so far
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		_ = w.decide(w.buf.Len() > 0)
+	}
+	if w.ew != nil {
+		_ = w.ew.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// EnableCompression compresses responses by Accept-Encoding, must be called before Run
+func (agw *ApiGateway) EnableCompression(conf CompressConfig) {
+	agw.compress = &conf
+}
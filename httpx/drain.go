@@ -0,0 +1,237 @@
+package httpx
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/graceful"
+	"github.com/madlabx/pkgx/log"
+)
+
+var _ graceful.GracefulServiceWithTimeout = (*ApiGateway)(nil)
+
+// DrainConfig of ApiGateway.Stop:
+//
+//  1. /readyz fails, while requests are still served for GraceDelay, so that load balancers take the instance out
+//  2. listeners are closed, Draining is closed and OnDrain callbacks are called, so that SSE and websockets end
+//  3. in-flight requests are awaited up to Timeout, then remaining connections are closed by force
+type DrainConfig struct {
+	GraceDelay int `vx_default:"0"` //in sec
+	Timeout    int `vx_default:"5"` //in sec
+}
+
+var DefaultDrainConfig = DrainConfig{GraceDelay: 0, Timeout: 5}
+
+// InflightRequest a request not finished when the drain timed out
+type InflightRequest struct {
+	Listener   string
+	Method     string
+	Path       string
+	RemoteAddr string
+	Start      time.Time
+	Hijacked   bool // e.g. websocket
+}
+
+type DrainReport struct {
+	Inflight    int               // requests in flight when listeners were closed
+	ForceClosed []InflightRequest // requests whose connections were closed by force, sorted by Start
+	Duration    time.Duration
+}
+
+// SetDrain replaces DefaultDrainConfig
+func (agw *ApiGateway) SetDrain(conf DrainConfig) {
+	agw.drainConf = conf
+}
+
+// StopTimeout implements graceful.GracefulServiceWithTimeout
+func (agw *ApiGateway) StopTimeout() time.Duration {
+	return time.Duration(agw.drainConf.GraceDelay+agw.drainConf.Timeout+1) * time.Second
+}
+
+// Draining is closed when listeners are closed in Stop, long-lived handlers like SSE should return then
+func (agw *ApiGateway) Draining() <-chan struct{} {
+	return agw.tracker.draining
+}
+
+// IsDraining true since Stop is called, /readyz fails then
+func (agw *ApiGateway) IsDraining() bool {
+	return agw.tracker.isDraining.Load()
+}
+
+// OnDrain registers fn called when listeners are closed in Stop, e.g. to send close frames to websockets
+func (agw *ApiGateway) OnDrain(fn func()) {
+	agw.tracker.mutex.Lock()
+	defer agw.tracker.mutex.Unlock()
+	agw.tracker.onDrain = append(agw.tracker.onDrain, fn)
+}
+
+// LastDrainReport nil before Stop
+func (agw *ApiGateway) LastDrainReport() *DrainReport {
+	return agw.tracker.report.Load()
+}
+
+type ctxKeyConn struct{}
+
+type inflightEntry struct {
+	InflightRequest
+	conn net.Conn
+}
+
+// connTracker tracks in-flight requests of all listeners, and hijacked connections
+type connTracker struct {
+	mutex      sync.Mutex
+	nextId     uint64
+	inflight   map[uint64]*inflightEntry
+	hijacked   map[net.Conn]bool
+	onDrain    []func()
+	draining   chan struct{}
+	isDraining atomic.Bool
+	drainOnce  sync.Once
+	report     atomic.Pointer[DrainReport]
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{
+		inflight: make(map[uint64]*inflightEntry),
+		hijacked: make(map[net.Conn]bool),
+		draining: make(chan struct{}),
+	}
+}
+
+func (t *connTracker) connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, ctxKeyConn{}, c)
+}
+
+func (t *connTracker) connState(c net.Conn, state http.ConnState) {
+	if state == http.StateHijacked {
+		t.mutex.Lock()
+		t.hijacked[c] = true
+		t.mutex.Unlock()
+	}
+}
+
+func (t *connTracker) wrap(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _ := r.Context().Value(ctxKeyConn{}).(net.Conn)
+
+		t.mutex.Lock()
+		t.nextId++
+		id := t.nextId
+		t.inflight[id] = &inflightEntry{
+			InflightRequest: InflightRequest{Listener: name, Method: r.Method, Path: r.URL.Path, RemoteAddr: r.RemoteAddr, Start: time.Now()},
+			conn:            conn,
+		}
+		t.mutex.Unlock()
+
+		defer func() {
+			t.mutex.Lock()
+			delete(t.inflight, id)
+			// the handler owns hijacked connections, which are not tracked after it returns
+			if conn != nil {
+				delete(t.hijacked, conn)
+			}
+			t.mutex.Unlock()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (t *connTracker) count() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.inflight)
+}
+
+func (t *connTracker) notifyDrain() {
+	t.mutex.Lock()
+	callbacks := append([]func(){}, t.onDrain...)
+	t.mutex.Unlock()
+
+	close(t.draining)
+	for _, fn := range callbacks {
+		fn()
+	}
+}
+
+// waitIdle waits until no request is in flight, http.Server.Shutdown does not wait for hijacked connections
+func (t *connTracker) waitIdle(ctx context.Context) bool {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for t.count() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// forceClose closes hijacked connections of remaining requests, others are closed by http.Server.Close
+func (t *connTracker) forceClose() []InflightRequest {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	remaining := make([]InflightRequest, 0, len(t.inflight))
+	for _, e := range t.inflight {
+		r := e.InflightRequest
+		if e.conn != nil && t.hijacked[e.conn] {
+			r.Hijacked = true
+			_ = e.conn.Close()
+		}
+		remaining = append(remaining, r)
+	}
+	sort.Slice(remaining, func(i, j int) bool { return remaining[i].Start.Before(remaining[j].Start) })
+	return remaining
+}
+
+// drain runs once, later calls return the first report
+func (agw *ApiGateway) drain() (*DrainReport, error) {
+	var err error
+	t := agw.tracker
+	t.drainOnce.Do(func() {
+		start := time.Now()
+		conf := agw.drainConf
+
+		t.isDraining.Store(true)
+		if conf.GraceDelay > 0 {
+			log.Infof("ApiGateway %v is draining, readiness fails, stop accepting in %vs", agw.name, conf.GraceDelay)
+			time.Sleep(time.Duration(conf.GraceDelay) * time.Second)
+		}
+
+		report := &DrainReport{Inflight: t.count()}
+		log.Infof("ApiGateway %v stops accepting, in flight:%v, timeout:%vs", agw.name, report.Inflight, conf.Timeout)
+		t.notifyDrain()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(conf.Timeout)*time.Second)
+		defer cancel()
+		err = agw.shutdownListeners(ctx)
+		if t.waitIdle(ctx) && err == nil {
+			report.Duration = time.Since(start)
+			t.report.Store(report)
+			return
+		}
+
+		report.ForceClosed = t.forceClose()
+		agw.closeListeners()
+		report.Duration = time.Since(start)
+		t.report.Store(report)
+		for _, r := range report.ForceClosed {
+			log.Warnf("ApiGateway %v force closed %v %v from %v on %v, hijacked:%v, age:%v",
+				agw.name, r.Method, r.Path, r.RemoteAddr, r.Listener, r.Hijacked, time.Since(r.Start))
+		}
+		if len(report.ForceClosed) > 0 {
+			err = errors.Wrapf(ErrDrainTimeout, "%v requests force closed", len(report.ForceClosed))
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			// only connections without handlers running, e.g. reading slow headers
+			err = nil
+		}
+	})
+	return t.report.Load(), err
+}
@@ -0,0 +1,150 @@
+package httpx
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrain(t *testing.T) {
+	agw, err := NewApiGateway(context.Background(), "127.0.0.1", "0", "test", &LogConfig{Level: "error"}, nil)
+	require.NoError(t, err)
+	agw.EnableAdmin(AdminConfig{})
+	agw.SetDrain(DrainConfig{GraceDelay: 1, Timeout: 1})
+	require.Equal(t, 3*time.Second, agw.StopTimeout())
+
+	notified := make(chan struct{})
+	agw.OnDrain(func() { close(notified) })
+	release := make(chan struct{})
+	defer close(release)
+
+	agw.GET("/v1/slow", func(c echo.Context) error {
+		time.Sleep(1500 * time.Millisecond)
+		return c.String(http.StatusOK, "slow")
+	})
+	agw.GET("/v1/sse", func(c echo.Context) error {
+		c.Response().WriteHeader(http.StatusOK)
+		c.Response().Flush()
+		<-agw.Draining()
+		_, err := c.Response().Write([]byte("data: bye\n\n"))
+		return err
+	})
+	agw.GET("/v1/stuck", func(c echo.Context) error {
+		c.Response().WriteHeader(http.StatusOK)
+		c.Response().Flush()
+		<-c.Request().Context().Done()
+		return nil
+	})
+	agw.GET("/v1/ws", func(c echo.Context) error {
+		conn, rw, err := c.Response().Hijack()
+		if err != nil {
+			return err
+		}
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		_ = rw.Flush()
+		<-release
+		return conn.Close()
+	})
+
+	require.NoError(t, agw.AddListener(ListenerConfig{Name: "public", Addr: "127.0.0.1:0"}))
+	done := make(chan error)
+	go func() { done <- agw.Run() }()
+	require.Eventually(t, func() bool { return len(agw.Addrs()) == 1 }, time.Second, 10*time.Millisecond)
+	base := "http://" + agw.Addrs()[0].String()
+
+	get := func(path string) (*http.Response, error) {
+		return (&http.Client{Transport: &http.Transport{DisableKeepAlives: true}}).Get(base + path)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		results sync.Map
+	)
+	for _, path := range []string{"/v1/slow", "/v1/sse", "/v1/stuck"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			resp, err := get(path)
+			if err != nil {
+				results.Store(path, err.Error())
+				return
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				results.Store(path, "read: "+err.Error())
+				return
+			}
+			results.Store(path, string(body))
+		}(path)
+	}
+
+	ws, err := net.Dial("tcp", agw.Addrs()[0].String())
+	require.NoError(t, err)
+	defer ws.Close()
+	_, err = ws.Write([]byte("GET /v1/ws HTTP/1.1\r\nHost: test\r\n\r\n"))
+	require.NoError(t, err)
+	wsReader := bufio.NewReader(ws)
+	line, err := wsReader.ReadString('\n')
+	require.NoError(t, err)
+	require.Contains(t, line, "101")
+	require.Eventually(t, func() bool { return agw.tracker.count() == 4 }, time.Second, 10*time.Millisecond)
+
+	stopped := make(chan error)
+	go func() { stopped <- agw.Stop() }()
+
+	// readiness fails first, while requests are still served
+	require.Eventually(t, agw.IsDraining, time.Second, 10*time.Millisecond)
+	resp, err := get("/admin/readyz")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	_ = resp.Body.Close()
+	select {
+	case <-notified:
+		t.Fatal("notified before the grace delay")
+	default:
+	}
+
+	err = <-stopped
+	require.ErrorIs(t, err, ErrDrainTimeout)
+	require.ErrorIs(t, <-done, http.ErrServerClosed)
+	<-notified
+	wg.Wait()
+
+	v, _ := results.Load("/v1/slow")
+	require.Equal(t, "slow", v)
+	v, _ = results.Load("/v1/sse")
+	require.Equal(t, "data: bye\n\n", v)
+	_, err = get("/v1/slow")
+	require.Error(t, err)
+
+	report := agw.LastDrainReport()
+	require.Equal(t, 4, report.Inflight)
+	require.Len(t, report.ForceClosed, 2)
+	var paths []string
+	for _, r := range report.ForceClosed {
+		paths = append(paths, r.Path)
+		require.Equal(t, "public", r.Listener)
+		require.Equal(t, r.Path == "/v1/ws", r.Hijacked)
+	}
+	require.ElementsMatch(t, []string{"/v1/stuck", "/v1/ws"}, paths)
+
+	// the hijacked connection is closed by force
+	_ = ws.SetReadDeadline(time.Now().Add(time.Second))
+	rest, err := io.ReadAll(wsReader)
+	require.NoError(t, err)
+	require.False(t, strings.Contains(string(rest), "bye"))
+
+	// later calls return the same report
+	require.NoError(t, agw.Stop())
+	require.Same(t, report, agw.LastDrainReport())
+}
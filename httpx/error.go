@@ -3,5 +3,10 @@ package httpx
 import "errors"
 
 var (
-	ErrAlreadyAccepted = errors.New("AlreadyAccepted")
+	ErrAlreadyAccepted    = errors.New("AlreadyAccepted")
+	ErrNoCredentials      = errors.New("NoCredentials")
+	ErrInvalidCredentials = errors.New("InvalidCredentials")
+	ErrReplayedRequest    = errors.New("ReplayedRequest")
+	ErrUnsupportedJwtKey  = errors.New("UnsupportedJwtKey")
+	ErrDrainTimeout       = errors.New("DrainTimeout")
 )
@@ -2,6 +2,7 @@ package httpx
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net"
@@ -15,10 +16,30 @@ import (
 
 type Client struct {
 	cli *http.Client
+	ctx context.Context
 }
 
+// Do sends req with the W3C trace context of req.Context()
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
-	return c.cli.Do(req)
+	return c.do(req)
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	span := startClientSpan(req.Context(), req.Method, req.URL.String(), req.Header)
+	rsp, err := c.cli.Do(req)
+	if err != nil {
+		endClientSpan(span, 0, err)
+		return nil, err
+	}
+	endClientSpan(span, rsp.StatusCode, nil)
+	return rsp, nil
+}
+
+// WithContext returns a copy whose requests are canceled with ctx, and carry the W3C trace context of ctx
+func (hc *Client) WithContext(ctx context.Context) *Client {
+	nc := hc.clone()
+	nc.ctx = ctx
+	return nc
 }
 
 func GetRealIp(req *http.Request) string {
@@ -105,7 +126,7 @@ func (hc *Client) PostBytesX(url string, b []byte, result interface{}) (*JsonRes
 
 func (hc *Client) clone() *Client {
 	newRawClient := *hc.cli
-	return &Client{cli: &newRawClient}
+	return &Client{cli: &newRawClient, ctx: hc.ctx}
 }
 
 func HttpGetBody(url string) (*http.Response, []byte, error) {
@@ -151,7 +172,11 @@ func requestBytes(cli *Client, method, url string, bodyBytes []byte) (*http.Resp
 }
 
 func requestBytesForBody(hc *Client, method, requrl string, bodyBytes []byte, wantBody bool) (*http.Response, []byte, error) {
-	req, err := http.NewRequest(method, requrl, bytes.NewReader(bodyBytes))
+	ctx := hc.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, requrl, bytes.NewReader(bodyBytes))
 
 	if err != nil {
 		log.Errorf("failed to build request, err:%#v", err.Error())
@@ -161,7 +186,7 @@ func requestBytesForBody(hc *Client, method, requrl string, bodyBytes []byte, wa
 		req.Header.Set("Content-Type", "application/json")
 	}
 	req.Header.Set("Connection", "close")
-	rsp, err := hc.cli.Do(req)
+	rsp, err := hc.do(req)
 	if err != nil {
 		//TODO add httpError
 		return nil, nil, err
@@ -0,0 +1,281 @@
+package httpx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/madlabx/pkgx/cachestore"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/log"
+	"github.com/madlabx/pkgx/memkv"
+)
+
+const (
+	HeaderIdempotencyKey      = "Idempotency-Key"
+	HeaderIdempotencyReplayed = "Idempotency-Replayed"
+)
+
+// IdempotencyStore keeps idempotency entries, implemented by memkv, redis and dbc
+type IdempotencyStore = cachestore.IdempotencyStore
+
+type IdempotencyConfig struct {
+	Skipper    middleware.Skipper `json:"-"`
+	Store      IdempotencyStore   `json:"-"`
+	Header     string             `vx_default:"Idempotency-Key"`
+	QueryParam string
+	Required   bool  // reject requests without key
+	Ttl        int64 `vx_default:"86400"`   //in sec, responses are replayed within ttl
+	LockTtl    int64 `vx_default:"60"`      //in sec, the key is released if the first request has not completed within LockTtl
+	MaxBody    int64 `vx_default:"1048576"` //in bytes, larger responses are not stored
+	MaxRequest int64 `vx_default:"1048576"` //in bytes, requests with a key and a larger body are rejected with 413
+}
+
+type idempotencyEntry struct {
+	Fingerprint string      `json:"fp"`
+	Done        bool        `json:"done"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// IdempotencyWithConfig runs a request only once per key of a principal on a route. The key is locked while the first request is in flight,
+// its status, headers and body are stored and replayed to retries. Retries with a different
+// method, path or body are rejected with 422. Responses with 5xx are not stored, so that retries run again
+func IdempotencyWithConfig(conf IdempotencyConfig) echo.MiddlewareFunc {
+	if conf.Store == nil {
+		panic("httpx: idempotency middleware requires a store")
+	}
+	if conf.Skipper == nil {
+		conf.Skipper = middleware.DefaultSkipper
+	}
+	if conf.Header == "" {
+		conf.Header = HeaderIdempotencyKey
+	}
+	if conf.Ttl <= 0 {
+		conf.Ttl = 86400
+	}
+	if conf.LockTtl <= 0 {
+		conf.LockTtl = 60
+	}
+	if conf.MaxBody <= 0 {
+		conf.MaxBody = 1 << 20
+	}
+	if conf.MaxRequest <= 0 {
+		conf.MaxRequest = 1 << 20
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if conf.Skipper(c) {
+				return next(c)
+			}
+
+			key := c.Request().Header.Get(conf.Header)
+			if key == "" && conf.QueryParam != "" {
+				key = c.QueryParam(conf.QueryParam)
+			}
+			if key == "" {
+				if conf.Required {
+					return SendResp(c, echo.NewHTTPError(http.StatusBadRequest, "missing "+conf.Header))
+				}
+				return next(c)
+			}
+
+			fp, err := fingerprint(c.Request(), conf.MaxRequest)
+			if err != nil {
+				return SendResp(c, err)
+			}
+			key = idempotencyStoreKey(c, key)
+
+			pending, _ := json.Marshal(&idempotencyEntry{Fingerprint: fp})
+			locked, err := conf.Store.SetNX(key, pending, time.Duration(conf.LockTtl)*time.Second)
+			if err != nil {
+				return errors.Wrap(err)
+			}
+			if !locked {
+				return replay(c, conf.Store, key, fp)
+			}
+
+			return runOnce(c, next, conf, key, fp)
+		}
+	}
+}
+
+// idempotencyStoreKey scopes the key by principal and route, so that clients can not replay responses of others
+func idempotencyStoreKey(c echo.Context, key string) string {
+	var id string
+	if p := GetPrincipal(c); p != nil {
+		id = p.Id
+	}
+
+	h := sha256.New()
+	for _, s := range []string{id, c.Request().Method, c.Path(), key} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return "idem_" + hex.EncodeToString(h.Sum(nil))
+}
+
+func fingerprint(req *http.Request, maxBody int64) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.Path + "\n"))
+
+	if req.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxBody+1))
+		if err != nil {
+			return "", errors.Wrap(err)
+		}
+		if int64(len(body)) > maxBody {
+			return "", echo.NewHTTPError(http.StatusRequestEntityTooLarge, "request body too large for idempotency")
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		h.Write(body)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func replay(c echo.Context, store IdempotencyStore, key, fp string) error {
+	value, err := store.Get(key)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	entry := &idempotencyEntry{}
+	if value == nil || json.Unmarshal(value, entry) != nil {
+		// expired or released just now
+		c.Response().Header().Set("Retry-After", "1")
+		return SendResp(c, echo.NewHTTPError(http.StatusConflict, "request with the same idempotency key is in progress"))
+	}
+
+	switch {
+	case entry.Fingerprint != fp:
+		return SendResp(c, echo.NewHTTPError(http.StatusUnprocessableEntity, "idempotency key reused with a different request"))
+	case !entry.Done:
+		c.Response().Header().Set("Retry-After", "1")
+		return SendResp(c, echo.NewHTTPError(http.StatusConflict, "request with the same idempotency key is in progress"))
+	}
+
+	h := c.Response().Header()
+	for k, vs := range entry.Header {
+		h[k] = vs
+	}
+	h.Set(HeaderIdempotencyReplayed, "true")
+	c.Response().WriteHeader(entry.Status)
+	_, err = c.Response().Write(entry.Body)
+	return err
+}
+
+func runOnce(c echo.Context, next echo.HandlerFunc, conf IdempotencyConfig, key, fp string) error {
+	body := &cappedBuffer{max: conf.MaxBody}
+	res := c.Response()
+	origWriter := res.Writer
+	res.Writer = &bodyDumpResponseWriter{Writer: io.MultiWriter(origWriter, body), ResponseWriter: origWriter}
+	defer func() { res.Writer = origWriter }()
+
+	// render the error here, so that it is stored as well. It is still returned for outer middlewares
+	// to record, echo does not render a committed response again
+	handlerErr := next(c)
+	if handlerErr != nil {
+		c.Error(handlerErr)
+	}
+
+	store := res.Committed && res.Status < http.StatusInternalServerError && !body.overflow
+	if !store {
+		log.IgnoreErrf(conf.Store.Delete(key), "release idempotency key:%v", key)
+		return handlerErr
+	}
+
+	header := res.Header().Clone()
+	header.Del(HeaderIdempotencyReplayed)
+	value, _ := json.Marshal(&idempotencyEntry{
+		Fingerprint: fp,
+		Done:        true,
+		Status:      res.Status,
+		Header:      header,
+		Body:        body.Bytes(),
+	})
+	if err := conf.Store.Set(key, value, time.Duration(conf.Ttl)*time.Second); err != nil {
+		log.Errorf("Failed to store idempotent response, key:%v, err:%v", key, err)
+		log.IgnoreErrf(conf.Store.Delete(key), "release idempotency key:%v", key)
+	}
+
+	return handlerErr
+}
+
+type cappedBuffer struct {
+	bytes.Buffer
+	max      int64
+	overflow bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.overflow || int64(b.Len()+len(p)) > b.max {
+		b.overflow = true
+		b.Reset()
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+var _ cachestore.Record = &idempotencyRecord{}
+
+type idempotencyRecord struct {
+	Key      string
+	Value    string
+	ExpireAt int64
+}
+
+func (r *idempotencyRecord) GetKey() string             { return r.Key }
+func (r *idempotencyRecord) GetValue() string           { return r.Value }
+func (r *idempotencyRecord) Unmarshal(s string) error   { r.Value = s; return nil }
+func (r *idempotencyRecord) SetExpireAt(expireAt int64) { r.ExpireAt = expireAt }
+func (r *idempotencyRecord) GetExpireAt() int64         { return r.ExpireAt }
+func (r *idempotencyRecord) TableName() string          { return "apigateway_idempotency" }
+func (r *idempotencyRecord) Clone() cachestore.Record   { n := *r; return &n }
+
+type memkvIdempotencyStore struct {
+	cache *memkv.Cache
+}
+
+// NewMemkvIdempotencyStore keeps entries in memkv, enable memkv.SnapshotConf to keep them across restarts
+func NewMemkvIdempotencyStore(cache *memkv.Cache) IdempotencyStore {
+	return &memkvIdempotencyStore{cache: cache}
+}
+
+func ttlInSec(ttl time.Duration) int64 {
+	return max(int64((ttl+time.Second-1)/time.Second), 1)
+}
+
+func (s *memkvIdempotencyStore) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.cache.SetIfAbsent(&idempotencyRecord{Key: key, Value: string(value)}, ttlInSec(ttl))
+}
+
+func (s *memkvIdempotencyStore) Get(key string) ([]byte, error) {
+	r, err := s.cache.Get(&idempotencyRecord{Key: key})
+	if errors.Is(err, memkv.ErrNotFound) || errors.Is(err, memkv.ErrExpired) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []byte(r.GetValue()), nil
+}
+
+func (s *memkvIdempotencyStore) Set(key string, value []byte, ttl time.Duration) error {
+	return s.cache.Set(&idempotencyRecord{Key: key, Value: string(value)}, ttlInSec(ttl))
+}
+
+func (s *memkvIdempotencyStore) Delete(key string) error {
+	if err := s.cache.Delete(&idempotencyRecord{Key: key}); err != nil && !errors.Is(err, memkv.ErrNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/madlabx/pkgx/memkv"
+	"github.com/stretchr/testify/require"
+)
+
+func newIdempotencyEcho(t *testing.T, conf IdempotencyConfig) (*echo.Echo, *atomic.Int64) {
+	cache := memkv.NewCache(context.Background(), nil, memkv.CacheConf{})
+	t.Cleanup(func() { _ = cache.Stop() })
+	conf.Store = NewMemkvIdempotencyStore(cache)
+
+	var runs atomic.Int64
+
+	e := echo.New()
+	e.Use(IdempotencyWithConfig(conf))
+	e.POST("/orders", func(c echo.Context) error {
+		runs.Add(1)
+		c.Response().Header().Set("X-Order", "o1")
+		return c.JSON(http.StatusCreated, map[string]int64{"n": runs.Load()})
+	})
+	e.POST("/fail", func(c echo.Context) error {
+		runs.Add(1)
+		return echo.NewHTTPError(http.StatusInternalServerError, "boom")
+	})
+	return e, &runs
+}
+
+func postIdempotent(e *echo.Echo, path, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	e, runs := newIdempotencyEcho(t, IdempotencyConfig{})
+
+	first := postIdempotent(e, "/orders", "k1", `{"a":1}`)
+	require.Equal(t, http.StatusCreated, first.Code)
+	require.Empty(t, first.Header().Get(HeaderIdempotencyReplayed))
+
+	second := postIdempotent(e, "/orders", "k1", `{"a":1}`)
+	require.Equal(t, http.StatusCreated, second.Code)
+	require.Equal(t, "true", second.Header().Get(HeaderIdempotencyReplayed))
+	require.Equal(t, "o1", second.Header().Get("X-Order"))
+	require.Equal(t, first.Body.String(), second.Body.String())
+	require.Equal(t, int64(1), runs.Load())
+
+	// same key with another body
+	rec := postIdempotent(e, "/orders", "k1", `{"a":2}`)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	// no key, no idempotency
+	postIdempotent(e, "/orders", "", `{"a":1}`)
+	require.Equal(t, int64(2), runs.Load())
+}
+
+func TestIdempotencyInProgressAndFailure(t *testing.T) {
+	e, runs := newIdempotencyEcho(t, IdempotencyConfig{Required: true})
+
+	rec := postIdempotent(e, "/orders", "", `{}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// 5xx is not stored, retry runs again
+	rec = postIdempotent(e, "/fail", "k2", `{}`)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	rec = postIdempotent(e, "/fail", "k2", `{}`)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Empty(t, rec.Header().Get(HeaderIdempotencyReplayed))
+	require.Equal(t, int64(2), runs.Load())
+}
+
+func TestIdempotencyConcurrent(t *testing.T) {
+	cache := memkv.NewCache(context.Background(), nil, memkv.CacheConf{})
+	defer cache.Stop()
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	e := echo.New()
+	e.Use(IdempotencyWithConfig(IdempotencyConfig{Store: NewMemkvIdempotencyStore(cache)}))
+	e.POST("/orders", func(c echo.Context) error {
+		close(entered)
+		<-release
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postIdempotent(e, "/orders", "k3", `{}`) }()
+	<-entered
+
+	rec := postIdempotent(e, "/orders", "k3", `{}`)
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	close(release)
+	require.Equal(t, http.StatusNoContent, (<-done).Code)
+	rec = postIdempotent(e, "/orders", "k3", `{}`)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "true", rec.Header().Get(HeaderIdempotencyReplayed))
+}
+
+func TestIdempotencyKeyScope(t *testing.T) {
+	keys, err := NewApiKeyAuthenticator(ApiKeyConfig{Keys: []ApiKey{{Key: "ka", Id: "alice"}, {Key: "kb", Id: "bob"}}})
+	require.Nil(t, err)
+
+	cache := memkv.NewCache(context.Background(), nil, memkv.CacheConf{})
+	defer cache.Stop()
+
+	var runs atomic.Int64
+	e := echo.New()
+	e.Use(AuthWithConfig(AuthConfig{Authenticators: []Authenticator{keys}}))
+	e.Use(IdempotencyWithConfig(IdempotencyConfig{Store: NewMemkvIdempotencyStore(cache), MaxRequest: 8}))
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusCreated, GetPrincipal(c).Id+strconv.FormatInt(runs.Add(1), 10))
+	}
+	e.POST("/orders", handler)
+	e.POST("/refunds", handler)
+
+	do := func(path, apiKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(HeaderIdempotencyKey, "k1")
+		req.Header.Set("X-Api-Key", apiKey)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, "alice1", do("/orders", "ka", "{}").Body.String())
+	require.Equal(t, "alice1", do("/orders", "ka", "{}").Body.String())
+	// the same key of another principal or route is another request
+	require.Equal(t, "bob2", do("/orders", "kb", "{}").Body.String())
+	rec := do("/refunds", "ka", "{}")
+	require.Equal(t, "alice3", rec.Body.String())
+	require.Empty(t, rec.Header().Get(HeaderIdempotencyReplayed))
+
+	rec = do("/orders", "ka", `{"a":"123456"}`)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	require.Equal(t, int64(3), runs.Load())
+}
+
+func TestIdempotencyReturnsHandlerError(t *testing.T) {
+	cache := memkv.NewCache(context.Background(), nil, memkv.CacheConf{})
+	defer cache.Stop()
+
+	var recorded []error
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			recorded = append(recorded, err)
+			return err
+		}
+	})
+	e.Use(IdempotencyWithConfig(IdempotencyConfig{Store: NewMemkvIdempotencyStore(cache)}))
+	e.POST("/orders", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusConflict, "duplicated order")
+	})
+
+	// rendered once and stored, while the error reaches outer middlewares
+	first := postIdempotent(e, "/orders", "k1", `{}`)
+	require.Equal(t, http.StatusConflict, first.Code)
+	require.Equal(t, 1, strings.Count(first.Body.String(), "duplicated order"))
+	require.Len(t, recorded, 1)
+	require.Equal(t, http.StatusConflict, recorded[0].(*echo.HTTPError).Code)
+
+	second := postIdempotent(e, "/orders", "k1", `{}`)
+	require.Equal(t, http.StatusConflict, second.Code)
+	require.Equal(t, "true", second.Header().Get(HeaderIdempotencyReplayed))
+	require.Equal(t, first.Body.String(), second.Body.String())
+	require.Nil(t, recorded[1])
+}
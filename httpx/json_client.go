@@ -1,6 +1,7 @@
 package httpx
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
@@ -107,6 +108,7 @@ type JsonClient struct {
 
 	statsChan chan<- *RequestStats
 	c         *resty.Client
+	ctx       context.Context
 }
 
 func NewJsonClient(host string, port int, timeout int) *JsonClient {
@@ -136,6 +138,14 @@ func (c *JsonClient) SetStatsChan(ch chan<- *RequestStats) {
 	c.statsChan = ch
 }
 
+// WithContext returns a copy sharing the underlying client, requests are canceled with ctx,
+// and carry the W3C trace context of ctx
+func (c *JsonClient) WithContext(ctx context.Context) *JsonClient {
+	nc := *c
+	nc.ctx = ctx
+	return &nc
+}
+
 func (c *JsonClient) SetReuseConnection() {
 
 	log.Infof("Set client %s:%d reuse connection", c.Host, c.Port)
@@ -307,6 +317,10 @@ func (c *JsonClient) requestRTimeout(result interface{}, method, url string, hea
 		}
 		url = c.Url(url)
 	}
+	if c.ctx != nil {
+		r.SetContext(c.ctx)
+	}
+	span := startClientSpan(c.ctx, method, url, r.Header)
 	log.Debugf("Send api request: %s %s, timeout: %d", method, url, timeout)
 	if c.IsHttps {
 		stats.Scheme = "https"
@@ -323,9 +337,11 @@ func (c *JsonClient) requestRTimeout(result interface{}, method, url string, hea
 	stats.SendTime = r.Time
 	stats.RspTime = time.Now()
 	if err != nil {
+		endClientSpan(span, 0, err)
 		log.Errorf("%v, timeout: %d ms", err, timeout)
 		return nil, err
 	}
+	endClientSpan(span, rsp.StatusCode(), nil)
 	log.Debugf("Recv api response: %s %s, status: %d", method, url, rsp.StatusCode())
 	defer rsp.RawBody().Close()
 
@@ -358,6 +374,13 @@ type AuthJsonClient struct {
 	token  string
 }
 
+// WithContext returns a copy with the current token, see JsonClient.WithContext
+func (c *AuthJsonClient) WithContext(ctx context.Context) *AuthJsonClient {
+	nc := *c
+	nc.Client.ctx = ctx
+	return &nc
+}
+
 func (c *AuthJsonClient) Login(method, url string, headers map[string]string,
 	data interface{}, tokenField string) (string, error) {
 
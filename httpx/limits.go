@@ -0,0 +1,256 @@
+package httpx
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/madlabx/pkgx/errors"
+)
+
+// TimeoutConfig timeouts of http.Server of all listeners, 0 means no timeout
+type TimeoutConfig struct {
+	ReadHeader int `vx_default:"10"`  //in sec, against slowloris
+	Read       int `vx_default:"0"`   //in sec, of the whole request including body
+	Write      int `vx_default:"0"`   //in sec, from the end of request headers to the end of response, keep 0 for SSE
+	Idle       int `vx_default:"120"` //in sec, of keep-alive connections
+}
+
+var DefaultTimeoutConfig = TimeoutConfig{ReadHeader: 10, Idle: 120}
+
+// SetTimeouts replaces DefaultTimeoutConfig, must be called before Run
+func (agw *ApiGateway) SetTimeouts(conf TimeoutConfig) {
+	agw.timeouts = conf
+}
+
+func (conf TimeoutConfig) apply(srv *http.Server) {
+	srv.ReadHeaderTimeout = time.Duration(conf.ReadHeader) * time.Second
+	srv.ReadTimeout = time.Duration(conf.Read) * time.Second
+	srv.WriteTimeout = time.Duration(conf.Write) * time.Second
+	srv.IdleTimeout = time.Duration(conf.Idle) * time.Second
+}
+
+// RouteLimit overrides LimitsConfig for a route
+type RouteLimit struct {
+	Method  string // all methods if empty
+	Path    string // route template, e.g. /v1/files/:id
+	MaxBody int64  //in bytes, 0 uses LimitsConfig.MaxBody, negative no limit
+	Timeout int    //in sec, 0 uses LimitsConfig.HandlerTimeout, negative no deadline
+}
+
+type LimitsConfig struct {
+	Skipper middleware.Skipper `json:"-"`
+	// MaxBody of requests, also caps the decompressed body. Negative no limit
+	MaxBody int64 `vx_default:"4194304"` //in bytes
+	// HandlerTimeout sets the deadline of c.Request().Context(), handlers should pass it on.
+	// 503 is sent if a handler fails by the deadline without response. No deadline if 0
+	HandlerTimeout int `vx_default:"0"` //in sec
+	// Decompress request bodies by Content-Encoding, gzip, deflate and zstd are built in, others by RegisterDecoder.
+	// Bodies of other encodings are passed to handlers as is
+	Decompress bool `vx_default:"true"`
+	// RejectUnknownEncoding responds 415 to encodings without a decoder if Decompress
+	RejectUnknownEncoding bool `vx_default:"false"`
+	Routes                []RouteLimit
+}
+
+// DefaultLimitsConfig applied by ApiGateway.SetLimits, so that BindAndValidate reads at most 4MiB
+var DefaultLimitsConfig = LimitsConfig{MaxBody: 4 << 20, Decompress: true}
+
+// zstdMaxWindow window size decoders of HTTP are required to support by RFC 8878, larger frames are rejected
+const zstdMaxWindow = 8 << 20
+
+// Decoder decompresses a request body
+type Decoder func(r io.Reader) (io.ReadCloser, error)
+
+var (
+	codecMutex sync.RWMutex
+	decoders   = map[string]Decoder{
+		"gzip":    func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) }, // zlib format by RFC 9110
+		"zstd": func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	}
+)
+
+// RegisterDecoder adds or replaces a request Content-Encoding
+func RegisterDecoder(encoding string, d Decoder) {
+	codecMutex.Lock()
+	defer codecMutex.Unlock()
+	decoders[strings.ToLower(encoding)] = d
+}
+
+func getDecoder(encoding string) Decoder {
+	codecMutex.RLock()
+	defer codecMutex.RUnlock()
+	return decoders[encoding]
+}
+
+// limitedBody fails with *http.MaxBytesError once more than limit bytes are read, like http.MaxBytesReader
+type limitedBody struct {
+	r      io.Reader
+	closer io.Closer
+	n      int64 // bytes left
+	limit  int64
+	err    error
+}
+
+func newLimitedBody(r io.Reader, closer io.Closer, limit int64) *limitedBody {
+	return &limitedBody{r: r, closer: closer, n: limit, limit: limit}
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// one more byte to tell whether the limit is exceeded
+	if int64(len(p))-1 > l.n {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) <= l.n {
+		l.n -= int64(n)
+		l.err = err
+		return n, err
+	}
+
+	n = int(l.n)
+	l.n = 0
+	l.err = &http.MaxBytesError{Limit: l.limit}
+	return n, l.err
+}
+
+func (l *limitedBody) Close() error {
+	return l.closer.Close()
+}
+
+// BodyReadError maps errors of reading request bodies limited by LimitsWithConfig to 413 or 400
+func BodyReadError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, tooLarge.Error())
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, "read body: "+err.Error())
+}
+
+type decodedBody struct {
+	io.ReadCloser
+	raw io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if e := b.raw.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// LimitsWithConfig limits body sizes and handler deadlines per route, register it by Echo.Use,
+// so that the route is known
+func LimitsWithConfig(conf LimitsConfig) echo.MiddlewareFunc {
+	if conf.Skipper == nil {
+		conf.Skipper = middleware.DefaultSkipper
+	}
+	routes := make(map[string]RouteLimit, len(conf.Routes))
+	for _, r := range conf.Routes {
+		routes[strings.ToUpper(r.Method)+" "+r.Path] = r
+	}
+
+	limitOf := func(c echo.Context) (int64, int) {
+		maxBody, timeout := conf.MaxBody, conf.HandlerTimeout
+		r, ok := routes[c.Request().Method+" "+c.Path()]
+		if !ok {
+			r, ok = routes[" "+c.Path()]
+		}
+		if ok {
+			if r.MaxBody != 0 {
+				maxBody = r.MaxBody
+			}
+			if r.Timeout != 0 {
+				timeout = r.Timeout
+			}
+		}
+		return maxBody, timeout
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if conf.Skipper(c) {
+				return next(c)
+			}
+
+			req := c.Request()
+			maxBody, timeout := limitOf(c)
+			if maxBody > 0 && req.ContentLength > maxBody {
+				return SendResp(c, echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+					"request body larger than "+strconv.FormatInt(maxBody, 10)+" bytes"))
+			}
+
+			if req.Body != nil && req.Body != http.NoBody {
+				var body io.ReadCloser = req.Body
+				if maxBody > 0 {
+					body = newLimitedBody(body, body, maxBody)
+				}
+
+				var decoder Decoder
+				if encoding := strings.ToLower(strings.TrimSpace(req.Header.Get(echo.HeaderContentEncoding))); conf.Decompress &&
+					encoding != "" && encoding != "identity" {
+					if decoder = getDecoder(encoding); decoder == nil && conf.RejectUnknownEncoding {
+						return SendResp(c, echo.NewHTTPError(http.StatusUnsupportedMediaType, "unsupported content encoding "+encoding))
+					}
+				}
+				if decoder != nil {
+					decoded, err := decoder(body)
+					if err != nil {
+						return SendResp(c, BodyReadError(err))
+					}
+					body = &decodedBody{ReadCloser: decoded, raw: body}
+					if maxBody > 0 {
+						body = newLimitedBody(body, body, maxBody)
+					}
+					req.Header.Del(echo.HeaderContentEncoding)
+					req.Header.Del(echo.HeaderContentLength)
+					req.ContentLength = -1
+				}
+				req.Body = body
+			}
+
+			if timeout <= 0 {
+				return next(c)
+			}
+
+			ctx, cancel := context.WithTimeout(req.Context(), time.Duration(timeout)*time.Second)
+			defer cancel()
+			c.SetRequest(req.WithContext(ctx))
+			err := next(c)
+			if ctx.Err() == context.DeadlineExceeded && !c.Response().Committed &&
+				(err == nil || errors.Is(err, context.DeadlineExceeded)) {
+				return SendResp(c, echo.NewHTTPError(http.StatusServiceUnavailable, "handler timeout"))
+			}
+			return err
+		}
+	}
+}
+
+// SetLimits limits request bodies and handler deadlines of all routes, e.g. by DefaultLimitsConfig, must be called before Run.
+// No limits without SetLimits
+func (agw *ApiGateway) SetLimits(conf LimitsConfig) {
+	agw.limits = &conf
+}
@@ -0,0 +1,227 @@
+package httpx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	_, err := w.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func zstded(t *testing.T, s string) []byte {
+	w, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	defer w.Close()
+	return w.EncodeAll([]byte(s), nil)
+}
+
+func TestLimits(t *testing.T) {
+	type user struct {
+		Name string
+	}
+
+	e := echo.New()
+	e.Use(LimitsWithConfig(LimitsConfig{
+		MaxBody:    64,
+		Decompress: true,
+		Routes: []RouteLimit{
+			{Method: http.MethodPost, Path: "/v1/upload", MaxBody: 1024},
+			{Path: "/v1/slow", Timeout: 1},
+		},
+	}))
+	bind := func(c echo.Context) error {
+		u := &user{}
+		if err := BindAndValidate(c, u); err != nil {
+			return SendResp(c, err)
+		}
+		return c.String(http.StatusOK, u.Name)
+	}
+	e.POST("/v1/users", bind)
+	e.POST("/v1/upload", bind)
+	e.GET("/v1/slow", func(c echo.Context) error {
+		<-c.Request().Context().Done()
+		return c.Request().Context().Err()
+	})
+
+	serve := func(target string, body io.Reader, contentLength int64, encoding string) *httptest.ResponseRecorder {
+		method := http.MethodPost
+		if body == nil {
+			method = http.MethodGet
+		}
+		req := httptest.NewRequest(method, target, body)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.ContentLength = contentLength
+		if encoding != "" {
+			req.Header.Set(echo.HeaderContentEncoding, encoding)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	large := `{"Name":"` + strings.Repeat("a", 100) + `"}`
+	rec := serve("/v1/users", strings.NewReader(`{"Name":"alice"}`), 16, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "alice", rec.Body.String())
+
+	// declared, or found while reading chunked bodies
+	require.Equal(t, http.StatusRequestEntityTooLarge, serve("/v1/users", strings.NewReader(large), int64(len(large)), "").Code)
+	require.Equal(t, http.StatusRequestEntityTooLarge, serve("/v1/users", strings.NewReader(large), -1, "").Code)
+	require.Equal(t, http.StatusOK, serve("/v1/upload", strings.NewReader(large), int64(len(large)), "").Code)
+
+	// decompressed, and capped after decompression
+	body := gzipped(t, `{"Name":"bob"}`)
+	rec = serve("/v1/users", bytes.NewReader(body), int64(len(body)), "gzip")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "bob", rec.Body.String())
+	bomb := gzipped(t, `{"Name":"`+strings.Repeat("a", 10000)+`"}`)
+	require.Less(t, len(bomb), 64)
+	require.Equal(t, http.StatusRequestEntityTooLarge, serve("/v1/users", bytes.NewReader(bomb), int64(len(bomb)), "gzip").Code)
+	require.Equal(t, http.StatusBadRequest, serve("/v1/users", strings.NewReader("not gzip"), 8, "gzip").Code)
+	body = zstded(t, `{"Name":"carol"}`)
+	rec = serve("/v1/users", bytes.NewReader(body), int64(len(body)), "zstd")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "carol", rec.Body.String())
+	bomb = zstded(t, `{"Name":"`+strings.Repeat("a", 10000)+`"}`)
+	require.Less(t, len(bomb), 64)
+	require.Equal(t, http.StatusRequestEntityTooLarge, serve("/v1/users", bytes.NewReader(bomb), int64(len(bomb)), "zstd").Code)
+	// passed as is without RejectUnknownEncoding
+	rec = serve("/v1/users", strings.NewReader(`{"Name":"dave"}`), 15, "br")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "dave", rec.Body.String())
+
+	start := time.Now()
+	require.Equal(t, http.StatusServiceUnavailable, serve("/v1/slow", nil, 0, "").Code)
+	require.Less(t, time.Since(start), 3*time.Second)
+}
+
+func TestCompress(t *testing.T) {
+	e := echo.New()
+	e.Use(CompressWithConfig(CompressConfig{MinLength: 16}))
+	e.GET("/text/:n", func(c echo.Context) error {
+		if c.Param("n") == "small" {
+			return c.String(http.StatusOK, "tiny")
+		}
+		return c.String(http.StatusOK, strings.Repeat("compressible ", 100))
+	})
+
+	serve := func(target, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(echo.HeaderAcceptEncoding, acceptEncoding)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("/text/large", "br;q=1.0, gzip;q=0.8, deflate;q=0.9")
+	require.Equal(t, "deflate", rec.Header().Get(echo.HeaderContentEncoding))
+	require.Equal(t, echo.HeaderAcceptEncoding, rec.Header().Get(echo.HeaderVary))
+	zr, err := zlib.NewReader(rec.Body)
+	require.NoError(t, err)
+	plain, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("compressible ", 100), string(plain))
+
+	rec = serve("/text/large", "*")
+	require.Equal(t, "gzip", rec.Header().Get(echo.HeaderContentEncoding))
+	gr, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	plain, err = io.ReadAll(gr)
+	require.NoError(t, err)
+	require.Len(t, plain, 1300)
+
+	rec = serve("/text/large", "zstd, gzip;q=0.5")
+	require.Equal(t, "zstd", rec.Header().Get(echo.HeaderContentEncoding))
+	zsr, err := zstd.NewReader(rec.Body)
+	require.NoError(t, err)
+	defer zsr.Close()
+	plain, err = io.ReadAll(zsr)
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("compressible ", 100), string(plain))
+
+	rec = serve("/text/small", "gzip")
+	require.Empty(t, rec.Header().Get(echo.HeaderContentEncoding))
+	require.Equal(t, "tiny", rec.Body.String())
+
+	rec = serve("/text/large", "gzip;q=0, identity")
+	require.Empty(t, rec.Header().Get(echo.HeaderContentEncoding))
+	require.Len(t, rec.Body.String(), 1300)
+}
+
+func TestApiGatewayLimitsOptIn(t *testing.T) {
+	newGateway := func(limits *LimitsConfig) *ApiGateway {
+		agw, err := NewApiGateway(context.Background(), "127.0.0.1", "0", "test", &LogConfig{Level: "error"}, nil)
+		require.NoError(t, err)
+		if limits != nil {
+			agw.SetLimits(*limits)
+		}
+		agw.POST("/v1/users", func(c echo.Context) error {
+			u := &struct{ Name string }{}
+			if err := BindAndValidate(c, u); err != nil {
+				return SendResp(c, err)
+			}
+			return c.String(http.StatusOK, strconv.Itoa(len(u.Name)))
+		})
+		agw.configEcho()
+		return agw
+	}
+	large := `{"Name":"` + strings.Repeat("a", 5<<20) + `"}`
+	serve := func(agw *ApiGateway, body, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if encoding != "" {
+			req.Header.Set(echo.HeaderContentEncoding, encoding)
+		}
+		req.ContentLength = -1
+		rec := httptest.NewRecorder()
+		agw.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// no limits without SetLimits
+	agw := newGateway(nil)
+	rec := serve(agw, large, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, strconv.Itoa(5<<20), rec.Body.String())
+	require.Equal(t, http.StatusOK, serve(agw, `{"Name":"bob"}`, "br").Code)
+
+	agw = newGateway(&DefaultLimitsConfig)
+	require.Equal(t, http.StatusRequestEntityTooLarge, serve(agw, large, "").Code)
+	require.Equal(t, http.StatusOK, serve(agw, `{"Name":"bob"}`, "br").Code)
+
+	limits := DefaultLimitsConfig
+	limits.RejectUnknownEncoding = true
+	require.Equal(t, http.StatusUnsupportedMediaType, serve(newGateway(&limits), `{"Name":"bob"}`, "br").Code)
+}
+
+func TestServerTimeouts(t *testing.T) {
+	agw, err := NewApiGateway(context.Background(), "127.0.0.1", "0", "test", &LogConfig{Level: "error"}, nil)
+	require.NoError(t, err)
+	agw.SetTimeouts(TimeoutConfig{ReadHeader: 5, Read: 30, Write: 40, Idle: 60})
+	require.NoError(t, agw.AddListener(ListenerConfig{Name: "test", Addr: "127.0.0.1:0"}))
+
+	srv := agw.listeners[0].newServer(agw)
+	require.Equal(t, 5*time.Second, srv.ReadHeaderTimeout)
+	require.Equal(t, 30*time.Second, srv.ReadTimeout)
+	require.Equal(t, 40*time.Second, srv.WriteTimeout)
+	require.Equal(t, time.Minute, srv.IdleTimeout)
+}
@@ -0,0 +1,203 @@
+package httpx
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/log"
+)
+
+// ListenerConfig a listener of ApiGateway, e.g. a public TLS port plus an internal plain admin port
+type ListenerConfig struct {
+	Name string
+	Addr string // host:port
+	TLS  ServerTLSConfig
+	// DisableHttp2 serves HTTP/1.1 only, h2 is negotiated by ALPN over TLS by default
+	DisableHttp2 bool `vx_default:"false"`
+	// H2c serves HTTP/2 without TLS by prior knowledge, ignored if TLS is enabled
+	H2c bool `vx_default:"false"`
+	// Prefixes only paths under them are served by this listener, others get 404. All paths if empty
+	Prefixes []string
+}
+
+type listener struct {
+	conf     ListenerConfig
+	ln       net.Listener
+	server   *http.Server
+	reloader *certReloader
+}
+
+// AddListener serves on more addresses, must be called before Run.
+// Run listens on addr:port of NewApiGateway only if no listener is added
+func (agw *ApiGateway) AddListener(conf ListenerConfig) error {
+	if conf.Addr == "" {
+		return errors.Errorf("addr of listener %q is empty", conf.Name)
+	}
+
+	l := &listener{conf: conf}
+	if conf.TLS.Enable {
+		// ALPN is also used by GetConfigForClient of mTLS, set it explicitly
+		nextProtos := []string{"http/1.1"}
+		if !conf.DisableHttp2 {
+			nextProtos = []string{"h2", "http/1.1"}
+		}
+		r, err := newCertReloader(conf.TLS, nextProtos)
+		if err != nil {
+			return errors.Wrapf(err, "listener:%v", conf.Name)
+		}
+		l.reloader = r
+	}
+
+	agw.listeners = append(agw.listeners, l)
+	return nil
+}
+
+func (l *listener) newServer(agw *ApiGateway) *http.Server {
+	var handler http.Handler = agw.Echo
+	if len(l.conf.Prefixes) > 0 {
+		handler = prefixHandler(l.conf.Prefixes, handler)
+	}
+
+	srv := &http.Server{
+		Handler:     agw.tracker.wrap(l.conf.Name, handler),
+		ErrorLog:    agw.Echo.StdLogger,
+		ConnContext: agw.tracker.connContext,
+		ConnState:   agw.tracker.connState,
+	}
+	agw.timeouts.apply(srv)
+
+	protocols := &http.Protocols{}
+	protocols.SetHTTP1(true)
+	if l.reloader != nil {
+		srv.TLSConfig = l.reloader.base
+		protocols.SetHTTP2(!l.conf.DisableHttp2)
+	} else {
+		protocols.SetUnencryptedHTTP2(l.conf.H2c)
+	}
+	srv.Protocols = protocols
+	return srv
+}
+
+func prefixHandler(prefixes []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range prefixes {
+			p = strings.TrimSuffix(p, "/")
+			if r.URL.Path == p || strings.HasPrefix(r.URL.Path, p+"/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+}
+
+// serve listens on all listeners first, so that none is served if any address is in use.
+// It blocks until all servers are closed, and returns http.ErrServerClosed after shutdown like Echo.Start
+func (agw *ApiGateway) serve() error {
+	agw.listenMutex.Lock()
+	for _, l := range agw.listeners {
+		ln, err := net.Listen("tcp", l.conf.Addr)
+		if err != nil {
+			for _, opened := range agw.listeners {
+				if opened.ln != nil {
+					_ = opened.ln.Close()
+				}
+			}
+			agw.listenMutex.Unlock()
+			return errors.Wrapf(err, "listener:%v", l.conf.Name)
+		}
+		l.ln = ln
+		l.server = l.newServer(agw)
+	}
+	agw.listenMutex.Unlock()
+
+	var (
+		wg       sync.WaitGroup
+		errMutex sync.Mutex
+		firstErr error
+	)
+	for _, l := range agw.listeners {
+		wg.Add(1)
+		go func(l *listener) {
+			defer wg.Done()
+
+			var err error
+			log.Infof("ApiGateway %v listens on %v, name:%v, tls:%v", agw.name, l.ln.Addr(), l.conf.Name, l.reloader != nil)
+			if l.reloader != nil {
+				go l.reloader.reloadLoop(agw.ctx)
+				err = l.server.ServeTLS(l.ln, "", "")
+			} else {
+				err = l.server.Serve(l.ln)
+			}
+
+			errMutex.Lock()
+			if firstErr == nil || errors.Is(firstErr, http.ErrServerClosed) {
+				firstErr = err
+			}
+			errMutex.Unlock()
+		}(l)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// shutdownListeners closes listeners and waits for active connections concurrently
+func (agw *ApiGateway) shutdownListeners(ctx context.Context) error {
+	agw.listenMutex.Lock()
+	defer agw.listenMutex.Unlock()
+
+	var (
+		wg       sync.WaitGroup
+		errMutex sync.Mutex
+		firstErr error
+	)
+	for _, l := range agw.listeners {
+		if l.server == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(l *listener) {
+			defer wg.Done()
+			if err := l.server.Shutdown(ctx); err != nil {
+				errMutex.Lock()
+				if firstErr == nil {
+					firstErr = errors.Wrapf(err, "listener:%v", l.conf.Name)
+				}
+				errMutex.Unlock()
+			}
+		}(l)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// closeListeners closes all connections by force, except hijacked ones
+func (agw *ApiGateway) closeListeners() {
+	agw.listenMutex.Lock()
+	defer agw.listenMutex.Unlock()
+
+	for _, l := range agw.listeners {
+		if l.server != nil {
+			log.IgnoreErrf(l.server.Close(), "close listener %v", l.conf.Name)
+		}
+	}
+}
+
+// Addrs actual addresses listened on, for listeners with port 0
+func (agw *ApiGateway) Addrs() []net.Addr {
+	agw.listenMutex.Lock()
+	defer agw.listenMutex.Unlock()
+
+	var addrs []net.Addr
+	for _, l := range agw.listeners {
+		if l.ln != nil {
+			addrs = append(addrs, l.ln.Addr())
+		}
+	}
+	return addrs
+}
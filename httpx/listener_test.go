@@ -0,0 +1,157 @@
+package httpx
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCa bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"pkgx"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{cn},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if isCa {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+func (tc *testCert) write(t *testing.T, certFile, keyFile string) {
+	require.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tc.cert.Raw}), 0600))
+	if keyFile != "" {
+		der, err := x509.MarshalECPrivateKey(tc.key)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+	}
+}
+
+func (tc *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{tc.cert.Raw}, PrivateKey: tc.key}
+}
+
+func TestApiGatewayListeners(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt")
+	ca := newTestCert(t, "ca", nil, true)
+	ca.write(t, caFile, "")
+	newTestCert(t, "localhost", ca, false).write(t, certFile, keyFile)
+	client := newTestCert(t, "client-1", ca, false)
+
+	agw, err := NewApiGateway(context.Background(), "", "", "test", &LogConfig{Level: "error"}, nil)
+	require.Nil(t, err)
+	agw.GET("/api/whoami", func(c echo.Context) error {
+		id := GetClientIdentity(c)
+		if id == nil {
+			return c.String(http.StatusOK, "anonymous "+c.Request().Proto)
+		}
+		return c.String(http.StatusOK, id.CommonName+" "+c.Request().Proto)
+	})
+	agw.GET("/admin/ping", func(c echo.Context) error { return c.String(http.StatusOK, "pong "+c.Request().Proto) })
+
+	require.Nil(t, agw.AddListener(ListenerConfig{
+		Name: "public",
+		Addr: "127.0.0.1:0",
+		TLS:  ServerTLSConfig{Enable: true, CertFile: certFile, KeyFile: keyFile, ClientCaFile: caFile, ClientCertOptional: true},
+	}))
+	require.Nil(t, agw.AddListener(ListenerConfig{Name: "admin", Addr: "127.0.0.1:0", H2c: true, Prefixes: []string{"/admin"}}))
+	require.NotNil(t, agw.AddListener(ListenerConfig{Name: "bad", Addr: "127.0.0.1:0", TLS: ServerTLSConfig{Enable: true}}))
+
+	done := make(chan error)
+	go func() { done <- agw.Run() }()
+	require.Eventually(t, func() bool { return len(agw.Addrs()) == 2 }, time.Second, 10*time.Millisecond)
+	publicAddr, adminAddr := agw.Addrs()[0].String(), agw.Addrs()[1].String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(c *http.Client, url string) (int, string) {
+		resp, err := c.Get(url)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// h2 by ALPN, with and without client cert
+	tlsClient := &http.Client{Transport: &http.Transport{ForceAttemptHTTP2: true, TLSClientConfig: &tls.Config{
+		RootCAs: roots, ServerName: "localhost", Certificates: []tls.Certificate{client.tlsCert()}}}}
+	_, body := get(tlsClient, "https://"+publicAddr+"/api/whoami")
+	require.Equal(t, "client-1 HTTP/2.0", body)
+
+	anonymous := &http.Client{Transport: &http.Transport{ForceAttemptHTTP2: true, TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}}}
+	_, body = get(anonymous, "https://"+publicAddr+"/api/whoami")
+	require.Equal(t, "anonymous HTTP/2.0", body)
+
+	// h2c on the admin port, which serves /admin only
+	protocols := &http.Protocols{}
+	protocols.SetUnencryptedHTTP2(true)
+	h2cClient := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	_, body = get(h2cClient, "http://"+adminAddr+"/admin/ping")
+	require.Equal(t, "pong HTTP/2.0", body)
+	code, _ := get(h2cClient, "http://"+adminAddr+"/api/whoami")
+	require.Equal(t, http.StatusNotFound, code)
+
+	// cert is reloaded once files change
+	newTestCert(t, "localhost", ca, false).write(t, certFile, keyFile)
+	require.Nil(t, os.Chtimes(certFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+	reloader := agw.listeners[0].reloader
+	old := reloader.cert
+	served, err := reloader.base.GetConfigForClient(nil)
+	require.Nil(t, err)
+	require.Equal(t, []string{"h2", "http/1.1"}, served.NextProtos)
+	require.Nil(t, reloader.load())
+	require.NotSame(t, old, reloader.cert)
+
+	// built once per reload, not per handshake
+	reloaded, err := reloader.base.GetConfigForClient(nil)
+	require.Nil(t, err)
+	require.NotSame(t, served, reloaded)
+	again, err := reloader.base.GetConfigForClient(nil)
+	require.Nil(t, err)
+	require.Same(t, reloaded, again)
+	require.Equal(t, reloader.cert.Certificate, reloaded.Certificates[0].Certificate)
+	require.NotNil(t, reloaded.ClientCAs)
+	_, body = get(tlsClient, "https://"+publicAddr+"/api/whoami")
+	require.Equal(t, "client-1 HTTP/2.0", body)
+
+	require.Nil(t, agw.Stop())
+	require.ErrorIs(t, <-done, http.ErrServerClosed)
+}
@@ -0,0 +1,331 @@
+package httpx
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+type MetricsConfig struct {
+	Skipper   middleware.Skipper `json:"-"`
+	Namespace string             `vx_default:"http_server"` // prefix of metric names
+	Path      string             `vx_default:"/metrics"`    // route of the text exposition, used by ApiGateway.EnableMetrics
+	// LatencyBuckets upper bounds in seconds
+	LatencyBuckets []float64
+	// SizeBuckets upper bounds in bytes, of request and response sizes
+	SizeBuckets []float64
+}
+
+var (
+	DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	DefaultSizeBuckets    = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+)
+
+const routeUnmatched = "<unmatched>"
+
+// HttpMetrics aggregates requests by route template, method and status class,
+// and writes them in the text format of Prometheus without the client library
+type HttpMetrics struct {
+	conf MetricsConfig
+
+	mutex    sync.RWMutex
+	requests map[metricLabels]*requestSeries
+	inflight map[metricLabels]*atomic.Int64 // status is empty
+}
+
+type metricLabels struct {
+	route  string
+	method string
+	status string // 2xx, 4xx...
+}
+
+type requestSeries struct {
+	mutex    sync.Mutex
+	count    uint64
+	latency  histogram
+	reqSize  histogram
+	respSize histogram
+}
+
+type histogram struct {
+	counts []uint64 // cumulative counts are computed on output
+	sum    float64
+}
+
+func (h *histogram) observe(bounds []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(bounds)+1)
+	}
+	i := sort.SearchFloat64s(bounds, v)
+	h.counts[i]++
+	h.sum += v
+}
+
+func NewHttpMetrics(conf MetricsConfig) *HttpMetrics {
+	if conf.Skipper == nil {
+		conf.Skipper = middleware.DefaultSkipper
+	}
+	if conf.Namespace == "" {
+		conf.Namespace = "http_server"
+	}
+	if conf.Path == "" {
+		conf.Path = "/metrics"
+	}
+	if len(conf.LatencyBuckets) == 0 {
+		conf.LatencyBuckets = DefaultLatencyBuckets
+	}
+	if len(conf.SizeBuckets) == 0 {
+		conf.SizeBuckets = DefaultSizeBuckets
+	}
+	conf.LatencyBuckets = slices.Sorted(slices.Values(conf.LatencyBuckets))
+	conf.SizeBuckets = slices.Sorted(slices.Values(conf.SizeBuckets))
+
+	return &HttpMetrics{
+		conf:     conf,
+		requests: make(map[metricLabels]*requestSeries),
+		inflight: make(map[metricLabels]*atomic.Int64),
+	}
+}
+
+// normalizeMethod keeps cardinality bounded against arbitrary methods
+func normalizeMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+func (m *HttpMetrics) inflightOf(l metricLabels) *atomic.Int64 {
+	m.mutex.RLock()
+	p, ok := m.inflight[l]
+	m.mutex.RUnlock()
+	if ok {
+		return p
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if p, ok = m.inflight[l]; !ok {
+		p = &atomic.Int64{}
+		m.inflight[l] = p
+	}
+	return p
+}
+
+func (m *HttpMetrics) seriesOf(l metricLabels) *requestSeries {
+	m.mutex.RLock()
+	s, ok := m.requests[l]
+	m.mutex.RUnlock()
+	if ok {
+		return s
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if s, ok = m.requests[l]; !ok {
+		s = &requestSeries{}
+		m.requests[l] = s
+	}
+	return s
+}
+
+var notFoundHandler = reflect.ValueOf(echo.NotFoundHandler).Pointer()
+
+// isNotFound echo leaves the raw path in c.Path() if no route matches, which must not be a label
+func isNotFound(c echo.Context) bool {
+	return c.Path() == "" || reflect.ValueOf(c.Handler()).Pointer() == notFoundHandler
+}
+
+// Middleware records requests, the route template is known only after routing, so register it by Echo.Use
+func (m *HttpMetrics) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			if m.conf.Skipper(c) {
+				return next(c)
+			}
+
+			req := c.Request()
+			route := c.Path()
+			if isNotFound(c) {
+				route = routeUnmatched
+			}
+			labels := metricLabels{route: route, method: normalizeMethod(req.Method)}
+
+			inflight := m.inflightOf(labels)
+			inflight.Add(1)
+			start := time.Now()
+			defer func() {
+				inflight.Add(-1)
+
+				labels.status = statusClass(c.Response().Status)
+				s := m.seriesOf(labels)
+				s.mutex.Lock()
+				s.count++
+				s.latency.observe(m.conf.LatencyBuckets, time.Since(start).Seconds())
+				s.reqSize.observe(m.conf.SizeBuckets, float64(max(req.ContentLength, 0)))
+				s.respSize.observe(m.conf.SizeBuckets, float64(c.Response().Size))
+				s.mutex.Unlock()
+			}()
+
+			// render the error here, so that its status is recorded
+			if err = next(c); err != nil {
+				c.Error(err)
+			}
+			return nil
+		}
+	}
+}
+
+// Handler writes metrics in the text format of Prometheus
+func (m *HttpMetrics) Handler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+		c.Response().WriteHeader(http.StatusOK)
+		_, err := m.WriteTo(c.Response())
+		return err
+	}
+}
+
+type seriesSnapshot struct {
+	labels   metricLabels
+	count    uint64
+	latency  histogram
+	reqSize  histogram
+	respSize histogram
+}
+
+func (h histogram) clone() histogram {
+	return histogram{counts: append([]uint64(nil), h.counts...), sum: h.sum}
+}
+
+func (m *HttpMetrics) snapshot() ([]seriesSnapshot, map[metricLabels]int64) {
+	m.mutex.RLock()
+	series := make([]seriesSnapshot, 0, len(m.requests))
+	for l, s := range m.requests {
+		s.mutex.Lock()
+		series = append(series, seriesSnapshot{labels: l, count: s.count,
+			latency: s.latency.clone(), reqSize: s.reqSize.clone(), respSize: s.respSize.clone()})
+		s.mutex.Unlock()
+	}
+	inflight := make(map[metricLabels]int64, len(m.inflight))
+	for l, p := range m.inflight {
+		inflight[l] = p.Load()
+	}
+	m.mutex.RUnlock()
+
+	sort.Slice(series, func(i, j int) bool { return series[i].labels.less(series[j].labels) })
+	return series, inflight
+}
+
+func (l metricLabels) less(o metricLabels) bool {
+	if l.route != o.route {
+		return l.route < o.route
+	}
+	if l.method != o.method {
+		return l.method < o.method
+	}
+	return l.status < o.status
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (l metricLabels) String() string {
+	s := `method="` + labelEscaper.Replace(l.method) + `",route="` + labelEscaper.Replace(l.route) + `"`
+	if l.status != "" {
+		s += `,status="` + l.status + `"`
+	}
+	return s
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteTo writes metrics in the text format of Prometheus
+func (m *HttpMetrics) WriteTo(w io.Writer) (int64, error) {
+	series, inflight := m.snapshot()
+	ns := m.conf.Namespace
+	bw := bufio.NewWriter(w)
+	var n int64
+	write := func(s string) {
+		written, _ := bw.WriteString(s)
+		n += int64(written)
+	}
+
+	write("# HELP " + ns + "_requests_total Total number of HTTP requests.\n")
+	write("# TYPE " + ns + "_requests_total counter\n")
+	for _, s := range series {
+		write(ns + "_requests_total{" + s.labels.String() + "} " + strconv.FormatUint(s.count, 10) + "\n")
+	}
+
+	inflightLabels := make([]metricLabels, 0, len(inflight))
+	for l := range inflight {
+		inflightLabels = append(inflightLabels, l)
+	}
+	sort.Slice(inflightLabels, func(i, j int) bool { return inflightLabels[i].less(inflightLabels[j]) })
+	write("# HELP " + ns + "_requests_inflight Number of HTTP requests being served.\n")
+	write("# TYPE " + ns + "_requests_inflight gauge\n")
+	for _, l := range inflightLabels {
+		write(ns + "_requests_inflight{" + l.String() + "} " + strconv.FormatInt(inflight[l], 10) + "\n")
+	}
+
+	writeHistogram := func(name, help string, bounds []float64, get func(s *seriesSnapshot) histogram) {
+		write("# HELP " + ns + name + " " + help + "\n")
+		write("# TYPE " + ns + name + " histogram\n")
+		for i := range series {
+			h := get(&series[i])
+			labels := series[i].labels.String()
+			var cumulative uint64
+			for j := 0; j <= len(bounds); j++ {
+				bound := math.Inf(1)
+				if j < len(bounds) {
+					bound = bounds[j]
+				}
+				if j < len(h.counts) {
+					cumulative += h.counts[j]
+				}
+				write(ns + name + "_bucket{" + labels + `,le="` + formatFloat(bound) + `"} ` + strconv.FormatUint(cumulative, 10) + "\n")
+			}
+			write(ns + name + "_sum{" + labels + "} " + formatFloat(h.sum) + "\n")
+			write(ns + name + "_count{" + labels + "} " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+	}
+	writeHistogram("_request_duration_seconds", "Latency of HTTP requests in seconds.", m.conf.LatencyBuckets,
+		func(s *seriesSnapshot) histogram { return s.latency })
+	writeHistogram("_request_size_bytes", "Size of HTTP request bodies in bytes.", m.conf.SizeBuckets,
+		func(s *seriesSnapshot) histogram { return s.reqSize })
+	writeHistogram("_response_size_bytes", "Size of HTTP response bodies in bytes.", m.conf.SizeBuckets,
+		func(s *seriesSnapshot) histogram { return s.respSize })
+
+	return n, bw.Flush()
+}
+
+// EnableMetrics records all requests, and serves them at conf.Path. Not support dynamic change
+func (agw *ApiGateway) EnableMetrics(conf MetricsConfig) *HttpMetrics {
+	agw.metrics = NewHttpMetrics(conf)
+	return agw.metrics
+}
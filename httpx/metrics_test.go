@@ -0,0 +1,59 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpMetrics(t *testing.T) {
+	m := NewHttpMetrics(MetricsConfig{Namespace: "api", LatencyBuckets: []float64{1, 0.1}, SizeBuckets: []float64{10}})
+
+	e := echo.New()
+	e.Use(m.Middleware())
+	e.GET("/metrics", m.Handler())
+	e.POST("/v1/users/:id", func(c echo.Context) error { return c.String(http.StatusCreated, "created, long body") })
+	e.GET("/v1/fail", func(c echo.Context) error { return echo.NewHTTPError(http.StatusBadGateway, "boom") })
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+	serve(http.MethodPost, "/v1/users/1", "hi")
+	serve(http.MethodPost, "/v1/users/2", "")
+	require.Equal(t, http.StatusBadGateway, serve(http.MethodGet, "/v1/fail", "").Code)
+	serve(http.MethodGet, "/no/such/path", "")
+	serve("PURGE", "/v1/users/1", "")
+
+	rec := serve(http.MethodGet, "/metrics", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Header().Get(echo.HeaderContentType), "text/plain; version=0.0.4")
+	out := rec.Body.String()
+
+	for _, line := range []string{
+		"# TYPE api_requests_total counter",
+		`api_requests_total{method="POST",route="/v1/users/:id",status="2xx"} 2`,
+		`api_requests_total{method="GET",route="/v1/fail",status="5xx"} 1`,
+		`api_requests_total{method="GET",route="<unmatched>",status="4xx"} 1`,
+		`api_requests_total{method="OTHER",route="/v1/users/:id",status="4xx"} 1`,
+		// the scrape itself is in flight
+		`api_requests_inflight{method="GET",route="/metrics"} 1`,
+		`api_requests_inflight{method="POST",route="/v1/users/:id"} 0`,
+		"# TYPE api_request_duration_seconds histogram",
+		`api_request_duration_seconds_bucket{method="POST",route="/v1/users/:id",status="2xx",le="0.1"} 2`,
+		`api_request_duration_seconds_bucket{method="POST",route="/v1/users/:id",status="2xx",le="+Inf"} 2`,
+		`api_request_duration_seconds_count{method="POST",route="/v1/users/:id",status="2xx"} 2`,
+		`api_request_size_bytes_bucket{method="POST",route="/v1/users/:id",status="2xx",le="10"} 2`,
+		`api_request_size_bytes_sum{method="POST",route="/v1/users/:id",status="2xx"} 2`,
+		`api_response_size_bytes_bucket{method="POST",route="/v1/users/:id",status="2xx",le="10"} 0`,
+		`api_response_size_bytes_sum{method="POST",route="/v1/users/:id",status="2xx"} 36`,
+	} {
+		require.Contains(t, out, line+"\n")
+	}
+	require.NotContains(t, out, "/v1/users/1")
+}
@@ -59,7 +59,7 @@ func TestRawStruct(t *testing.T) {
 	require.Equal(t, "", jr.Cause())
 
 	errString := "this is error"
-	_ = jr.WithErrorf(errString)
+	_ = jr.WithErrorf("%s", errString)
 	require.Equal(t, "", jr.Message)
 	require.Equal(t, n, len(jr.StackTrace()))
 	require.Equal(t, n, len(jr.Frames()))
@@ -0,0 +1,279 @@
+package httpx
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/madlabx/pkgx/errors"
+)
+
+// CorsConfig CORS of a route group, CORS headers are not sent if neither AllowOrigins nor AllowOriginRegexes is set
+type CorsConfig struct {
+	AllowOrigins       []string // exact origins like https://a.example.com, "*" allows any
+	AllowOriginRegexes []string // e.g. ^https://[a-z0-9-]+\.example\.com$
+	AllowMethods       []string `vx_default:"GET,HEAD,PUT,PATCH,POST,DELETE"` // "*" reflects the requested method
+	AllowHeaders       []string // empty or "*" reflects the requested headers
+	ExposeHeaders      []string
+	AllowCredentials   bool // requires exact origins or regexes, not allowed with "*"
+	MaxAge             int  //in sec, cache time of preflight responses, 0 means not sent
+}
+
+// SecureHeadersConfig security headers of a route group, empty values are not sent
+type SecureHeadersConfig struct {
+	ContentSecurityPolicy     string
+	CspReportOnly             bool // send Content-Security-Policy-Report-Only instead
+	HstsMaxAge                int  //in sec, 0 disables HSTS, only sent over https
+	HstsIncludeSubdomains     bool
+	HstsPreload               bool
+	XFrameOptions             string // DENY or SAMEORIGIN
+	ContentTypeNosniff        bool
+	XssProtection             string // e.g. "1; mode=block", "0" for modern browsers
+	ReferrerPolicy            string // e.g. strict-origin-when-cross-origin
+	PermissionsPolicy         string
+	CrossOriginOpenerPolicy   string
+	CrossOriginResourcePolicy string
+}
+
+type SecurityPolicy struct {
+	Cors    CorsConfig
+	Headers SecureHeadersConfig
+}
+
+// GroupSecurityPolicy applies to paths under Prefix, e.g. /api/v1 matches /api/v1 and /api/v1/users
+type GroupSecurityPolicy struct {
+	Prefix string
+	Policy SecurityPolicy
+}
+
+// SecurityConfig CORS and security headers of ApiGateway. The group with the longest matching prefix
+// replaces Default as a whole, settings are not merged
+type SecurityConfig struct {
+	Default SecurityPolicy
+	Groups  []GroupSecurityPolicy
+}
+
+// DefaultSecurityConfig is used by ApiGateway without SetSecurity, allows any origin without credentials,
+// so that browsers do not send cookies or authorization cross-origin
+var DefaultSecurityConfig = SecurityConfig{
+	Default: SecurityPolicy{
+		Cors: CorsConfig{
+			AllowOrigins:  []string{"*"},
+			AllowMethods:  []string{"*"},
+			AllowHeaders:  []string{"*"},
+			ExposeHeaders: []string{"*"},
+		},
+	},
+}
+
+type compiledPolicy struct {
+	prefix         string
+	cors           CorsConfig
+	originRegexes  []*regexp.Regexp
+	allowMethods   string
+	allowHeaders   string
+	exposeHeaders  string
+	maxAge         string
+	anyOrigin      bool
+	corsEnabled    bool
+	reflectMethods bool
+	reflectHeaders bool
+	headers        SecureHeadersConfig
+	hsts           string
+}
+
+func compilePolicy(prefix string, p SecurityPolicy) (*compiledPolicy, error) {
+	cp := &compiledPolicy{
+		prefix:        strings.TrimSuffix(prefix, "/"),
+		cors:          p.Cors,
+		headers:       p.Headers,
+		exposeHeaders: strings.Join(p.Cors.ExposeHeaders, ","),
+	}
+
+	for _, expr := range p.Cors.AllowOriginRegexes {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.Errorf("invalid origin regex %q of %q: %v", expr, prefix, err)
+		}
+		cp.originRegexes = append(cp.originRegexes, re)
+	}
+	cp.anyOrigin = slices.Contains(p.Cors.AllowOrigins, "*")
+	if cp.anyOrigin && p.Cors.AllowCredentials {
+		// any site could act as the user
+		return nil, errors.Errorf("AllowCredentials of %q requires explicit origins instead of \"*\"", prefix)
+	}
+	cp.corsEnabled = len(p.Cors.AllowOrigins) > 0 || len(cp.originRegexes) > 0
+
+	methods := p.Cors.AllowMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete}
+	}
+	cp.reflectMethods = slices.Contains(methods, "*")
+	cp.allowMethods = strings.Join(methods, ",")
+	cp.reflectHeaders = len(p.Cors.AllowHeaders) == 0 || slices.Contains(p.Cors.AllowHeaders, "*")
+	cp.allowHeaders = strings.Join(p.Cors.AllowHeaders, ",")
+	if p.Cors.MaxAge > 0 {
+		cp.maxAge = strconv.Itoa(p.Cors.MaxAge)
+	}
+
+	if h := p.Headers; h.HstsMaxAge > 0 {
+		cp.hsts = "max-age=" + strconv.Itoa(h.HstsMaxAge)
+		if h.HstsIncludeSubdomains {
+			cp.hsts += "; includeSubDomains"
+		}
+		if h.HstsPreload {
+			cp.hsts += "; preload"
+		}
+	}
+
+	return cp, nil
+}
+
+func (cp *compiledPolicy) match(path string) bool {
+	return cp.prefix == "" || path == cp.prefix || strings.HasPrefix(path, cp.prefix+"/")
+}
+
+func (cp *compiledPolicy) allowOrigin(origin string) bool {
+	if cp.anyOrigin || slices.Contains(cp.cors.AllowOrigins, origin) {
+		return true
+	}
+	for _, re := range cp.originRegexes {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// SecurityWithConfig sets CORS and security headers per route group. It should be registered by Echo.Use
+// rather than Group.Use, so that preflight requests of routes without OPTIONS handler are answered as well
+func SecurityWithConfig(conf SecurityConfig) (echo.MiddlewareFunc, error) {
+	root, err := compilePolicy("", conf.Default)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*compiledPolicy, 0, len(conf.Groups))
+	for _, g := range conf.Groups {
+		if g.Prefix == "" || g.Prefix[0] != '/' {
+			return nil, errors.Errorf("invalid prefix of security group: %q", g.Prefix)
+		}
+		cp, err := compilePolicy(g.Prefix, g.Policy)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, cp)
+	}
+	// longest prefix first
+	slices.SortStableFunc(groups, func(a, b *compiledPolicy) int { return len(b.prefix) - len(a.prefix) })
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			cp := root
+			path := c.Request().URL.Path
+			for _, g := range groups {
+				if g.match(path) {
+					cp = g
+					break
+				}
+			}
+
+			cp.setSecureHeaders(c)
+			if !cp.corsEnabled {
+				return next(c)
+			}
+			return cp.handleCors(c, next)
+		}
+	}, nil
+}
+
+func (cp *compiledPolicy) setSecureHeaders(c echo.Context) {
+	h := c.Response().Header()
+	conf := &cp.headers
+
+	if conf.ContentSecurityPolicy != "" {
+		if conf.CspReportOnly {
+			h.Set("Content-Security-Policy-Report-Only", conf.ContentSecurityPolicy)
+		} else {
+			h.Set(echo.HeaderContentSecurityPolicy, conf.ContentSecurityPolicy)
+		}
+	}
+	if cp.hsts != "" && c.Scheme() == "https" {
+		h.Set(echo.HeaderStrictTransportSecurity, cp.hsts)
+	}
+	if conf.XFrameOptions != "" {
+		h.Set(echo.HeaderXFrameOptions, conf.XFrameOptions)
+	}
+	if conf.ContentTypeNosniff {
+		h.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	}
+	if conf.XssProtection != "" {
+		h.Set(echo.HeaderXXSSProtection, conf.XssProtection)
+	}
+	if conf.ReferrerPolicy != "" {
+		h.Set("Referrer-Policy", conf.ReferrerPolicy)
+	}
+	if conf.PermissionsPolicy != "" {
+		h.Set("Permissions-Policy", conf.PermissionsPolicy)
+	}
+	if conf.CrossOriginOpenerPolicy != "" {
+		h.Set("Cross-Origin-Opener-Policy", conf.CrossOriginOpenerPolicy)
+	}
+	if conf.CrossOriginResourcePolicy != "" {
+		h.Set("Cross-Origin-Resource-Policy", conf.CrossOriginResourcePolicy)
+	}
+}
+
+func (cp *compiledPolicy) handleCors(c echo.Context, next echo.HandlerFunc) error {
+	req := c.Request()
+	h := c.Response().Header()
+	origin := req.Header.Get(echo.HeaderOrigin)
+	preflight := req.Method == http.MethodOptions && req.Header.Get(echo.HeaderAccessControlRequestMethod) != ""
+
+	h.Add(echo.HeaderVary, echo.HeaderOrigin)
+	if origin == "" || !cp.allowOrigin(origin) {
+		if preflight {
+			// without CORS headers, the browser fails the request
+			return c.NoContent(http.StatusNoContent)
+		}
+		return next(c)
+	}
+
+	if cp.anyOrigin {
+		h.Set(echo.HeaderAccessControlAllowOrigin, "*")
+	} else {
+		h.Set(echo.HeaderAccessControlAllowOrigin, origin)
+	}
+	if cp.cors.AllowCredentials {
+		h.Set(echo.HeaderAccessControlAllowCredentials, "true")
+	}
+
+	if !preflight {
+		if cp.exposeHeaders != "" {
+			h.Set(echo.HeaderAccessControlExposeHeaders, cp.exposeHeaders)
+		}
+		return next(c)
+	}
+
+	h.Add(echo.HeaderVary, echo.HeaderAccessControlRequestMethod)
+	h.Add(echo.HeaderVary, echo.HeaderAccessControlRequestHeaders)
+	if cp.reflectMethods {
+		h.Set(echo.HeaderAccessControlAllowMethods, req.Header.Get(echo.HeaderAccessControlRequestMethod))
+	} else {
+		h.Set(echo.HeaderAccessControlAllowMethods, cp.allowMethods)
+	}
+	if cp.reflectHeaders {
+		if reqHeaders := req.Header.Get(echo.HeaderAccessControlRequestHeaders); reqHeaders != "" {
+			h.Set(echo.HeaderAccessControlAllowHeaders, reqHeaders)
+		}
+	} else {
+		h.Set(echo.HeaderAccessControlAllowHeaders, cp.allowHeaders)
+	}
+	if cp.maxAge != "" {
+		h.Set(echo.HeaderAccessControlMaxAge, cp.maxAge)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
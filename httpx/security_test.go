@@ -0,0 +1,133 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/require"
+)
+
+func newSecurityEcho(t *testing.T, conf SecurityConfig) *echo.Echo {
+	m, err := SecurityWithConfig(conf)
+	require.Nil(t, err)
+
+	e := echo.New()
+	e.Use(m)
+	ok := func(c echo.Context) error { return c.String(http.StatusOK, "ok") }
+	e.GET("/public/css", ok)
+	e.GET("/api/v1/users", ok)
+	e.POST("/api/v1/users", ok)
+	return e
+}
+
+func serveSecurity(e *echo.Echo, method, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSecurityPerGroup(t *testing.T) {
+	e := newSecurityEcho(t, SecurityConfig{
+		Default: SecurityPolicy{
+			Headers: SecureHeadersConfig{ContentTypeNosniff: true, ReferrerPolicy: "no-referrer"},
+		},
+		Groups: []GroupSecurityPolicy{
+			{Prefix: "/api", Policy: SecurityPolicy{Headers: SecureHeadersConfig{XFrameOptions: "SAMEORIGIN"}}},
+			{Prefix: "/api/v1", Policy: SecurityPolicy{
+				Cors: CorsConfig{
+					AllowOrigins:       []string{"https://app.example.com"},
+					AllowOriginRegexes: []string{`^https://[a-z0-9-]+\.dev\.example\.com$`},
+					AllowHeaders:       []string{"Authorization", "Content-Type"},
+					ExposeHeaders:      []string{"X-Request-Id"},
+					AllowCredentials:   true,
+					MaxAge:             600,
+				},
+				Headers: SecureHeadersConfig{
+					ContentSecurityPolicy: "default-src 'self'",
+					XFrameOptions:         "DENY",
+					HstsMaxAge:            31536000,
+				},
+			}},
+		},
+	})
+
+	rec := serveSecurity(e, http.MethodGet, "/public/css", map[string]string{echo.HeaderOrigin: "https://app.example.com"})
+	require.Equal(t, "nosniff", rec.Header().Get(echo.HeaderXContentTypeOptions))
+	require.Equal(t, "no-referrer", rec.Header().Get("Referrer-Policy"))
+	require.Empty(t, rec.Header().Get(echo.HeaderContentSecurityPolicy))
+	require.Empty(t, rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+
+	// the longest prefix wins, nothing is merged from default
+	rec = serveSecurity(e, http.MethodGet, "/api/v1/users", map[string]string{
+		echo.HeaderOrigin:          "https://feature-1.dev.example.com",
+		echo.HeaderXForwardedProto: "https",
+	})
+	require.Equal(t, "DENY", rec.Header().Get(echo.HeaderXFrameOptions))
+	require.Equal(t, "default-src 'self'", rec.Header().Get(echo.HeaderContentSecurityPolicy))
+	require.Equal(t, "max-age=31536000", rec.Header().Get(echo.HeaderStrictTransportSecurity))
+	require.Empty(t, rec.Header().Get(echo.HeaderXContentTypeOptions))
+	require.Equal(t, "https://feature-1.dev.example.com", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	require.Equal(t, "true", rec.Header().Get(echo.HeaderAccessControlAllowCredentials))
+	require.Equal(t, "X-Request-Id", rec.Header().Get(echo.HeaderAccessControlExposeHeaders))
+
+	// /apiv1 is not under /api
+	rec = serveSecurity(e, http.MethodGet, "/apiv1", nil)
+	require.Empty(t, rec.Header().Get(echo.HeaderXFrameOptions))
+
+	// preflight of a route without OPTIONS handler
+	rec = serveSecurity(e, http.MethodOptions, "/api/v1/users", map[string]string{
+		echo.HeaderOrigin:                      "https://app.example.com",
+		echo.HeaderAccessControlRequestMethod:  http.MethodPost,
+		echo.HeaderAccessControlRequestHeaders: "Authorization",
+	})
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "https://app.example.com", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	require.Equal(t, "GET,HEAD,PUT,PATCH,POST,DELETE", rec.Header().Get(echo.HeaderAccessControlAllowMethods))
+	require.Equal(t, "Authorization,Content-Type", rec.Header().Get(echo.HeaderAccessControlAllowHeaders))
+	require.Equal(t, "600", rec.Header().Get(echo.HeaderAccessControlMaxAge))
+	require.Empty(t, rec.Header().Get(echo.HeaderStrictTransportSecurity))
+
+	// origin not allowed
+	rec = serveSecurity(e, http.MethodOptions, "/api/v1/users", map[string]string{
+		echo.HeaderOrigin:                     "https://evil.com",
+		echo.HeaderAccessControlRequestMethod: http.MethodPost,
+	})
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Empty(t, rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+}
+
+func TestSecurityDefault(t *testing.T) {
+	e := newSecurityEcho(t, DefaultSecurityConfig)
+
+	rec := serveSecurity(e, http.MethodOptions, "/api/v1/users", map[string]string{
+		echo.HeaderOrigin:                      "https://any.com",
+		echo.HeaderAccessControlRequestMethod:  http.MethodPut,
+		echo.HeaderAccessControlRequestHeaders: "X-Custom",
+	})
+	require.Equal(t, "*", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	require.Empty(t, rec.Header().Get(echo.HeaderAccessControlAllowCredentials))
+	require.Equal(t, http.MethodPut, rec.Header().Get(echo.HeaderAccessControlAllowMethods))
+	require.Equal(t, "X-Custom", rec.Header().Get(echo.HeaderAccessControlAllowHeaders))
+
+	// an arbitrary origin never gets credentials
+	rec = serveSecurity(e, http.MethodGet, "/api/v1/users", map[string]string{
+		echo.HeaderOrigin: "https://evil.com",
+		echo.HeaderCookie: "session=1",
+	})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "*", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	require.Empty(t, rec.Header().Get(echo.HeaderAccessControlAllowCredentials))
+
+	_, err := SecurityWithConfig(SecurityConfig{Default: SecurityPolicy{Cors: CorsConfig{AllowOriginRegexes: []string{"("}}}})
+	require.NotNil(t, err)
+	_, err = SecurityWithConfig(SecurityConfig{Groups: []GroupSecurityPolicy{{Prefix: "api"}}})
+	require.NotNil(t, err)
+	_, err = SecurityWithConfig(SecurityConfig{Default: SecurityPolicy{Cors: CorsConfig{AllowOrigins: []string{"*"}, AllowCredentials: true}}})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,199 @@
+package httpx
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/log"
+)
+
+// ServerTLSConfig TLS of a listener, cert and key files are reloaded once changed
+type ServerTLSConfig struct {
+	Enable   bool `vx_default:"false"`
+	CertFile string
+	KeyFile  string
+	// ClientCaFile enables mTLS, client certificates are verified against it
+	ClientCaFile string
+	// ClientCertOptional accepts clients without certificate, those with an invalid one are still rejected
+	ClientCertOptional bool   `vx_default:"false"`
+	MinVersion         string `vx_default:"1.2" vx_range:"oneof=1.2 1.3"`
+	ReloadInterval     int    `vx_default:"10"` //in sec, interval of checking changes of files, 0 disables reloading
+}
+
+// certReloader serves the cert and client CAs loaded last, the old ones are kept if reloading fails
+type certReloader struct {
+	conf ServerTLSConfig
+	base *tls.Config
+
+	mutex  sync.RWMutex
+	cert   *tls.Certificate
+	served *tls.Config // base with the cert and client CAs, built once per load
+	stamps map[string]fileStamp
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// newCertReloader nextProtos is ALPN of the listener, base must not be changed after creation
+func newCertReloader(conf ServerTLSConfig, nextProtos []string) (*certReloader, error) {
+	if conf.CertFile == "" || conf.KeyFile == "" {
+		return nil, errors.New("CertFile and KeyFile are required by TLS")
+	}
+
+	r := &certReloader{conf: conf}
+	r.base = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mutex.RLock()
+			defer r.mutex.RUnlock()
+			return r.cert, nil
+		},
+	}
+	if conf.MinVersion == "1.3" {
+		r.base.MinVersion = tls.VersionTLS13
+	}
+	if conf.ClientCaFile != "" {
+		r.base.ClientAuth = tls.RequireAndVerifyClientCert
+		if conf.ClientCertOptional {
+			r.base.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		// the client CA pool may be reloaded as well
+		r.base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mutex.RLock()
+			defer r.mutex.RUnlock()
+			return r.served, nil
+		}
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.conf.CertFile, r.conf.KeyFile}
+	if r.conf.ClientCaFile != "" {
+		files = append(files, r.conf.ClientCaFile)
+	}
+	return files
+}
+
+func (r *certReloader) changed() (bool, map[string]fileStamp, error) {
+	stamps := make(map[string]fileStamp)
+	changed := false
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return false, nil, errors.Wrap(err)
+		}
+		stamps[f] = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+		if stamps[f] != r.stamps[f] {
+			changed = true
+		}
+	}
+	return changed, stamps, nil
+}
+
+func (r *certReloader) load() error {
+	changed, stamps, err := r.changed()
+	if err != nil || !changed {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.conf.CertFile, r.conf.KeyFile)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	var pool *x509.CertPool
+	if r.conf.ClientCaFile != "" {
+		ca, err := os.ReadFile(r.conf.ClientCaFile)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return errors.Errorf("no valid certificate in ClientCaFile:%v", r.conf.ClientCaFile)
+		}
+	}
+
+	served := r.base.Clone()
+	served.GetConfigForClient = nil
+	served.GetCertificate = nil
+	served.Certificates = []tls.Certificate{cert}
+	served.ClientCAs = pool
+
+	r.mutex.Lock()
+	r.cert = &cert
+	r.served = served
+	r.stamps = stamps
+	r.mutex.Unlock()
+	return nil
+}
+
+func (r *certReloader) reloadLoop(ctx context.Context) {
+	if r.conf.ReloadInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(r.conf.ReloadInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.load(); err != nil {
+				log.Errorf("Failed to reload cert %v, keep the old one, err:%v", r.conf.CertFile, err)
+			}
+		}
+	}
+}
+
+// ClientIdentity identity of a client verified by mTLS
+type ClientIdentity struct {
+	CommonName   string
+	Organization []string
+	DNSNames     []string
+	Emails       []string
+	URIs         []string // e.g. SPIFFE ID
+	SerialNumber string
+	Issuer       string
+	Fingerprint  string // hex of sha256 of the certificate
+}
+
+// GetClientIdentity returns nil if the client did not present a verified certificate
+func GetClientIdentity(c echo.Context) *ClientIdentity {
+	state := c.Request().TLS
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := state.VerifiedChains[0][0]
+	fp := sha256.Sum256(cert.Raw)
+	id := &ClientIdentity{
+		CommonName:   cert.Subject.CommonName,
+		Organization: cert.Subject.Organization,
+		DNSNames:     cert.DNSNames,
+		Emails:       cert.EmailAddresses,
+		SerialNumber: cert.SerialNumber.String(),
+		Issuer:       cert.Issuer.String(),
+		Fingerprint:  hex.EncodeToString(fp[:]),
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+	return id
+}
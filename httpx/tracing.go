@@ -0,0 +1,102 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/madlabx/pkgx/tracex"
+)
+
+type TracingConfig struct {
+	Skipper middleware.Skipper `json:"-"`
+	// Tracer exports server spans and client spans started in handlers, tracex.Default() if nil
+	Tracer *tracex.Tracer `json:"-"`
+	// IgnoreIncoming starts new traces regardless of traceparent, for endpoints facing untrusted clients
+	IgnoreIncoming bool `vx_default:"false"`
+}
+
+// TracingWithConfig extracts the W3C trace context of requests or starts a new trace, and stores the server span
+// in the request context, so that clients with the context propagate it, see JsonClient.WithContext
+func TracingWithConfig(conf TracingConfig) echo.MiddlewareFunc {
+	if conf.Skipper == nil {
+		conf.Skipper = middleware.DefaultSkipper
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			if conf.Skipper(c) {
+				return next(c)
+			}
+
+			tracer := conf.Tracer
+			if tracer == nil {
+				tracer = tracex.Default()
+			}
+
+			req := c.Request()
+			ctx := req.Context()
+			if sc, ok := tracex.Extract(req.Header); ok && !conf.IgnoreIncoming {
+				ctx = tracex.ContextWithRemoteSpanContext(ctx, sc)
+			}
+
+			route := c.Path()
+			if isNotFound(c) {
+				route = routeUnmatched
+			}
+			ctx, span := tracer.Start(ctx, req.Method+" "+route, tracex.SpanKindServer)
+			defer span.End()
+			span.SetAttribute("http.request.method", req.Method)
+			span.SetAttribute("http.route", route)
+			span.SetAttribute("url.path", req.URL.Path)
+			span.SetAttribute("client.address", GetRealIp(req))
+			c.SetRequest(req.WithContext(ctx))
+
+			// render the error here, so that its status is recorded
+			if err = next(c); err != nil {
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			span.SetAttribute("http.response.status_code", status)
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(tracex.StatusError, http.StatusText(status))
+			}
+			return nil
+		}
+	}
+}
+
+// EnableTracing traces all requests, the access log gets trace_id and span_id tags. Not support dynamic change
+func (agw *ApiGateway) EnableTracing(conf TracingConfig) {
+	agw.tracing = &conf
+}
+
+// startClientSpan starts a client span if ctx is traced, and injects it into header
+func startClientSpan(ctx context.Context, method, url string, header http.Header) *tracex.Span {
+	if ctx == nil || !tracex.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+
+	ctx, span := tracex.Start(ctx, method, tracex.SpanKindClient)
+	span.SetAttribute("http.request.method", method)
+	span.SetAttribute("url.full", url)
+	tracex.Inject(ctx, header)
+	return span
+}
+
+func endClientSpan(span *tracex.Span, status int, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttribute("http.response.status_code", status)
+		if status >= http.StatusBadRequest {
+			span.SetStatus(tracex.StatusError, http.StatusText(status))
+		}
+	}
+	span.End()
+}
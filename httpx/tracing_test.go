@@ -0,0 +1,83 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/madlabx/pkgx/tracex"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracing(t *testing.T) {
+	var downstream []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstream = append(downstream, r.Header.Get(tracex.HeaderTraceparent))
+		w.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer backend.Close()
+
+	exporter := tracex.NewInMemoryExporter()
+	tracer := tracex.NewTracer(context.Background(), tracex.Config{}, exporter)
+	accessLog := &bytes.Buffer{}
+
+	e := echo.New()
+	e.Use(TracingWithConfig(TracingConfig{Tracer: tracer}))
+	e.Use(LoggerWithConfig(LoggerConfig{
+		OutBodyFilter: DefaultOutBodyFilter,
+		Timing:        AccessLogAfterRun,
+		FormatAfter:   "${trace_id} ${span_id} ${status}",
+		Output:        accessLog,
+	}))
+	e.GET("/v1/proxy/:id", func(c echo.Context) error {
+		ctx := c.Request().Context()
+		jc := NewJsonClient("", 0, 1000)
+		if _, err := jc.WithContext(ctx).Get(backend.URL, nil); err != nil {
+			return err
+		}
+		if _, _, err := NewClientWithTimeout(1).WithContext(ctx).HttpGetBody(backend.URL); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusOK)
+	})
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/v1/proxy/1", nil)
+	req.Header.Set(tracex.HeaderTraceparent, parent)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 3)
+	server := spans[2]
+	require.Equal(t, "GET /v1/proxy/:id", server.Name)
+	require.Equal(t, tracex.SpanKindServer, server.Kind)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.TraceId.String())
+	require.Equal(t, "00f067aa0ba902b7", server.ParentSpanId.String())
+	require.Equal(t, http.StatusOK, server.Attributes["http.response.status_code"])
+
+	// each client call is a child span of the server span, and is propagated downstream
+	require.Len(t, downstream, 2)
+	for i, client := range spans[:2] {
+		require.Equal(t, tracex.SpanKindClient, client.Kind)
+		require.Equal(t, server.SpanId, client.ParentSpanId)
+		require.Equal(t, client.Traceparent(), downstream[i])
+	}
+
+	require.Equal(t, server.TraceId.String()+" "+server.SpanId.String()+" 200", strings.TrimSpace(accessLog.String()))
+
+	// a new trace without traceparent
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/no/such/path", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	lines := strings.Split(strings.TrimSpace(accessLog.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Len(t, strings.Fields(lines[1])[0], 32)
+}
@@ -1,13 +1,19 @@
 package log
 
 import (
+	"context"
 	"fmt"
 	"io"
 
+	"github.com/madlabx/pkgx/tracex"
 	"github.com/sirupsen/logrus"
 )
 
-const keyModule = "mod"
+const (
+	keyModule  = "mod"
+	KeyTraceId = "trace_id"
+	KeySpanId  = "span_id"
+)
 
 func init() {
 	SetFormatter(&TextFormatter{
@@ -35,6 +41,10 @@ func SetLevel(level logrus.Level) {
 	logrus.SetLevel(level)
 }
 
+func GetLevel() logrus.Level {
+	return logrus.GetLevel()
+}
+
 func SetFormatter(formatter logrus.Formatter) {
 	logrus.SetFormatter(formatter)
 }
@@ -55,6 +65,15 @@ func WithFields(fields logrus.Fields) *logrus.Entry {
 	return logrus.WithFields(fields)
 }
 
+// WithContext adds trace_id and span_id of the current span in ctx, e.g. c.Request().Context() in handlers
+func WithContext(ctx context.Context) *logrus.Entry {
+	entry := logrus.WithContext(ctx)
+	if sc := tracex.SpanContextFromContext(ctx); sc.IsValid() {
+		entry = entry.WithFields(logrus.Fields{KeyTraceId: sc.TraceId.String(), KeySpanId: sc.SpanId.String()})
+	}
+	return entry
+}
+
 func Debug(args ...interface{}) {
 	logrus.Debug(args...)
 }
@@ -10,4 +10,5 @@ var (
 	ErrExpired           = errors.New("expired record")
 	ErrInvalidRecordType = errors.New("invalid record type")
 	ErrNotFound          = gorm.ErrRecordNotFound
+	ErrCorruptedSnapshot = errors.New("corrupted snapshot")
 )
@@ -5,4 +5,6 @@ type KvDbClientIf interface {
 	Get(filterAndDest any) error
 	ListWithKeyPrefix(dest any, filter any, keyFieldName, keyPrefix string) error
 	DeleteExpired(filter any) error
+	Delete(filter any) error
+	DeleteWithKeyPrefix(filter any, keyFieldName, keyPrefix string) error
 }
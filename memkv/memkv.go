@@ -4,11 +4,11 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/google/go-cmp/cmp"
 	"github.com/madlabx/pkgx/cachestore"
 	"github.com/madlabx/pkgx/errors"
 	"github.com/madlabx/pkgx/log"
@@ -20,25 +20,62 @@ import (
 // Cache 结构，用于内存缓存
 type Cache struct {
 	ctx     context.Context
+	cancel  context.CancelFunc
 	items   *sync.Map
 	db      KvDbClientIf
 	records []any
 	conf    CacheConf
+
+	tagMutex sync.Mutex
+	tags     map[string]map[string]cachestore.Record // tag -> key -> record
+	keyTags  map[string][]string                     // key -> tags, replaced on write
+
+	snapshotMutex sync.Mutex
+	snapshotDone  chan struct{}
+
+	watchers watcherSet
+}
+
+// item is the value of a record in memory, with expireAt of the record, 0 means never expire
+type item struct {
+	value    string
+	expireAt int64
+}
+
+func newItem(rt cachestore.Record) *item {
+	return &item{value: rt.GetValue(), expireAt: rt.GetExpireAt()}
+}
+
+func (it *item) expired(now int64) bool {
+	return it.expireAt != 0 && now > it.expireAt
 }
 
 type CacheConf struct {
 	GcInterval int `vx_default:"10"` //in sec
+	Snapshot   SnapshotConf
 }
 
 // NewCache 创建一个新的缓存实例
 func NewCache(pCtx context.Context, client KvDbClientIf, conf CacheConf, records ...any) *Cache {
-	ctx, _ :=context.WithCancel(pCtx),
+	ctx, cancel := context.WithCancel(pCtx)
 	cache := &Cache{
 		ctx:     ctx,
+		cancel:  cancel,
 		items:   new(sync.Map),
 		db:      client,
 		conf:    conf,
 		records: records,
+		tags:    make(map[string]map[string]cachestore.Record),
+		keyTags: make(map[string][]string),
+	}
+
+	if conf.Snapshot.File != "" {
+		// start empty rather than fail on a broken snapshot
+		if err := cache.loadSnapshot(); err != nil {
+			log.Errorf("Failed to load snapshot, file:%v, err:%v", conf.Snapshot.File, err)
+		}
+		cache.snapshotDone = make(chan struct{})
+		go cache.snapshotLoop()
 	}
 
 	go cache.gcLoop()
@@ -53,27 +90,23 @@ func (c *Cache) Dump() {
 
 	c.items.Range(func(k, v any) bool {
 		if isFirst {
-			sb.WriteString(fmt.Sprintf("Dump cache:{\"%v\": \"%v\"", k, v))
+			sb.WriteString(fmt.Sprintf("Dump cache:{\"%v\": \"%v\"", k, v.(*item).value))
 		} else {
-			sb.WriteString(fmt.Sprintf(",\"%v\": \"%v\"", k, v))
+			sb.WriteString(fmt.Sprintf(",\"%v\": \"%v\"", k, v.(*item).value))
 		}
 		return true
 	})
 	sb.WriteString("}")
-	log.Infof(sb.String())
+	log.Infof("%s", sb.String())
 }
 
 func (c *Cache) doMemoryClean() {
+	now := time.Now().Unix()
 	c.items.Range(func(k, v any) bool {
-		record, ok := v.(cachestore.Record)
-		if ok {
-			if time.Now().Unix() > record.GetExpireAt() {
-				c.items.CompareAndDelete(k, v)
-			}
-		} else {
-			c.items.Delete(k)
+		if v.(*item).expired(now) && c.items.CompareAndDelete(k, v) {
+			c.untagExpired(k.(string))
+			c.notify(EnumEventExpire, k.(string), v.(*item), nil)
 		}
-
 		return true
 	})
 }
@@ -123,11 +156,14 @@ func (c *Cache) Update(rt cachestore.Record) error {
 		}
 	}
 
-	prevValue, loaded := c.items.Swap(cachestore.UniqCacheKey(rt), rt.GetValue())
+	key, it := cachestore.UniqCacheKey(rt), newItem(rt)
+	prev, loaded := c.items.Swap(key, it)
+	c.tag(rt)
+	c.notifySwap(key, prev, loaded, it)
 
 	// check exist
 	if c.db != nil {
-		if (loaded && !cmp.Equal(prevValue, rt.GetValue())) || (!loaded && prevValue == nil) {
+		if !loaded || prev.(*item).value != rt.GetValue() {
 			if err := c.db.Set(rt); err != nil {
 				return errors.Wrap(err)
 			}
@@ -146,10 +182,13 @@ func (c *Cache) CreateOrUpdate(rt cachestore.Record, expireAfterInSec int64) (ex
 		expireAt = 0
 	}
 	rt.SetExpireAt(expireAt)
-	prevValue, loaded := c.items.Swap(cachestore.UniqCacheKey(rt), rt.GetValue())
+	key, it := cachestore.UniqCacheKey(rt), newItem(rt)
+	prev, loaded := c.items.Swap(key, it)
+	c.tag(rt)
+	c.notifySwap(key, prev, loaded, it)
 
 	if c.db != nil {
-		if loaded && !cmp.Equal(prevValue, rt.GetValue()) {
+		if loaded && prev.(*item).value != rt.GetValue() {
 			if err = c.db.Set(rt); err != nil {
 				//rollback expireAt
 				rt.SetExpireAt(origExpireAt)
@@ -159,7 +198,7 @@ func (c *Cache) CreateOrUpdate(rt cachestore.Record, expireAfterInSec int64) (ex
 			return true, nil
 		}
 
-		if !loaded && prevValue == nil {
+		if !loaded {
 			if err = c.db.Get(originRt); err == nil {
 				loaded = true
 			}
@@ -184,10 +223,13 @@ func (c *Cache) Set(rt cachestore.Record, expireAfterInSec int64) error {
 		expireAt = 0
 	}
 	rt.SetExpireAt(expireAt)
-	prevValue, loaded := c.items.Swap(cachestore.UniqCacheKey(rt), rt.GetValue())
+	key, it := cachestore.UniqCacheKey(rt), newItem(rt)
+	prev, loaded := c.items.Swap(key, it)
+	c.tag(rt)
+	c.notifySwap(key, prev, loaded, it)
 
 	if c.db != nil {
-		if (loaded && !cmp.Equal(prevValue, rt.GetValue())) || (!loaded && prevValue == nil) {
+		if !loaded || prev.(*item).value != rt.GetValue() {
 			// 存储到数据库
 			if err := c.db.Set(rt); err != nil {
 				//rollback expireAt
@@ -200,6 +242,54 @@ func (c *Cache) Set(rt cachestore.Record, expireAfterInSec int64) error {
 	return nil
 }
 
+// SetIfAbsent stores rt only if its key is absent or expired in memory and db, returns whether rt is stored.
+// It is atomic among callers of the same Cache
+func (c *Cache) SetIfAbsent(rt cachestore.Record, expireAfterInSec int64) (bool, error) {
+	origExpireAt := rt.GetExpireAt()
+	now := time.Now().Unix()
+	expireAt := now + expireAfterInSec
+	if expireAfterInSec == 0 {
+		expireAt = 0
+	}
+
+	key := cachestore.UniqCacheKey(rt)
+	if _, inMemory := c.items.Load(key); !inMemory && c.db != nil {
+		existing := rt.Clone()
+		if err := c.db.Get(existing); err == nil && (existing.GetExpireAt() == 0 || existing.GetExpireAt() >= now) {
+			return false, nil
+		}
+	}
+
+	rt.SetExpireAt(expireAt)
+	it := newItem(rt)
+	for {
+		prev, loaded := c.items.LoadOrStore(key, it)
+		if !loaded {
+			c.notify(EnumEventSet, key, nil, it)
+			break
+		}
+		if !prev.(*item).expired(now) {
+			rt.SetExpireAt(origExpireAt)
+			return false, nil
+		}
+		if c.items.CompareAndSwap(key, prev, it) {
+			c.notify(EnumEventUpdate, key, prev.(*item), it)
+			break
+		}
+	}
+	c.tag(rt)
+
+	if c.db != nil {
+		if err := c.db.Set(rt); err != nil {
+			c.items.CompareAndDelete(key, it)
+			rt.SetExpireAt(origExpireAt)
+			return false, errors.Wrap(err)
+		}
+	}
+
+	return true, nil
+}
+
 func ListWithKeyPrefix[T cachestore.ConsistentRecord](c *Cache, filterWithKeyPrefix T) ([]T, error) {
 	var (
 		err error
@@ -212,7 +302,7 @@ func ListWithKeyPrefix[T cachestore.ConsistentRecord](c *Cache, filterWithKeyPre
 	c.items.Range(func(k, v interface{}) bool {
 		if strings.HasPrefix(k.(string), cachestore.UniqCacheKey(filterWithKeyPrefix)) {
 			tmp := filterWithKeyPrefix.Clone() //get a clone
-			err = tmp.Unmarshal(v.(string))
+			err = tmp.Unmarshal(v.(*item).value)
 			if err != nil {
 				return false
 			}
@@ -251,10 +341,13 @@ func (c *Cache) Get(filter cachestore.Record) (cachestore.Record, error) {
 	// 首先尝试从内存缓存获取
 	value, inMemory := c.items.Load(cachestore.UniqCacheKey(filter))
 	if inMemory {
-		err = filter.Unmarshal(value.(string))
+		it := value.(*item)
+		err = filter.Unmarshal(it.value)
 		if err != nil {
 			return nil, err
 		}
+		// records loaded from snapshot carry expireAt only in item
+		filter.SetExpireAt(it.expireAt)
 		dest = filter
 	} else if c.db != nil {
 		// Try from db
@@ -275,7 +368,8 @@ func (c *Cache) Get(filter cachestore.Record) (cachestore.Record, error) {
 	}
 
 	if !inMemory {
-		c.items.Store(cachestore.UniqCacheKey(dest), dest.GetValue())
+		c.items.Store(cachestore.UniqCacheKey(dest), newItem(dest))
+		c.tag(dest)
 	}
 
 	return dest, nil
@@ -297,3 +391,147 @@ func (c *Cache) Exist(filter cachestore.Record) (bool, error) {
 
 	return false, nil
 }
+
+// Delete removes rt from memory and from db if any
+func (c *Cache) Delete(rt cachestore.Record) error {
+	key := cachestore.UniqCacheKey(rt)
+	prev, inMemory := c.items.LoadAndDelete(key)
+	c.untag(rt)
+	if inMemory {
+		c.notify(EnumEventDelete, key, prev.(*item), nil)
+	}
+
+	if c.db != nil {
+		return c.db.Delete(rt)
+	}
+
+	if !inMemory {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteWithKeyPrefix removes all records whose key starts with filterWithKeyPrefix.GetKey()
+func (c *Cache) DeleteWithKeyPrefix(filterWithKeyPrefix cachestore.ConsistentRecord) error {
+	prefix := cachestore.UniqCacheKey(filterWithKeyPrefix)
+	c.items.Range(func(k, v any) bool {
+		if strings.HasPrefix(k.(string), prefix) {
+			if prev, ok := c.items.LoadAndDelete(k); ok {
+				c.notify(EnumEventDelete, k.(string), prev.(*item), nil)
+			}
+		}
+		return true
+	})
+	c.untagWithKeyPrefix(prefix)
+
+	if c.db != nil {
+		err := c.db.DeleteWithKeyPrefix(filterWithKeyPrefix, filterWithKeyPrefix.GetPrimaryName(), filterWithKeyPrefix.GetKey())
+		if err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// Clear drops all records in memory, records in db are kept
+func (c *Cache) Clear() {
+	c.items.Range(func(k, v any) bool {
+		if prev, ok := c.items.LoadAndDelete(k); ok {
+			c.notify(EnumEventDelete, k.(string), prev.(*item), nil)
+		}
+		return true
+	})
+
+	c.tagMutex.Lock()
+	c.tags = make(map[string]map[string]cachestore.Record)
+	c.keyTags = make(map[string][]string)
+	c.tagMutex.Unlock()
+}
+
+// InvalidateTag deletes all records tagged with any of tags, see cachestore.TaggedRecord
+func (c *Cache) InvalidateTag(tags ...string) error {
+	var records []cachestore.Record
+	c.tagMutex.Lock()
+	for _, tag := range tags {
+		for _, r := range c.tags[tag] {
+			records = append(records, r)
+		}
+		delete(c.tags, tag)
+	}
+	c.tagMutex.Unlock()
+
+	var firstErr error
+	for _, r := range records {
+		if err := c.Delete(r); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Errorf("Failed to invalidate record, key:%v, err:%v", cachestore.UniqCacheKey(r), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// tag replaces the tags of rt's key by the current tags of rt
+func (c *Cache) tag(rt cachestore.Record) {
+	var tags []string
+	if tr, ok := rt.(cachestore.TaggedRecord); ok {
+		tags = tr.GetTags()
+	}
+
+	key := cachestore.UniqCacheKey(rt)
+	c.tagMutex.Lock()
+	defer c.tagMutex.Unlock()
+	c.untagLocked(key)
+	if len(tags) == 0 {
+		return
+	}
+
+	clone := rt.Clone()
+	for _, tag := range tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]cachestore.Record)
+		}
+		c.tags[tag][key] = clone
+	}
+	c.keyTags[key] = slices.Clone(tags)
+}
+
+func (c *Cache) untagLocked(key string) {
+	for _, tag := range c.keyTags[key] {
+		keys := c.tags[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+	delete(c.keyTags, key)
+}
+
+func (c *Cache) untag(rt cachestore.Record) {
+	c.tagMutex.Lock()
+	defer c.tagMutex.Unlock()
+	c.untagLocked(cachestore.UniqCacheKey(rt))
+}
+
+// untagExpired keeps the tags if key is set again after expired
+func (c *Cache) untagExpired(key string) {
+	c.tagMutex.Lock()
+	defer c.tagMutex.Unlock()
+	if _, ok := c.items.Load(key); !ok {
+		c.untagLocked(key)
+	}
+}
+
+func (c *Cache) untagWithKeyPrefix(prefix string) {
+	c.tagMutex.Lock()
+	defer c.tagMutex.Unlock()
+	for key := range c.keyTags {
+		if strings.HasPrefix(key, prefix) {
+			c.untagLocked(key)
+		}
+	}
+}
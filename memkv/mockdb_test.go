@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/madlabx/pkgx/cachestore"
 	"github.com/madlabx/pkgx/errors"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
@@ -40,6 +41,16 @@ func (m *MockDbClient) DeleteExpired(record any) error {
 	return args.Error(0)
 }
 
+func (m *MockDbClient) Delete(record any) error {
+	args := m.Called(record)
+	return args.Error(0)
+}
+
+func (m *MockDbClient) DeleteWithKeyPrefix(filter any, keyFieldName, keyPrefix string) error {
+	args := m.Called(filter, keyFieldName, keyPrefix)
+	return args.Error(0)
+}
+
 // TestSuite 是一组相关测试的集合
 type TestMockDbSuite struct {
 	suite.Suite
@@ -59,7 +70,7 @@ func (s *TestMockDbSuite) TearDownSuite() {
 }
 
 func (s *TestMockDbSuite) BeforeTest(suitename, testname string) {
-	if testname == "TestOnlyMemCache" {
+	if testname == "TestOnlyMemCache" || testname == "TestOnlyMemCacheDelete" {
 		s.cache = NewCache(context.Background(), nil, CacheConf{})
 	}
 }
@@ -178,3 +189,104 @@ func (s *TestMockDbSuite) TestOnlyMemCache() {
 	err = s.cache.Set(rt, 3600)
 	s.Nil(err)
 }
+
+func (s *TestMockDbSuite) TestDelete() {
+	rt := &RefreshTokenMock{IKey: "key4", IValue: "value4"}
+	s.mockDB.On("Set", rt).Return(nil)
+	s.Nil(s.cache.Set(rt, 5))
+
+	s.mockDB.On("Delete", rt).Return(nil)
+	s.Nil(s.cache.Delete(rt))
+	s.mockDB.AssertCalled(s.T(), "Delete", rt)
+
+	s.mockDB.On("Get", rt).Return(gorm.ErrRecordNotFound)
+	_, err := s.cache.Get(rt)
+	s.True(errors.Is(err, gorm.ErrRecordNotFound))
+}
+
+func (s *TestMockDbSuite) TestDeleteWithKeyPrefix() {
+	for _, k := range []string{"prefix_1", "prefix_2", "other_1"} {
+		rt := &RefreshTokenMock{IKey: k, IValue: k}
+		s.mockDB.On("Set", rt).Return(nil)
+		s.Nil(s.cache.Set(rt, 5))
+	}
+
+	filter := &RefreshTokenMock{IKey: "prefix_"}
+	s.mockDB.On("DeleteWithKeyPrefix", filter, "key", "prefix_").Return(nil)
+	s.Nil(s.cache.DeleteWithKeyPrefix(filter))
+
+	_, inMemory := s.cache.items.Load(cachestore.UniqCacheKey(&RefreshTokenMock{IKey: "prefix_1"}))
+	s.False(inMemory)
+	_, inMemory = s.cache.items.Load(cachestore.UniqCacheKey(&RefreshTokenMock{IKey: "other_1"}))
+	s.True(inMemory)
+}
+
+func (s *TestMockDbSuite) TestOnlyMemCacheDelete() {
+	s.Nil(s.cache.db)
+
+	rt := &RefreshTokenMock{IKey: "key5", IValue: "value5"}
+	s.True(errors.Is(s.cache.Delete(rt), ErrNotFound))
+
+	s.Nil(s.cache.Set(rt, 5))
+	s.Nil(s.cache.Delete(rt))
+	_, err := s.cache.Get(rt)
+	s.True(errors.Is(err, ErrNotFound))
+
+	tagged := []*TaggedRefreshTokenMock{
+		{RefreshTokenMock: RefreshTokenMock{IKey: "t1", IValue: "v1"}, ITags: []string{"user_1"}},
+		{RefreshTokenMock: RefreshTokenMock{IKey: "t2", IValue: "v2"}, ITags: []string{"user_1", "user_2"}},
+		{RefreshTokenMock: RefreshTokenMock{IKey: "t3", IValue: "v3"}, ITags: []string{"user_2"}},
+	}
+	for _, r := range tagged {
+		s.Nil(s.cache.Set(r, 5))
+	}
+
+	s.Nil(s.cache.InvalidateTag("user_1"))
+	_, err = s.cache.Get(&TaggedRefreshTokenMock{RefreshTokenMock: RefreshTokenMock{IKey: "t1"}})
+	s.True(errors.Is(err, ErrNotFound))
+	_, err = s.cache.Get(&TaggedRefreshTokenMock{RefreshTokenMock: RefreshTokenMock{IKey: "t2"}})
+	s.True(errors.Is(err, ErrNotFound))
+	_, err = s.cache.Get(&TaggedRefreshTokenMock{RefreshTokenMock: RefreshTokenMock{IKey: "t3"}})
+	s.Nil(err)
+
+	// re-set with other tags leaves the old ones
+	s.Nil(s.cache.Set(&TaggedRefreshTokenMock{RefreshTokenMock: RefreshTokenMock{IKey: "t3", IValue: "v3b"}, ITags: []string{"user_3"}}, 5))
+	s.Nil(s.cache.InvalidateTag("user_2"))
+	_, err = s.cache.Get(&TaggedRefreshTokenMock{RefreshTokenMock: RefreshTokenMock{IKey: "t3"}})
+	s.Nil(err)
+
+	// expired records are untagged
+	s.Nil(s.cache.Set(&TaggedRefreshTokenMock{RefreshTokenMock: RefreshTokenMock{IKey: "t4", IValue: "v4"}, ITags: []string{"user_4"}}, -10))
+	s.cache.doMemoryClean()
+	s.cache.tagMutex.Lock()
+	s.NotContains(s.cache.tags, "user_4")
+	s.Len(s.cache.keyTags, 1)
+	s.cache.tagMutex.Unlock()
+
+	s.cache.Clear()
+	_, err = s.cache.Get(&TaggedRefreshTokenMock{RefreshTokenMock: RefreshTokenMock{IKey: "t3"}})
+	s.True(errors.Is(err, ErrNotFound))
+}
+
+func (s *TestMockDbSuite) TestSetIfAbsent() {
+	rt := &RefreshTokenMock{IKey: "key6", IValue: "value6"}
+	s.mockDB.On("Get", &RefreshTokenMock{IKey: "key6", IValue: "value6"}).Return(gorm.ErrRecordNotFound).Once()
+	s.mockDB.On("Set", rt).Return(nil)
+	ok, err := s.cache.SetIfAbsent(rt, 5)
+	s.Nil(err)
+	s.True(ok)
+
+	ok, err = s.cache.SetIfAbsent(&RefreshTokenMock{IKey: "key6", IValue: "other"}, 5)
+	s.Nil(err)
+	s.False(ok)
+
+	// db error rolls back the memory
+	failed := &RefreshTokenMock{IKey: "key7", IValue: "value7"}
+	s.mockDB.On("Get", &RefreshTokenMock{IKey: "key7", IValue: "value7"}).Return(gorm.ErrRecordNotFound)
+	s.mockDB.On("Set", failed).Return(fmt.Errorf("db down"))
+	ok, err = s.cache.SetIfAbsent(failed, 5)
+	s.NotNil(err)
+	s.False(ok)
+	_, inMemory := s.cache.items.Load(cachestore.UniqCacheKey(failed))
+	s.False(inMemory)
+}
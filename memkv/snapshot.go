@@ -0,0 +1,245 @@
+package memkv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/graceful"
+	"github.com/madlabx/pkgx/log"
+)
+
+var _ graceful.GracefulService = (*Cache)(nil)
+
+type SnapshotConf struct {
+	File     string // snapshot is disabled if empty
+	Interval int    `vx_default:"60"` //in sec, 0 means only on shutdown
+}
+
+// 快照格式:
+//
+//	magic(4) | version(1) | entries... | 0(1) | crc32(4)
+//	entry: 1(1) | uvarint(len(key)) | key | uvarint(len(value)) | value | varint(expireAt)
+//
+// crc32(castagnoli) covers all bytes before it
+var snapshotMagic = []byte("MKVS")
+
+const (
+	snapshotVersion  byte = 1
+	snapshotEntryTag byte = 1
+	snapshotEndTag   byte = 0
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SaveSnapshot atomically replaces the snapshot file with records in memory, expired records are skipped.
+// Tags of records are not saved
+func (c *Cache) SaveSnapshot() error {
+	file := c.conf.Snapshot.File
+	if file == "" {
+		return errors.New("snapshot file not configured")
+	}
+
+	c.snapshotMutex.Lock()
+	defer c.snapshotMutex.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp*")
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer func() {
+		if tmp != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = c.writeSnapshot(tmp, time.Now().Unix()); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return errors.Wrap(err)
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err)
+	}
+	if err = os.Rename(tmp.Name(), file); err != nil {
+		return errors.Wrap(err)
+	}
+	tmp = nil
+
+	// persist the rename
+	if dir, err := os.Open(filepath.Dir(file)); err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+
+	return nil
+}
+
+// writeSnapshot writes records not expired at now
+func (c *Cache) writeSnapshot(w io.Writer, now int64) error {
+	crc := crc32.New(crcTable)
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	bw.Write(snapshotMagic)
+	bw.WriteByte(snapshotVersion)
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	c.items.Range(func(k, v any) bool {
+		it := v.(*item)
+		if it.expired(now) {
+			return true
+		}
+
+		key := k.(string)
+		bw.WriteByte(snapshotEntryTag)
+		bw.Write(buf[:binary.PutUvarint(buf, uint64(len(key)))])
+		bw.WriteString(key)
+		bw.Write(buf[:binary.PutUvarint(buf, uint64(len(it.value)))])
+		bw.WriteString(it.value)
+		bw.Write(buf[:binary.PutVarint(buf, it.expireAt)])
+		return true
+	})
+	bw.WriteByte(snapshotEndTag)
+
+	// bufio.Writer keeps the first error
+	if err := bw.Flush(); err != nil {
+		return errors.Wrap(err)
+	}
+
+	_, err := w.Write(crc.Sum(nil))
+	return errors.Wrap(err)
+}
+
+// loadSnapshot loads records not expired from the snapshot file, a missing file is not an error
+func (c *Cache) loadSnapshot() error {
+	data, err := os.ReadFile(c.conf.Snapshot.File)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err)
+	}
+
+	items, err := parseSnapshot(data)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	loaded := 0
+	for key, it := range items {
+		if it.expired(now) {
+			continue
+		}
+		c.items.Store(key, it)
+		loaded++
+	}
+
+	log.Infof("Loaded snapshot, file:%v, records:%v, expired:%v", c.conf.Snapshot.File, loaded, len(items)-loaded)
+	return nil
+}
+
+func parseSnapshot(data []byte) (map[string]*item, error) {
+	if len(data) < len(snapshotMagic)+1+1+crc32.Size || !bytes.Equal(data[:len(snapshotMagic)], snapshotMagic) {
+		return nil, ErrCorruptedSnapshot
+	}
+
+	body, sum := data[:len(data)-crc32.Size], data[len(data)-crc32.Size:]
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(sum) {
+		return nil, ErrCorruptedSnapshot
+	}
+
+	if body[len(snapshotMagic)] != snapshotVersion {
+		return nil, errors.Errorf("unsupported snapshot version:%v", body[len(snapshotMagic)])
+	}
+
+	r := bytes.NewReader(body[len(snapshotMagic)+1:])
+	readBytes := func() (string, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return "", ErrCorruptedSnapshot
+		}
+		b := make([]byte, n)
+		_, _ = r.Read(b)
+		return string(b), nil
+	}
+
+	items := make(map[string]*item)
+	for {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrCorruptedSnapshot
+		}
+		if tag == snapshotEndTag {
+			break
+		}
+		if tag != snapshotEntryTag {
+			return nil, ErrCorruptedSnapshot
+		}
+
+		key, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		expireAt, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, ErrCorruptedSnapshot
+		}
+		items[key] = &item{value: value, expireAt: expireAt}
+	}
+
+	if r.Len() != 0 {
+		return nil, ErrCorruptedSnapshot
+	}
+
+	return items, nil
+}
+
+func (c *Cache) snapshotLoop() {
+	defer close(c.snapshotDone)
+
+	var tick <-chan time.Time
+	if c.conf.Snapshot.Interval > 0 {
+		ticker := time.NewTicker(time.Second * time.Duration(c.conf.Snapshot.Interval))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			if err := c.SaveSnapshot(); err != nil {
+				log.Errorf("Failed to save snapshot, file:%v, err:%v", c.conf.Snapshot.File, err)
+			}
+		case <-c.ctx.Done():
+			if err := c.SaveSnapshot(); err != nil {
+				log.Errorf("Failed to save snapshot on shutdown, file:%v, err:%v", c.conf.Snapshot.File, err)
+			}
+			return
+		}
+	}
+}
+
+func (c *Cache) Name() string {
+	return "memkv"
+}
+
+// Stop stops background loops, and saves the last snapshot if enabled, implements graceful.GracefulService
+func (c *Cache) Stop() error {
+	c.cancel()
+	if c.snapshotDone != nil {
+		<-c.snapshotDone
+	}
+	return nil
+}
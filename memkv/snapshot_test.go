@@ -0,0 +1,126 @@
+package memkv
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/madlabx/pkgx/cachestore"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func newSnapshotCache(file string, interval int) *Cache {
+	return NewCache(context.Background(), nil, CacheConf{Snapshot: SnapshotConf{File: file, Interval: interval}})
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "idempotent.snap")
+
+	c := newSnapshotCache(file, 0)
+	require.Nil(t, c.Set(&RefreshTokenMock{IKey: "k1", IValue: "v1"}, 60))
+	require.Nil(t, c.Set(&RefreshTokenMock{IKey: "k2", IValue: "v2"}, 0))
+	// saved on shutdown
+	require.Nil(t, c.Stop())
+
+	c = newSnapshotCache(file, 0)
+	defer c.Stop()
+
+	r, err := c.Get(&RefreshTokenMock{IKey: "k1"})
+	require.Nil(t, err)
+	require.Equal(t, "v1", r.GetValue())
+	require.InDelta(t, time.Now().Unix()+60, r.GetExpireAt(), 2)
+
+	r, err = c.Get(&RefreshTokenMock{IKey: "k2"})
+	require.Nil(t, err)
+	require.Equal(t, "v2", r.GetValue())
+	require.Zero(t, r.GetExpireAt())
+
+	// no temp file left
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(entries))
+}
+
+func TestSnapshotDropExpired(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "idempotent.snap")
+	now := time.Now().Unix()
+
+	c := newSnapshotCache(file, 0)
+	c.items.Store(cachestore.UniqCacheKey(&RefreshTokenMock{IKey: "alive"}), &item{value: "a", expireAt: now + 60})
+	c.items.Store(cachestore.UniqCacheKey(&RefreshTokenMock{IKey: "expiring"}), &item{value: "b", expireAt: now - 10})
+	c.items.Store(cachestore.UniqCacheKey(&RefreshTokenMock{IKey: "expired"}), &item{value: "c", expireAt: now - 200})
+
+	// "expiring" is alive when saved, but expired when loaded
+	var buf bytes.Buffer
+	require.Nil(t, c.writeSnapshot(&buf, now-100))
+	require.Nil(t, os.WriteFile(file, buf.Bytes(), 0644))
+
+	items, err := parseSnapshot(buf.Bytes())
+	require.Nil(t, err)
+	require.Equal(t, 2, len(items))
+
+	c2 := newSnapshotCache(file, 0)
+	defer c2.Stop()
+	_, err = c2.Get(&RefreshTokenMock{IKey: "alive"})
+	require.Nil(t, err)
+	_, err = c2.Get(&RefreshTokenMock{IKey: "expiring"})
+	require.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestSnapshotCorrupted(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "idempotent.snap")
+
+	c := newSnapshotCache(file, 0)
+	require.Nil(t, c.Set(&RefreshTokenMock{IKey: "k1", IValue: "v1"}, 60))
+	require.Nil(t, c.SaveSnapshot())
+	require.Nil(t, c.Stop())
+
+	data, err := os.ReadFile(file)
+	require.Nil(t, err)
+	_, err = parseSnapshot(data)
+	require.Nil(t, err)
+
+	for _, broken := range [][]byte{
+		flipByte(data, len(data)/2),
+		data[:len(data)-1],
+		[]byte("MKVS"),
+	} {
+		_, err = parseSnapshot(broken)
+		require.True(t, errors.Is(err, ErrCorruptedSnapshot))
+	}
+
+	// start empty on corruption
+	require.Nil(t, os.WriteFile(file, flipByte(data, len(data)/2), 0644))
+	c = newSnapshotCache(file, 0)
+	defer c.Stop()
+	_, err = c.Get(&RefreshTokenMock{IKey: "k1"})
+	require.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestSnapshotPeriodic(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "idempotent.snap")
+
+	c := newSnapshotCache(file, 1)
+	defer c.Stop()
+	require.Nil(t, c.Set(&RefreshTokenMock{IKey: "k1", IValue: "v1"}, 60))
+
+	require.Eventually(t, func() bool {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return false
+		}
+		items, err := parseSnapshot(data)
+		return err == nil && len(items) == 1
+	}, 3*time.Second, 100*time.Millisecond)
+}
+
+func flipByte(data []byte, i int) []byte {
+	b := bytes.Clone(data)
+	b[i] ^= 0xff
+	return b
+}
@@ -0,0 +1,170 @@
+package memkv
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type EventType int
+
+const (
+	EnumEventSet    EventType = iota + 1 // key not in memory before
+	EnumEventUpdate                      // key in memory overwritten
+	EnumEventExpire                      // expired key cleaned by gc
+	EnumEventDelete
+)
+
+func (et EventType) String() string {
+	switch et {
+	case EnumEventSet:
+		return "set"
+	case EnumEventUpdate:
+		return "update"
+	case EnumEventExpire:
+		return "expire"
+	case EnumEventDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a change of a key in memory, Key is cachestore.UniqCacheKey of the record.
+// OldValue is empty for EnumEventSet, NewValue is empty for EnumEventExpire and EnumEventDelete
+type Event struct {
+	Type     EventType
+	Key      string
+	OldValue string
+	NewValue string
+	ExpireAt int64
+}
+
+type WatchOption func(*watchOptions)
+
+type watchOptions struct {
+	bufferSize int
+	block      bool
+}
+
+// WithBufferSize events buffered for a slow watcher, 64 by default
+func WithBufferSize(size int) WatchOption {
+	return func(o *watchOptions) {
+		o.bufferSize = size
+	}
+}
+
+// WithBlock writers of the cache wait for a full watcher instead of dropping events,
+// use it only if the watcher never stalls
+func WithBlock(block bool) WatchOption {
+	return func(o *watchOptions) {
+		o.block = block
+	}
+}
+
+// Watcher receives events of keys with the prefix until its context is done or the cache is stopped
+type Watcher struct {
+	C <-chan Event
+
+	ch      chan Event
+	ctx     context.Context
+	prefix  string
+	opts    watchOptions
+	dropped atomic.Uint64
+}
+
+// Dropped number of events dropped since the buffer is full
+func (w *Watcher) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+type watcherSet struct {
+	mutex    sync.RWMutex
+	watchers map[*Watcher]struct{}
+}
+
+// Watch subscribes changes of keys with prefix, "" for all keys. Changes of records loaded from db are not notified.
+// Watcher.C is closed after ctx is done or the cache is stopped
+func (c *Cache) Watch(ctx context.Context, prefix string, opts ...WatchOption) *Watcher {
+	o := watchOptions{bufferSize: 64}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	wCtx, cancel := context.WithCancel(ctx)
+	ch := make(chan Event, o.bufferSize)
+	w := &Watcher{C: ch, ch: ch, ctx: wCtx, prefix: prefix, opts: o}
+
+	c.watchers.mutex.Lock()
+	if c.watchers.watchers == nil {
+		c.watchers.watchers = make(map[*Watcher]struct{})
+	}
+	c.watchers.watchers[w] = struct{}{}
+	c.watchers.mutex.Unlock()
+
+	go func() {
+		defer cancel()
+		select {
+		case <-wCtx.Done():
+		case <-c.ctx.Done():
+			// wake up blocked writers
+			cancel()
+		}
+
+		// no writer is sending once the write lock is held
+		c.watchers.mutex.Lock()
+		delete(c.watchers.watchers, w)
+		c.watchers.mutex.Unlock()
+		close(w.ch)
+	}()
+
+	return w
+}
+
+func (c *Cache) notify(et EventType, key string, prev, cur *item) {
+	c.watchers.mutex.RLock()
+	defer c.watchers.mutex.RUnlock()
+	if len(c.watchers.watchers) == 0 {
+		return
+	}
+
+	ev := Event{Type: et, Key: key}
+	if prev != nil {
+		ev.OldValue = prev.value
+		ev.ExpireAt = prev.expireAt
+	}
+	if cur != nil {
+		ev.NewValue = cur.value
+		ev.ExpireAt = cur.expireAt
+	}
+
+	for w := range c.watchers.watchers {
+		if !strings.HasPrefix(key, w.prefix) || w.ctx.Err() != nil {
+			continue
+		}
+
+		if w.opts.block {
+			select {
+			case w.ch <- ev:
+			case <-w.ctx.Done():
+			}
+			continue
+		}
+
+		select {
+		case w.ch <- ev:
+		default:
+			w.dropped.Add(1)
+		}
+	}
+}
+
+// notifySwap notifies the result of items.Swap
+func (c *Cache) notifySwap(key string, prev any, loaded bool, cur *item) {
+	if loaded {
+		c.notify(EnumEventUpdate, key, prev.(*item), cur)
+	} else {
+		c.notify(EnumEventSet, key, nil, cur)
+	}
+}
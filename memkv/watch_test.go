@@ -0,0 +1,124 @@
+package memkv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/madlabx/pkgx/cachestore"
+	"github.com/stretchr/testify/require"
+)
+
+func recvEvent(t *testing.T, w *Watcher) Event {
+	select {
+	case ev := <-w.C:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+		return Event{}
+	}
+}
+
+func TestWatchEvents(t *testing.T) {
+	c := NewCache(context.Background(), nil, CacheConf{})
+	defer c.Stop()
+
+	prefix := cachestore.UniqCacheKey(&RefreshTokenMock{IKey: "user1_"})
+	w := c.Watch(context.Background(), prefix)
+
+	r := &RefreshTokenMock{IKey: "user1_a", IValue: "v1"}
+	require.Nil(t, c.Set(r, 60))
+	ev := recvEvent(t, w)
+	require.Equal(t, EnumEventSet, ev.Type)
+	require.Equal(t, cachestore.UniqCacheKey(r), ev.Key)
+	require.Equal(t, "", ev.OldValue)
+	require.Equal(t, "v1", ev.NewValue)
+	require.Equal(t, r.IExpireAt, ev.ExpireAt)
+
+	r.IValue = "v2"
+	require.Nil(t, c.Update(r))
+	ev = recvEvent(t, w)
+	require.Equal(t, EnumEventUpdate, ev.Type)
+	require.Equal(t, "v1", ev.OldValue)
+	require.Equal(t, "v2", ev.NewValue)
+
+	// other keys are not watched
+	require.Nil(t, c.Set(&RefreshTokenMock{IKey: "user2_a", IValue: "x"}, 60))
+
+	require.Nil(t, c.Delete(r))
+	ev = recvEvent(t, w)
+	require.Equal(t, EnumEventDelete, ev.Type)
+	require.Equal(t, "v2", ev.OldValue)
+
+	// expired records cleaned by gc
+	require.Nil(t, c.Set(&RefreshTokenMock{IKey: "user1_b", IValue: "v3"}, 60))
+	require.Equal(t, EnumEventSet, recvEvent(t, w).Type)
+	c.items.Store(cachestore.UniqCacheKey(&RefreshTokenMock{IKey: "user1_b"}), &item{value: "v3", expireAt: time.Now().Unix() - 1})
+	c.doMemoryClean()
+	ev = recvEvent(t, w)
+	require.Equal(t, EnumEventExpire, ev.Type)
+	require.Equal(t, "v3", ev.OldValue)
+
+	require.Equal(t, 0, len(w.C))
+}
+
+func TestWatchDropAndBlock(t *testing.T) {
+	c := NewCache(context.Background(), nil, CacheConf{})
+	defer c.Stop()
+
+	dropping := c.Watch(context.Background(), "", WithBufferSize(2))
+	blocking := c.Watch(context.Background(), "", WithBufferSize(1), WithBlock(true))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, k := range []string{"a", "b", "c", "d"} {
+			require.Nil(t, c.Set(&RefreshTokenMock{IKey: k, IValue: k}, 0))
+		}
+	}()
+
+	var keys []string
+	for i := 0; i < 4; i++ {
+		keys = append(keys, recvEvent(t, blocking).NewValue)
+	}
+	<-done
+	require.Equal(t, []string{"a", "b", "c", "d"}, keys)
+
+	require.Equal(t, 2, len(dropping.C))
+	require.Equal(t, uint64(2), dropping.Dropped())
+	require.Equal(t, uint64(0), blocking.Dropped())
+}
+
+func TestWatchCancel(t *testing.T) {
+	c := NewCache(context.Background(), nil, CacheConf{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := c.Watch(ctx, "", WithBufferSize(1), WithBlock(true))
+	require.Nil(t, c.Set(&RefreshTokenMock{IKey: "a", IValue: "a"}, 0))
+
+	// a writer blocked by the full watcher is released by cancel
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.Nil(t, c.Set(&RefreshTokenMock{IKey: "b", IValue: "b"}, 0))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	require.Equal(t, "a", recvEvent(t, w).NewValue)
+	_, ok := <-w.C
+	require.False(t, ok)
+
+	// closed by stop of the cache
+	w = c.Watch(context.Background(), "")
+	require.Nil(t, c.Stop())
+	require.Eventually(t, func() bool {
+		select {
+		case _, ok := <-w.C:
+			return !ok
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+}
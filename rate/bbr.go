@@ -0,0 +1,216 @@
+package rate
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/madlabx/pkgx/log"
+)
+
+type BbrOption func(*bbrOptions)
+
+type bbrOptions struct {
+	window       time.Duration
+	bucketNum    int
+	cpuThreshold int64
+	coolDown     time.Duration
+	cpuUsage     func() int64
+	now          func() time.Time
+}
+
+// WithBbrWindow stats of pass and latency are kept in window, split into buckets. 10s and 100 buckets by default,
+// also used if window or buckets is not positive
+func WithBbrWindow(window time.Duration, buckets int) BbrOption {
+	return func(o *bbrOptions) {
+		o.window = window
+		o.bucketNum = buckets
+	}
+}
+
+// WithCpuThreshold load is shed once cpu usage in permille reaches threshold, 800 by default
+func WithCpuThreshold(threshold int64) BbrOption {
+	return func(o *bbrOptions) {
+		o.cpuThreshold = threshold
+	}
+}
+
+// WithCoolDown keep shedding within coolDown after the last drop by high cpu even if cpu gets lower, 1s by default
+func WithCoolDown(coolDown time.Duration) BbrOption {
+	return func(o *bbrOptions) {
+		o.coolDown = coolDown
+	}
+}
+
+// WithCpuUsage source of cpu usage in permille, SystemCpuUsage by default
+func WithCpuUsage(cpuUsage func() int64) BbrOption {
+	return func(o *bbrOptions) {
+		o.cpuUsage = cpuUsage
+	}
+}
+
+// WithClock source of time, for tests
+func WithClock(now func() time.Time) BbrOption {
+	return func(o *bbrOptions) {
+		o.now = now
+	}
+}
+
+type bbrBucket struct {
+	epoch int64 // index of the bucket since unix epoch
+	pass  int64
+	rtSum time.Duration
+}
+
+// BbrLimiter sheds load adaptively like BBR of TCP: while cpu is high, requests are dropped
+// if inflight reaches maxPass*minRt, the estimated capacity of the service
+// from max throughput and min latency observed in the window
+type BbrLimiter struct {
+	bbrOptions
+	span time.Duration
+
+	inflight atomic.Int64
+	prevDrop atomic.Int64 //in ns, 0 means not dropping
+
+	mutex   sync.Mutex
+	buckets []bbrBucket
+	// maxInflight is computed once per bucket
+	cacheEpoch  int64
+	maxPass     int64
+	minRt       time.Duration
+	maxInflight int64
+}
+
+type BbrStat struct {
+	Cpu         int64
+	Inflight    int64
+	MaxInflight int64
+	MaxPass     int64 // max requests completed in a bucket
+	MinRt       time.Duration
+}
+
+func NewBbrLimiter(opts ...BbrOption) *BbrLimiter {
+	l := &BbrLimiter{bbrOptions: bbrOptions{
+		window:       10 * time.Second,
+		bucketNum:    100,
+		cpuThreshold: 800,
+		coolDown:     time.Second,
+		cpuUsage:     SystemCpuUsage,
+		now:          time.Now,
+	}}
+	for _, o := range opts {
+		o(&l.bbrOptions)
+	}
+	if l.window <= 0 || l.bucketNum <= 0 || l.window < time.Duration(l.bucketNum) {
+		log.Warnf("Invalid bbr window, use 10s and 100 buckets, window:%v, buckets:%v", l.window, l.bucketNum)
+		l.window, l.bucketNum = 10*time.Second, 100
+	}
+
+	l.span = l.window / time.Duration(l.bucketNum)
+	l.buckets = make([]bbrBucket, l.bucketNum)
+	l.cacheEpoch = -1
+	return l
+}
+
+// Allow returns ErrOverloaded if the request should be dropped,
+// otherwise done must be called once the request completes
+func (l *BbrLimiter) Allow() (done func(), err error) {
+	if l.shouldDrop() {
+		return nil, ErrOverloaded
+	}
+
+	l.inflight.Add(1)
+	start := l.now()
+	return func() {
+		now := l.now()
+		l.inflight.Add(-1)
+
+		l.mutex.Lock()
+		b := l.bucket(now)
+		b.pass++
+		b.rtSum += now.Sub(start)
+		l.mutex.Unlock()
+	}, nil
+}
+
+func (l *BbrLimiter) shouldDrop() bool {
+	now := l.now().UnixNano()
+	inflight := l.inflight.Load()
+
+	if l.cpuUsage() < l.cpuThreshold {
+		prevDrop := l.prevDrop.Load()
+		if prevDrop == 0 {
+			return false
+		}
+		if now-prevDrop <= int64(l.coolDown) {
+			return inflight >= l.estimate().maxInflight
+		}
+		l.prevDrop.Store(0)
+		return false
+	}
+
+	if inflight < l.estimate().maxInflight {
+		return false
+	}
+	l.prevDrop.Store(now)
+	return true
+}
+
+// bucket must be called with mutex held
+func (l *BbrLimiter) bucket(now time.Time) *bbrBucket {
+	epoch := now.UnixNano() / int64(l.span)
+	b := &l.buckets[epoch%int64(len(l.buckets))]
+	if b.epoch != epoch {
+		*b = bbrBucket{epoch: epoch}
+	}
+	return b
+}
+
+type bbrEstimate struct {
+	maxPass     int64
+	minRt       time.Duration
+	maxInflight int64
+}
+
+// estimate from buckets completed in the window, the current one is excluded
+func (l *BbrLimiter) estimate() bbrEstimate {
+	epoch := l.now().UnixNano() / int64(l.span)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if epoch != l.cacheEpoch {
+		var maxPass int64
+		var minRt time.Duration
+		for _, b := range l.buckets {
+			if b.epoch >= epoch || b.epoch <= epoch-int64(len(l.buckets)) || b.pass == 0 {
+				continue
+			}
+			maxPass = max(maxPass, b.pass)
+			if rt := b.rtSum / time.Duration(b.pass); minRt == 0 || rt < minRt {
+				minRt = rt
+			}
+		}
+
+		// no stats yet, allow at least one
+		l.maxPass = max(maxPass, 1)
+		l.minRt = max(minRt, time.Millisecond)
+		bucketsPerSecond := float64(time.Second) / float64(l.span)
+		l.maxInflight = max(int64(math.Floor(float64(l.maxPass)*l.minRt.Seconds()*bucketsPerSecond+0.5)), 1)
+		l.cacheEpoch = epoch
+	}
+
+	return bbrEstimate{maxPass: l.maxPass, minRt: l.minRt, maxInflight: l.maxInflight}
+}
+
+func (l *BbrLimiter) Stat() BbrStat {
+	e := l.estimate()
+	return BbrStat{
+		Cpu:         l.cpuUsage(),
+		Inflight:    l.inflight.Load(),
+		MaxInflight: e.maxInflight,
+		MaxPass:     e.maxPass,
+		MinRt:       e.minRt,
+	}
+}
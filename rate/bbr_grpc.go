@@ -0,0 +1,22 @@
+package rate
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BbrUnaryServerInterceptor sheds load by l, dropped calls fail with codes.ResourceExhausted
+func BbrUnaryServerInterceptor(l *BbrLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		done, err := l.Allow()
+		if err != nil {
+			return nil, status.Errorf(codes.ResourceExhausted, "%v, method:%v", err, info.FullMethod)
+		}
+		defer done()
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,205 @@
+package rate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return fc.now
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.now = fc.now.Add(d)
+}
+
+func newTestBbrLimiter() (*BbrLimiter, *fakeClock, *atomic.Int64) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	cpu := &atomic.Int64{}
+	l := NewBbrLimiter(
+		WithBbrWindow(time.Second, 10),
+		WithClock(clock.Now),
+		WithCpuUsage(cpu.Load))
+	return l, clock, cpu
+}
+
+// warmUp completes 10 requests of 50ms in each bucket of 100ms, the estimated max inflight is 10*50ms*10/s = 5
+func warmUp(t *testing.T, l *BbrLimiter, clock *fakeClock) {
+	for i := 0; i < 10; i++ {
+		var dones []func()
+		for j := 0; j < 10; j++ {
+			done, err := l.Allow()
+			require.Nil(t, err)
+			dones = append(dones, done)
+		}
+		clock.Advance(50 * time.Millisecond)
+		for _, done := range dones {
+			done()
+		}
+		clock.Advance(50 * time.Millisecond)
+	}
+}
+
+func TestBbrLimiter(t *testing.T) {
+	l, clock, cpu := newTestBbrLimiter()
+	warmUp(t, l, clock)
+
+	st := l.Stat()
+	require.Equal(t, int64(10), st.MaxPass)
+	require.Equal(t, 50*time.Millisecond, st.MinRt)
+	require.Equal(t, int64(5), st.MaxInflight)
+
+	// nothing is dropped while cpu is low
+	var dones []func()
+	for i := 0; i < 8; i++ {
+		done, err := l.Allow()
+		require.Nil(t, err)
+		dones = append(dones, done)
+	}
+
+	cpu.Store(900)
+	_, err := l.Allow()
+	require.ErrorIs(t, err, ErrOverloaded)
+	for _, done := range dones[:4] {
+		done()
+	}
+	done, err := l.Allow()
+	require.Nil(t, err)
+	dones = append(dones[4:], done)
+	_, err = l.Allow()
+	require.ErrorIs(t, err, ErrOverloaded)
+
+	// keep dropping within cool down after cpu gets lower
+	cpu.Store(100)
+	clock.Advance(500 * time.Millisecond)
+	_, err = l.Allow()
+	require.ErrorIs(t, err, ErrOverloaded)
+
+	clock.Advance(600 * time.Millisecond)
+	done, err = l.Allow()
+	require.Nil(t, err)
+	done()
+	for _, done := range dones {
+		done()
+	}
+	require.Equal(t, int64(0), l.Stat().Inflight)
+}
+
+func TestBbrLimiterWindowExpires(t *testing.T) {
+	l, clock, cpu := newTestBbrLimiter()
+	warmUp(t, l, clock)
+	require.Equal(t, int64(5), l.Stat().MaxInflight)
+
+	// stats older than the window are dropped
+	clock.Advance(2 * time.Second)
+	require.Equal(t, BbrStat{MaxInflight: 1, MaxPass: 1, MinRt: time.Millisecond}, l.Stat())
+
+	cpu.Store(900)
+	done, err := l.Allow()
+	require.Nil(t, err)
+	_, err = l.Allow()
+	require.ErrorIs(t, err, ErrOverloaded)
+	done()
+}
+
+func TestBbrLimiterCoolDownFromLastDrop(t *testing.T) {
+	l, clock, cpu := newTestBbrLimiter()
+	warmUp(t, l, clock)
+
+	var dones []func()
+	for i := 0; i < 5; i++ {
+		done, err := l.Allow()
+		require.Nil(t, err)
+		dones = append(dones, done)
+	}
+
+	cpu.Store(900)
+	_, err := l.Allow()
+	require.ErrorIs(t, err, ErrOverloaded)
+	clock.Advance(800 * time.Millisecond)
+	_, err = l.Allow()
+	require.ErrorIs(t, err, ErrOverloaded)
+
+	// cool down counts from the second drop
+	cpu.Store(100)
+	clock.Advance(500 * time.Millisecond)
+	_, err = l.Allow()
+	require.ErrorIs(t, err, ErrOverloaded)
+
+	clock.Advance(600 * time.Millisecond)
+	done, err := l.Allow()
+	require.Nil(t, err)
+	done()
+	for _, done := range dones {
+		done()
+	}
+}
+
+func TestBbrLimiterInvalidWindow(t *testing.T) {
+	for _, opt := range []BbrOption{WithBbrWindow(time.Second, 0), WithBbrWindow(0, 10), WithBbrWindow(time.Second, -1), WithBbrWindow(10, 100)} {
+		l := NewBbrLimiter(opt, WithCpuUsage(func() int64 { return 900 }))
+		require.Equal(t, 10*time.Second, l.window)
+		require.Equal(t, 100*time.Millisecond, l.span)
+
+		done, err := l.Allow()
+		require.Nil(t, err)
+		done()
+		require.Equal(t, int64(1), l.Stat().MaxPass)
+	}
+}
+
+func TestBbrMiddlewareAndInterceptor(t *testing.T) {
+	l, _, cpu := newTestBbrLimiter()
+	cpu.Store(900)
+
+	e := echo.New()
+	e.Use(BbrWithConfig(BbrConfig{Limiter: l}))
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	e.GET("/slow", func(c echo.Context) error {
+		close(entered)
+		<-release
+		return c.NoContent(http.StatusOK)
+	})
+
+	first := httptest.NewRecorder()
+	go e.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	<-entered
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	interceptor := BbrUnaryServerInterceptor(l)
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Method"},
+		func(ctx context.Context, req any) (any, error) { return "ok", nil })
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	close(release)
+	require.Eventually(t, func() bool { return l.Stat().Inflight == 0 }, time.Second, 10*time.Millisecond)
+
+	resp, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Method"},
+		func(ctx context.Context, req any) (any, error) { return "ok", nil })
+	require.Nil(t, err)
+	require.Equal(t, "ok", resp)
+}
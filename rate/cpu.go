@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package rate
+
+import "github.com/madlabx/pkgx/errors"
+
+func newCpuReader() (cpuReader, error) {
+	return nil, errors.New("cpu usage is not supported")
+}
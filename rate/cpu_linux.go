@@ -0,0 +1,210 @@
+package rate
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/madlabx/pkgx/errors"
+)
+
+var (
+	cgroupRoot     = "/sys/fs/cgroup"
+	procSelfCgroup = "/proc/self/cgroup"
+	procStat       = "/proc/stat"
+)
+
+// newCpuReader reads usage of the cgroup of the process against its cpu quota,
+// so that a container at its quota is seen busy. /proc/stat of the host is read if not in a cgroup
+func newCpuReader() (cpuReader, error) {
+	read, err := newCgroupCpuReader()
+	if err == nil {
+		return read, nil
+	}
+	return readProcStat, nil
+}
+
+// readProcStat returns busy and total jiffies of all cpus from /proc/stat, idle and iowait are not busy
+func readProcStat() (busy, total float64, err error) {
+	f, err := os.Open(procStat)
+	if err != nil {
+		return 0, 0, errors.Wrap(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return 0, 0, errors.Errorf("empty %v", procStat)
+	}
+
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, errors.Errorf("unexpected %v:%v", procStat, scanner.Text())
+	}
+
+	var idle float64
+	for i, field := range fields[1:] {
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, errors.Wrap(err)
+		}
+		total += float64(v)
+		// idle and iowait
+		if i == 3 || i == 4 {
+			idle += float64(v)
+		}
+	}
+
+	return total - idle, total, nil
+}
+
+// cgroupPaths parses /proc/self/cgroup, returns the path of cgroup v2, and paths of v1 by controller
+func cgroupPaths() (v2 string, v1 map[string]string, err error) {
+	data, err := os.ReadFile(procSelfCgroup)
+	if err != nil {
+		return "", nil, errors.Wrap(err)
+	}
+
+	v1 = make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			v2 = parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			v1[controller] = parts[2]
+		}
+	}
+	return v2, v1, nil
+}
+
+// cgroupDir the dir of the cgroup under mount, or mount itself if the cgroup namespace hides the path
+func cgroupDir(mount, path, file string) (string, bool) {
+	for _, dir := range []string{filepath.Join(mount, path), mount} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+func readUint(file string) (uint64, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return v, errors.Wrap(err)
+}
+
+func newCgroupCpuReader() (cpuReader, error) {
+	v2, v1, err := cgroupPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	var read cpuReader
+	if path, ok := v1["cpuacct"]; ok {
+		read, err = newCgroupV1CpuReader(path, v1["cpu"])
+	} else if v2 != "" {
+		read, err = newCgroupV2CpuReader(v2)
+	} else {
+		return nil, errors.New("no cpu cgroup")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, err = read(); err != nil {
+		return nil, err
+	}
+	return read, nil
+}
+
+// cgroupCpuReader busy is cpu time of the cgroup in ns, total is wall time in ns multiplied by cores of the quota
+func cgroupCpuReader(cores float64, usageNs func() (uint64, error)) cpuReader {
+	return func() (busy, total float64, err error) {
+		usage, err := usageNs()
+		if err != nil {
+			return 0, 0, err
+		}
+		return float64(usage), float64(time.Now().UnixNano()) * cores, nil
+	}
+}
+
+// cores of the quota, cpus of the process if no quota
+func quotaCores(quota, period int64) float64 {
+	if quota <= 0 || period <= 0 {
+		return float64(runtime.NumCPU())
+	}
+	return float64(quota) / float64(period)
+}
+
+func newCgroupV1CpuReader(cpuacctPath, cpuPath string) (cpuReader, error) {
+	usageDir, ok := cgroupDir(filepath.Join(cgroupRoot, "cpuacct"), cpuacctPath, "cpuacct.usage")
+	if !ok {
+		return nil, errors.New("no cpuacct.usage of cgroup v1")
+	}
+
+	quota, period := int64(-1), int64(0)
+	if quotaDir, ok := cgroupDir(filepath.Join(cgroupRoot, "cpu"), cpuPath, "cpu.cfs_quota_us"); ok {
+		data, err := os.ReadFile(filepath.Join(quotaDir, "cpu.cfs_quota_us"))
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		if quota, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err != nil {
+			return nil, errors.Wrap(err)
+		}
+		p, err := readUint(filepath.Join(quotaDir, "cpu.cfs_period_us"))
+		if err != nil {
+			return nil, err
+		}
+		period = int64(p)
+	}
+
+	usageFile := filepath.Join(usageDir, "cpuacct.usage")
+	return cgroupCpuReader(quotaCores(quota, period), func() (uint64, error) {
+		return readUint(usageFile)
+	}), nil
+}
+
+func newCgroupV2CpuReader(path string) (cpuReader, error) {
+	dir, ok := cgroupDir(cgroupRoot, path, "cpu.stat")
+	if !ok {
+		return nil, errors.New("no cpu.stat of cgroup v2")
+	}
+
+	// "max 100000" if no quota, cpu.max is absent in the root cgroup
+	quota, period := int64(-1), int64(0)
+	if data, err := os.ReadFile(filepath.Join(dir, "cpu.max")); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 && fields[0] != "max" {
+			quota, _ = strconv.ParseInt(fields[0], 10, 64)
+			period, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+
+	statFile := filepath.Join(dir, "cpu.stat")
+	return cgroupCpuReader(quotaCores(quota, period), func() (uint64, error) {
+		data, err := os.ReadFile(statFile)
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if v, ok := strings.CutPrefix(line, "usage_usec "); ok {
+				usec, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+				return usec * 1000, errors.Wrap(err)
+			}
+		}
+		return 0, errors.Errorf("no usage_usec in %v", statFile)
+	}), nil
+}
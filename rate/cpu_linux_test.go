@@ -0,0 +1,94 @@
+package rate
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(root, name)
+		require.Nil(t, os.MkdirAll(filepath.Dir(file), 0o755))
+		require.Nil(t, os.WriteFile(file, []byte(content), 0o644))
+	}
+}
+
+func fakeCgroup(t *testing.T, files map[string]string) {
+	root := t.TempDir()
+	writeFiles(t, root, files)
+
+	oldRoot, oldSelf := cgroupRoot, procSelfCgroup
+	cgroupRoot, procSelfCgroup = filepath.Join(root, "cgroup"), filepath.Join(root, "self_cgroup")
+	t.Cleanup(func() { cgroupRoot, procSelfCgroup = oldRoot, oldSelf })
+}
+
+func TestCgroupV1CpuReader(t *testing.T) {
+	fakeCgroup(t, map[string]string{
+		"self_cgroup":                            "12:cpu,cpuacct:/docker/c1\n0::/\n",
+		"cgroup/cpu/docker/c1/cpu.cfs_quota_us":  "150000\n",
+		"cgroup/cpu/docker/c1/cpu.cfs_period_us": "100000\n",
+		"cgroup/cpuacct/docker/c1/cpuacct.usage": "3000000000\n",
+		"cgroup/cpuacct/cpuacct.usage":           "9000000000\n",
+		"cgroup/unified/cpu.stat":                "usage_usec 1\n",
+	})
+
+	read, err := newCgroupCpuReader()
+	require.Nil(t, err)
+	busy, total1, err := read()
+	require.Nil(t, err)
+	require.Equal(t, 3e9, busy)
+
+	_, total2, err := read()
+	require.Nil(t, err)
+	require.Greater(t, total2, total1)
+}
+
+func TestCgroupV2CpuReader(t *testing.T) {
+	fakeCgroup(t, map[string]string{
+		"self_cgroup":     "0::/\n",
+		"cgroup/cpu.stat": "usage_usec 2500\nuser_usec 2000\nsystem_usec 500\n",
+		"cgroup/cpu.max":  "50000 100000\n",
+	})
+
+	read, err := newCgroupCpuReader()
+	require.Nil(t, err)
+	busy, _, err := read()
+	require.Nil(t, err)
+	require.Equal(t, 2.5e6, busy)
+}
+
+func TestCgroupCpuReaderNamespaced(t *testing.T) {
+	// the path of /proc/self/cgroup is not visible inside a cgroup namespace, the mount is the cgroup itself
+	fakeCgroup(t, map[string]string{
+		"self_cgroup":     "0::/kubepods/pod1/c1\n",
+		"cgroup/cpu.stat": "usage_usec 10\n",
+	})
+
+	read, err := newCgroupCpuReader()
+	require.Nil(t, err)
+	busy, _, err := read()
+	require.Nil(t, err)
+	require.Equal(t, 1e4, busy)
+}
+
+func TestCgroupCpuReaderFallback(t *testing.T) {
+	fakeCgroup(t, map[string]string{"self_cgroup": "0::/\n"})
+	_, err := newCgroupCpuReader()
+	require.NotNil(t, err)
+
+	read, err := newCpuReader()
+	require.Nil(t, err)
+	busy, total, err := read()
+	require.Nil(t, err)
+	require.Greater(t, total, busy)
+}
+
+func TestQuotaCores(t *testing.T) {
+	require.Equal(t, 1.5, quotaCores(150000, 100000))
+	require.Equal(t, float64(runtime.NumCPU()), quotaCores(-1, 100000))
+	require.Equal(t, float64(runtime.NumCPU()), quotaCores(-1, 0))
+}
@@ -0,0 +1,59 @@
+package rate
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/madlabx/pkgx/log"
+)
+
+const (
+	cpuSampleInterval = 500 * time.Millisecond
+	cpuDecay          = 0.8
+)
+
+// cpuReader returns cumulative busy and total time of cpus in the same unit, usage is the ratio of their deltas
+type cpuReader func() (busy, total float64, err error)
+
+var (
+	cpuOnce  sync.Once
+	cpuUsage atomic.Int64
+)
+
+// SystemCpuUsage cpu usage in permille, smoothed by EWMA, sampled every 500ms since the first call.
+// On linux it is usage of the cgroup against its cpu quota, or of the host if not in a cgroup.
+// Always 0 if not supported, then BbrLimiter never sheds load unless WithCpuUsage is given
+func SystemCpuUsage() int64 {
+	cpuOnce.Do(func() {
+		read, err := newCpuReader()
+		var busy, total float64
+		if err == nil {
+			busy, total, err = read()
+		}
+		if err != nil {
+			log.Warnf("Cpu usage not available, BbrLimiter by SystemCpuUsage never sheds load, err:%v", err)
+			return
+		}
+		go sampleCpu(read, busy, total)
+	})
+	return cpuUsage.Load()
+}
+
+func sampleCpu(read cpuReader, prevBusy, prevTotal float64) {
+	ticker := time.NewTicker(cpuSampleInterval)
+	defer ticker.Stop()
+
+	var smoothed float64
+	for range ticker.C {
+		busy, total, err := read()
+		if err != nil || total <= prevTotal {
+			continue
+		}
+
+		cur := min(1000*(busy-prevBusy)/(total-prevTotal), 1000)
+		smoothed = smoothed*cpuDecay + cur*(1-cpuDecay)
+		cpuUsage.Store(int64(smoothed))
+		prevBusy, prevTotal = busy, total
+	}
+}
@@ -0,0 +1,190 @@
+package rate
+
+import (
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/madlabx/pkgx/log"
+	"github.com/madlabx/pkgx/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+func (ll LimitLevel) String() string {
+	switch ll {
+	case EnumLevelGlobal:
+		return "global"
+	case EnumLevelUser:
+		return "user"
+	case EnumLevelClientId:
+		return "client_id"
+	case EnumLevelClientIp:
+		return "client_ip"
+	default:
+		return "level_" + strconv.Itoa(int(ll))
+	}
+}
+
+var allLevels = []LimitLevel{EnumLevelGlobal, EnumLevelUser, EnumLevelClientId, EnumLevelClientIp}
+
+// GCRA over all KEYS, the state of a key is its theoretical arrival time in ms, based on the clock of redis.
+// A request takes cost from every key only if allowed by all of them.
+// KEYS[i]: key of a level, ARGV[1]: cost, ARGV[2i]: qps of KEYS[i], ARGV[2i+1]: burst of KEYS[i]
+// returns {allowed, index of the key rejecting or with the least remaining, retry after in ms, remaining, reset in ms}
+var gcraScript = goredis.NewScript(`
+redis.replicate_commands()
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + tonumber(t[2]) / 1000
+local cost = tonumber(ARGV[1])
+
+local new_tats = {}
+local least, least_remaining, least_reset = 0, 0, 0
+for i, key in ipairs(KEYS) do
+	local interval = 1000 / tonumber(ARGV[2 * i])
+	local burst_offset = interval * tonumber(ARGV[2 * i + 1])
+
+	local tat = tonumber(redis.call("GET", key))
+	if not tat or tat < now then
+		tat = now
+	end
+
+	local new_tat = tat + interval * cost
+	local diff = now - (new_tat - burst_offset)
+	if diff < 0 then
+		return {0, i, math.ceil(-diff), 0, math.ceil(tat - now)}
+	end
+	new_tats[i] = new_tat
+
+	local remaining = math.floor(diff / interval + 1e-6)
+	if least == 0 or remaining < least_remaining then
+		least, least_remaining, least_reset = i, remaining, math.ceil(new_tat - now)
+	end
+end
+
+for i, key in ipairs(KEYS) do
+	redis.call("SET", key, tostring(new_tats[i]), "PX", math.ceil(new_tats[i] - now))
+end
+return {1, least, 0, least_remaining, least_reset}
+`)
+
+// DistQpsLimiter shares limits of QpsLimiter levels among replicas through redis, by GCRA.
+// Limits fall back to per-process limiters with the same qps and burst while redis is unavailable.
+// Levels with qps <= 0 or infinite are not supported by GCRA and dropped
+type DistQpsLimiter struct {
+	rc     *redis.Client
+	name   string
+	levels map[LimitLevel]*qpsOptions
+	local  *QpsLimiter
+
+	// redis is skipped until downUntil(unix ms) after a failure
+	retryInterval time.Duration
+	downUntil     atomic.Int64
+}
+
+// NewDistQpsLimiter limiters with the same name share limits, levels are configured by QpsLimitOpt
+func NewDistQpsLimiter(rc *redis.Client, name string, opts ...QpsOption) *DistQpsLimiter {
+	ql := NewQpsLimiter(opts...)
+	for level, o := range ql.levels {
+		if !(o.qps > 0) || math.IsInf(o.qps, 1) {
+			log.Warnf("Invalid qps of DistQpsLimiter, level dropped, name:%v, level:%v, qps:%v", name, level, o.qps)
+			delete(ql.levels, level)
+			delete(ql.limiters, level)
+		}
+	}
+
+	return &DistQpsLimiter{
+		rc:            rc,
+		name:          name,
+		levels:        ql.levels,
+		local:         ql,
+		retryInterval: time.Second,
+	}
+}
+
+// Allow checks limit of level for key, key is ignored by EnumLevelGlobal.
+// Always true if the level is not configured or key is empty
+func (l *DistQpsLimiter) Allow(level LimitLevel, key string) bool {
+	return l.decide([]LimitLevel{level}, []string{key}).Allowed
+}
+
+// AllowAll checks levels from Global to ClientIp at once, a rejected request takes no token of any level.
+// Returns the level rejecting and how long to wait before retry
+func (l *DistQpsLimiter) AllowAll(user, clientId, clientIp string) (bool, LimitLevel, time.Duration) {
+	d := l.Decide(user, clientId, clientIp)
+	if d.Allowed {
+		return true, EnumLevelGlobal, 0
+	}
+	return false, d.Level, d.RetryAfter
+}
+
+// Decide is AllowAll with the Decision as QpsLimiter.Decide, so that it can be a profile of LimitWithConfig
+func (l *DistQpsLimiter) Decide(user, clientId, clientIp string) Decision {
+	return l.decide(allLevels, []string{"", user, clientId, clientIp})
+}
+
+func (l *DistQpsLimiter) decide(levels []LimitLevel, keys []string) Decision {
+	var (
+		checked   []LimitLevel
+		redisKeys []string
+		args      = []any{1}
+	)
+	for i, level := range levels {
+		o, ok := l.levels[level]
+		if !ok || (keys[i] == "" && level != EnumLevelGlobal) {
+			continue
+		}
+		checked = append(checked, level)
+		redisKeys = append(redisKeys, l.redisKey(level, keys[i]))
+		args = append(args, o.qps, o.burst)
+	}
+	if len(checked) == 0 {
+		return Decision{Allowed: true}
+	}
+
+	now := time.Now()
+	if now.UnixMilli() >= l.downUntil.Load() {
+		ret, err := l.rc.RunScript(gcraScript, redisKeys, args...).Int64Slice()
+		if err == nil {
+			if l.downUntil.Swap(0) != 0 {
+				log.Infof("Redis recovered, stop local limiter, name:%v", l.name)
+			}
+			level := checked[ret[1]-1]
+			d := Decision{
+				Allowed:   ret[0] == 1,
+				Level:     level,
+				Limit:     l.levels[level].burst,
+				Remaining: int(ret[3]),
+				Reset:     time.Duration(ret[4]) * time.Millisecond,
+			}
+			if !d.Allowed {
+				d.RetryAfter = time.Duration(ret[2]) * time.Millisecond
+			}
+			return d
+		}
+
+		if l.downUntil.Swap(now.Add(l.retryInterval).UnixMilli()) == 0 {
+			log.Errorf("Redis unavailable, fall back to local limiter, name:%v, err:%v", l.name, err)
+		}
+	}
+
+	r := l.local.reserve()
+	for i, level := range levels {
+		r.allow(level, keys[i])
+	}
+	return r.Decision()
+}
+
+// redisKey keys of a limiter share the hash tag, so that levels are checked by one script in cluster mode
+func (l *DistQpsLimiter) redisKey(level LimitLevel, key string) string {
+	return "rate_{" + l.name + "}_" + level.String() + "_" + key
+}
+
+// LocalStats stats of the fallback limiters by level
+func (l *DistQpsLimiter) LocalStats() map[LimitLevel]LimiterStats {
+	stats := make(map[LimitLevel]LimiterStats, len(l.local.limiters))
+	for level, lm := range l.local.limiters {
+		stats[level] = lm.stats()
+	}
+	return stats
+}
@@ -0,0 +1,188 @@
+package rate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo"
+	"github.com/madlabx/pkgx/redis"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func newMiniRedisClient(t *testing.T, mr *miniredis.Miniredis) *redis.Client {
+	rdb := goredis.NewClient(&goredis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return redis.NewTestClient(context.Background(), rdb)
+}
+
+func countAllowed(l *DistQpsLimiter, level LimitLevel, key string, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if l.Allow(level, key) {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestDistQpsLimiterSharedByReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	now := time.Now()
+	mr.SetTime(now)
+
+	opts := []QpsOption{QpsLimitOpt(EnumLevelUser, 10, 5)}
+	l1 := NewDistQpsLimiter(newMiniRedisClient(t, mr), "api", opts...)
+	l2 := NewDistQpsLimiter(newMiniRedisClient(t, mr), "api", opts...)
+
+	// burst is shared by both replicas
+	require.Equal(t, 3, countAllowed(l1, EnumLevelUser, "u1", 3))
+	require.Equal(t, 2, countAllowed(l2, EnumLevelUser, "u1", 10))
+	require.Equal(t, 0, countAllowed(l1, EnumLevelUser, "u1", 10))
+
+	// other users and unconfigured levels are not limited
+	require.Equal(t, 5, countAllowed(l2, EnumLevelUser, "u2", 10))
+	require.Equal(t, 10, countAllowed(l1, EnumLevelGlobal, "", 10))
+	require.Equal(t, 10, countAllowed(l1, EnumLevelUser, "", 10))
+
+	// one token per 100ms at 10 qps
+	mr.SetTime(now.Add(250 * time.Millisecond))
+	require.Equal(t, 2, countAllowed(l2, EnumLevelUser, "u1", 10))
+
+	mr.SetTime(now.Add(10 * time.Second))
+	require.Equal(t, 5, countAllowed(l1, EnumLevelUser, "u1", 10))
+}
+
+func TestDistQpsLimiterAllowAll(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Now())
+
+	l := NewDistQpsLimiter(newMiniRedisClient(t, mr), "api",
+		QpsLimitOpt(EnumLevelGlobal, 100, 100),
+		QpsLimitOpt(EnumLevelClientIp, 1, 2))
+
+	for i := 0; i < 2; i++ {
+		allowed, _, _ := l.AllowAll("u1", "dev1", "10.0.0.1")
+		require.True(t, allowed)
+	}
+
+	allowed, level, retryAfter := l.AllowAll("u1", "dev1", "10.0.0.1")
+	require.False(t, allowed)
+	require.Equal(t, EnumLevelClientIp, level)
+	require.Greater(t, retryAfter, 900*time.Millisecond)
+	require.LessOrEqual(t, retryAfter, time.Second)
+
+	allowed, _, _ = l.AllowAll("u1", "dev1", "10.0.0.2")
+	require.True(t, allowed)
+}
+
+func TestDistQpsLimiterRejectionTakesNoToken(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Now())
+
+	l := NewDistQpsLimiter(newMiniRedisClient(t, mr), "api",
+		QpsLimitOpt(EnumLevelGlobal, 0.001, 3),
+		QpsLimitOpt(EnumLevelClientIp, 0.001, 1))
+
+	check := func() {
+		allowed, _, _ := l.AllowAll("u1", "dev1", "10.0.0.1")
+		require.True(t, allowed)
+
+		// rejected by ClientIp, Global keeps its 2 tokens
+		for i := 0; i < 5; i++ {
+			allowed, level, _ := l.AllowAll("u1", "dev1", "10.0.0.1")
+			require.False(t, allowed)
+			require.Equal(t, EnumLevelClientIp, level)
+		}
+		require.Equal(t, 2, countAllowed(l, EnumLevelGlobal, "", 5))
+	}
+	check()
+
+	// so does the local fallback
+	mr.Close()
+	check()
+	require.NotZero(t, l.downUntil.Load())
+}
+
+func TestDistQpsLimiterFallback(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	l := NewDistQpsLimiter(newMiniRedisClient(t, mr), "api", QpsLimitOpt(EnumLevelUser, 0.001, 3))
+	l.retryInterval = 100 * time.Millisecond
+	require.Equal(t, 3, countAllowed(l, EnumLevelUser, "u1", 5))
+
+	mr.Close()
+	require.Equal(t, 3, countAllowed(l, EnumLevelUser, "u1", 5))
+	require.NotZero(t, l.downUntil.Load())
+
+	require.Nil(t, mr.Restart())
+	time.Sleep(150 * time.Millisecond)
+	// limited by redis again, whose state survives the restart
+	require.Equal(t, 0, countAllowed(l, EnumLevelUser, "u1", 5))
+	require.Zero(t, l.downUntil.Load())
+}
+
+func TestDistQpsLimiterDecide(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Now())
+
+	l := NewDistQpsLimiter(newMiniRedisClient(t, mr), "api",
+		QpsLimitOpt(EnumLevelGlobal, 100, 100),
+		QpsLimitOpt(EnumLevelClientIp, 0.5, 3))
+
+	e := echo.New()
+	e.Use(LimitWithConfig(LimitConfig{
+		Profiles:       map[string]Decider{"ip": l},
+		DefaultProfile: "ip",
+	}))
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-IP", "10.0.0.1")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// ClientIp has the least remaining
+	rec := do()
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "3", rec.Header().Get(HeaderRateLimitLimit))
+	require.Equal(t, "2", rec.Header().Get(HeaderRateLimitRemaining))
+	require.Equal(t, "2", rec.Header().Get(HeaderRateLimitReset))
+
+	require.Equal(t, http.StatusOK, do().Code)
+	rec = do()
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "0", rec.Header().Get(HeaderRateLimitRemaining))
+	require.Equal(t, "6", rec.Header().Get(HeaderRateLimitReset))
+
+	rec = do()
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "2", rec.Header().Get(HeaderRetryAfter))
+
+	d := l.Decide("u1", "dev1", "10.0.0.1")
+	require.False(t, d.Allowed)
+	require.Equal(t, EnumLevelClientIp, d.Level)
+	require.Equal(t, 3, d.Limit)
+}
+
+func TestDistQpsLimiterInvalidQps(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Now())
+
+	l := NewDistQpsLimiter(newMiniRedisClient(t, mr), "api",
+		QpsLimitOpt(EnumLevelGlobal, 0, 1),
+		QpsLimitOpt(EnumLevelUser, 10, 2))
+	require.NotContains(t, l.LocalStats(), EnumLevelGlobal)
+
+	// the dropped level does not break the script, so redis stays in use
+	require.Equal(t, 2, countAllowed(l, EnumLevelUser, "u1", 5))
+	require.Equal(t, 5, countAllowed(l, EnumLevelGlobal, "", 5))
+	require.Zero(t, l.downUntil.Load())
+}
@@ -0,0 +1,8 @@
+package rate
+
+import "errors"
+
+var (
+	ErrOverloaded  = errors.New("overloaded")
+	ErrExceedParal = errors.New("permits exceed paral")
+)
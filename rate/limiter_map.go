@@ -0,0 +1,155 @@
+package rate
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type LimiterMapOption func(*limiterMapOptions)
+
+type limiterMapOptions struct {
+	maxKeys     int
+	idleTimeout time.Duration
+}
+
+var defaultLimiterMapOptions = limiterMapOptions{
+	maxKeys:     100000,
+	idleTimeout: 10 * time.Minute,
+}
+
+// WithMaxKeys the least recently used limiter is evicted if keys of a level exceed maxKeys, 0 means unbounded.
+// The bound is kept per shard, keys may be evicted a little earlier than maxKeys
+func WithMaxKeys(maxKeys int) LimiterMapOption {
+	return func(o *limiterMapOptions) {
+		o.maxKeys = maxKeys
+	}
+}
+
+// WithIdleTimeout limiters not used for idleTimeout are dropped lazily on access of the same shard, 0 means never.
+// It is raised to the time to refill the burst, so that dropping a limiter never resets a limited key
+func WithIdleTimeout(idleTimeout time.Duration) LimiterMapOption {
+	return func(o *limiterMapOptions) {
+		o.idleTimeout = idleTimeout
+	}
+}
+
+// LimiterStats of limiters of keys of a level
+type LimiterStats struct {
+	ActiveKeys  int
+	Evictions   uint64 // dropped due to WithMaxKeys
+	Expirations uint64 // dropped due to WithIdleTimeout
+}
+
+const (
+	maxLimiterShards = 32
+	minKeysPerShard  = 1024
+)
+
+// limiterMap holds a rate.Limiter per key, sharded by key, each shard is a LRU list
+type limiterMap struct {
+	o           *qpsOptions
+	idleTimeout int64 //in ns
+	seed        maphash.Seed
+	shards      []*limiterShard
+
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
+}
+
+type limiterShard struct {
+	mutex   sync.Mutex
+	maxKeys int
+	items   map[string]*list.Element
+	lru     *list.List // front is the most recently used
+}
+
+type limiterEntry struct {
+	key      string
+	limiter  *rate.Limiter
+	lastUsed int64 //in ns
+}
+
+func newLimiterMap(o *qpsOptions) *limiterMap {
+	idleTimeout := o.keyMap.idleTimeout
+	if idleTimeout > 0 && o.qps > 0 {
+		idleTimeout = max(idleTimeout, time.Duration(float64(o.burst)/o.qps*float64(time.Second)))
+	}
+
+	n := 1
+	if o.keyMap.maxKeys <= 0 {
+		n = maxLimiterShards
+	} else if o.keyMap.maxKeys >= minKeysPerShard {
+		n = min(maxLimiterShards, o.keyMap.maxKeys/minKeysPerShard)
+	}
+
+	lm := &limiterMap{o: o, idleTimeout: int64(idleTimeout), seed: maphash.MakeSeed(), shards: make([]*limiterShard, n)}
+	for i := range lm.shards {
+		lm.shards[i] = &limiterShard{items: make(map[string]*list.Element), lru: list.New()}
+		if o.keyMap.maxKeys > 0 {
+			// round up, so that the total is not less than maxKeys
+			lm.shards[i].maxKeys = (o.keyMap.maxKeys + n - 1) / n
+		}
+	}
+
+	return lm
+}
+
+func (lm *limiterMap) shard(key string) *limiterShard {
+	if len(lm.shards) == 1 {
+		return lm.shards[0]
+	}
+	return lm.shards[maphash.String(lm.seed, key)%uint64(len(lm.shards))]
+}
+
+func (lm *limiterMap) get(key string) *rate.Limiter {
+	s := lm.shard(key)
+	now := time.Now().UnixNano()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// expire idle limiters from the back, the least recently used
+	if lm.idleTimeout > 0 {
+		for e := s.lru.Back(); e != nil && now-e.Value.(*limiterEntry).lastUsed > lm.idleTimeout; e = s.lru.Back() {
+			s.remove(e)
+			lm.expirations.Add(1)
+		}
+	}
+
+	if e, ok := s.items[key]; ok {
+		s.lru.MoveToFront(e)
+		entry := e.Value.(*limiterEntry)
+		entry.lastUsed = now
+		return entry.limiter
+	}
+
+	entry := &limiterEntry{key: key, limiter: rate.NewLimiter(rate.Limit(lm.o.qps), lm.o.burst), lastUsed: now}
+	s.items[key] = s.lru.PushFront(entry)
+
+	if s.maxKeys > 0 && s.lru.Len() > s.maxKeys {
+		s.remove(s.lru.Back())
+		lm.evictions.Add(1)
+	}
+
+	return entry.limiter
+}
+
+func (s *limiterShard) remove(e *list.Element) {
+	s.lru.Remove(e)
+	delete(s.items, e.Value.(*limiterEntry).key)
+}
+
+func (lm *limiterMap) stats() LimiterStats {
+	st := LimiterStats{Evictions: lm.evictions.Load(), Expirations: lm.expirations.Load()}
+	for _, s := range lm.shards {
+		s.mutex.Lock()
+		st.ActiveKeys += s.lru.Len()
+		s.mutex.Unlock()
+	}
+	return st
+}
@@ -0,0 +1,67 @@
+package rate
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterMapLru(t *testing.T) {
+	l := NewQpsLimiter(QpsLimitOpt(EnumLevelClientIp, 1, 1, WithMaxKeys(3), WithIdleTimeout(0)))
+
+	for _, ip := range []string{"ip1", "ip2", "ip3"} {
+		require.True(t, l.Decide("", "", ip).Allowed)
+	}
+	// ip1 becomes the most recently used
+	require.False(t, l.Decide("", "", "ip1").Allowed)
+
+	require.True(t, l.Decide("", "", "ip4").Allowed)
+	require.Equal(t, LimiterStats{ActiveKeys: 3, Evictions: 1}, l.Stats()[EnumLevelClientIp])
+
+	// ip1 is kept, ip2 is evicted
+	require.False(t, l.Decide("", "", "ip1").Allowed)
+	require.True(t, l.Decide("", "", "ip2").Allowed)
+	require.Equal(t, uint64(2), l.Stats()[EnumLevelClientIp].Evictions)
+}
+
+func TestLimiterMapIdleExpiry(t *testing.T) {
+	l := NewQpsLimiter(QpsLimitOpt(EnumLevelUser, 100, 1, WithMaxKeys(100), WithIdleTimeout(30*time.Millisecond)))
+
+	for i := 0; i < 10; i++ {
+		l.Decide("u"+strconv.Itoa(i), "", "")
+	}
+	require.Equal(t, 10, l.Stats()[EnumLevelUser].ActiveKeys)
+
+	// expired lazily by the next access to the shard
+	time.Sleep(50 * time.Millisecond)
+	l.Decide("u0", "", "")
+	st := l.Stats()[EnumLevelUser]
+	require.Equal(t, 1, st.ActiveKeys)
+	require.Equal(t, uint64(10), st.Expirations)
+}
+
+func TestLimiterMapIdleNotBeforeRefill(t *testing.T) {
+	// refilling 5 tokens takes 5s, longer than the idle timeout
+	l := NewQpsLimiter(QpsLimitOpt(EnumLevelUser, 1, 5, WithIdleTimeout(10*time.Millisecond)))
+	for i := 0; i < 5; i++ {
+		require.True(t, l.Decide("u1", "", "").Allowed)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	require.False(t, l.Decide("u1", "", "").Allowed)
+	require.Zero(t, l.Stats()[EnumLevelUser].Expirations)
+}
+
+func TestLimiterMapSharded(t *testing.T) {
+	lm := newLimiterMap(&qpsOptions{qps: 1, burst: 1, keyMap: limiterMapOptions{maxKeys: 64 * 1024}})
+	require.Equal(t, maxLimiterShards, len(lm.shards))
+	require.Equal(t, 2048, lm.shards[0].maxKeys)
+
+	for i := 0; i < 1000; i++ {
+		lm.get(strconv.Itoa(i))
+	}
+	require.Same(t, lm.get("1"), lm.get("1"))
+	require.Equal(t, LimiterStats{ActiveKeys: 1000}, lm.stats())
+}
@@ -0,0 +1,293 @@
+package rate
+
+import (
+	"math"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/madlabx/pkgx/errcode"
+	"github.com/madlabx/pkgx/httpx"
+	"github.com/madlabx/pkgx/log"
+)
+
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRetryAfter         = "Retry-After"
+)
+
+// KeyExtractor returns the key of a limit level for the request, "" skips the level
+type KeyExtractor func(c echo.Context) string
+
+// KeyFromHeader value of the request header
+func KeyFromHeader(name string) KeyExtractor {
+	return func(c echo.Context) string {
+		return c.Request().Header.Get(name)
+	}
+}
+
+// KeyFromRealIp client ip by httpx.GetRealIp
+func KeyFromRealIp() KeyExtractor {
+	return func(c echo.Context) string {
+		return httpx.GetRealIp(c.Request())
+	}
+}
+
+// KeyFromJwtClaim claim of the token stored in echo context by the jwt middleware, contextKey is "user" by default of echo
+func KeyFromJwtClaim(contextKey, claim string) KeyExtractor {
+	return func(c echo.Context) string {
+		switch v := claimsOf(c.Get(contextKey))[claim].(type) {
+		case nil:
+			return ""
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			log.Warnf("Unsupported type of jwt claim, claim:%v, type:%T", claim, v)
+			return ""
+		}
+	}
+}
+
+var mapClaimsType = reflect.TypeOf(map[string]any{})
+
+// claimsOf claims of *jwt.Token or a map, or of tokens of other jwt packages by the field Claims,
+// e.g. github.com/dgrijalva/jwt-go used by the jwt middleware of echo v3
+func claimsOf(token any) map[string]any {
+	switch v := token.(type) {
+	case *jwt.Token:
+		claims, _ := v.Claims.(jwt.MapClaims)
+		return claims
+	case jwt.MapClaims:
+		return v
+	case map[string]any:
+		return v
+	}
+
+	tv := reflect.Indirect(reflect.ValueOf(token))
+	if tv.Kind() != reflect.Struct {
+		return nil
+	}
+	cv := tv.FieldByName("Claims")
+	if cv.Kind() == reflect.Interface {
+		cv = cv.Elem()
+	}
+	if !cv.IsValid() || !cv.CanConvert(mapClaimsType) {
+		return nil
+	}
+	return cv.Convert(mapClaimsType).Interface().(map[string]any)
+}
+
+// KeyFromPrincipal Id of the principal stored by httpx.AuthWithConfig, "" if not authenticated
+func KeyFromPrincipal() KeyExtractor {
+	return func(c echo.Context) string {
+		if p := httpx.GetPrincipal(c); p != nil {
+			return p.Id
+		}
+		return ""
+	}
+}
+
+// KeyFromPrincipalClientId ClientId of the principal, e.g. client_id of jwt or access key of hmac
+func KeyFromPrincipalClientId() KeyExtractor {
+	return func(c echo.Context) string {
+		if p := httpx.GetPrincipal(c); p != nil {
+			return p.ClientId
+		}
+		return ""
+	}
+}
+
+// KeyFromFirst the first non-empty key of extractors
+func KeyFromFirst(extractors ...KeyExtractor) KeyExtractor {
+	return func(c echo.Context) string {
+		for _, e := range extractors {
+			if key := e(c); key != "" {
+				return key
+			}
+		}
+		return ""
+	}
+}
+
+// RouteProfile maps routes to a profile of LimitConfig.Profiles.
+// Pattern is "[METHOD ]path", path is matched against the route template like /v1/users/:id by path.Match,
+// and a trailing "*" matches any suffix
+type RouteProfile struct {
+	Pattern string
+	Profile string
+}
+
+type LimitConfig struct {
+	Skipper        middleware.Skipper
+	Profiles       map[string]Decider // QpsLimiter, or DistQpsLimiter to share limits among replicas
+	Routes         []RouteProfile     // the first matched wins
+	DefaultProfile string             // used if no route matches, "" means not limited
+
+	User     KeyExtractor // KeyFromPrincipal by default, so that the user level applies after httpx.AuthWithConfig
+	ClientId KeyExtractor // nil skips the level
+	ClientIp KeyExtractor // KeyFromRealIp by default
+
+	// OnLimited responds to rejected requests, 429 by default
+	OnLimited func(c echo.Context, d Decision) error
+}
+
+type routeMatcher struct {
+	method  string
+	pattern string
+	prefix  bool
+	limiter Decider
+}
+
+func (rm *routeMatcher) match(method, route string) bool {
+	if rm.method != "" && rm.method != method {
+		return false
+	}
+	if rm.prefix {
+		return strings.HasPrefix(route, rm.pattern)
+	}
+	ok, _ := path.Match(rm.pattern, route)
+	return ok
+}
+
+// LimitWithConfig limits requests by Decide of the profile matched by route, and sets RateLimit-* headers
+func LimitWithConfig(conf LimitConfig) echo.MiddlewareFunc {
+	if conf.Skipper == nil {
+		conf.Skipper = middleware.DefaultSkipper
+	}
+	if conf.User == nil {
+		conf.User = KeyFromPrincipal()
+	}
+	if conf.ClientIp == nil {
+		conf.ClientIp = KeyFromRealIp()
+	}
+	if conf.OnLimited == nil {
+		conf.OnLimited = defaultOnLimited
+	}
+
+	var matchers []*routeMatcher
+	for _, r := range conf.Routes {
+		l, ok := conf.Profiles[r.Profile]
+		if !ok {
+			panic("rate: unknown limit profile " + r.Profile)
+		}
+
+		rm := &routeMatcher{pattern: r.Pattern, limiter: l}
+		if method, p, found := strings.Cut(r.Pattern, " "); found {
+			rm.method, rm.pattern = strings.ToUpper(method), strings.TrimSpace(p)
+		}
+		if strings.HasSuffix(rm.pattern, "*") {
+			rm.prefix, rm.pattern = true, strings.TrimSuffix(rm.pattern, "*")
+		}
+		matchers = append(matchers, rm)
+	}
+
+	var defaultLimiter Decider
+	if conf.DefaultProfile != "" {
+		var ok bool
+		if defaultLimiter, ok = conf.Profiles[conf.DefaultProfile]; !ok {
+			panic("rate: unknown limit profile " + conf.DefaultProfile)
+		}
+	}
+
+	key := func(e KeyExtractor, c echo.Context) string {
+		if e == nil {
+			return ""
+		}
+		return e(c)
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if conf.Skipper(c) {
+				return next(c)
+			}
+
+			l := defaultLimiter
+			method, route := c.Request().Method, c.Path()
+			for _, rm := range matchers {
+				if rm.match(method, route) {
+					l = rm.limiter
+					break
+				}
+			}
+			if l == nil {
+				return next(c)
+			}
+
+			d := l.Decide(key(conf.User, c), key(conf.ClientId, c), key(conf.ClientIp, c))
+			setRateLimitHeaders(c.Response().Header(), d)
+			if !d.Allowed {
+				return conf.OnLimited(c, d)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+func setRateLimitHeaders(h http.Header, d Decision) {
+	if d.Limit == 0 {
+		return
+	}
+
+	h.Set(HeaderRateLimitLimit, strconv.Itoa(d.Limit))
+	h.Set(HeaderRateLimitRemaining, strconv.Itoa(d.Remaining))
+	h.Set(HeaderRateLimitReset, ceilSeconds(d.Reset))
+	if !d.Allowed && d.RetryAfter > 0 {
+		h.Set(HeaderRetryAfter, ceilSeconds(d.RetryAfter))
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+func defaultOnLimited(c echo.Context, d Decision) error {
+	return httpx.SendResp(c, errcode.ErrTooManyRequests().WithErrorf("rate limited, level:%v", d.Level))
+}
+
+type BbrConfig struct {
+	Skipper middleware.Skipper
+	Limiter *BbrLimiter
+
+	// OnOverloaded responds to dropped requests, 503 by default
+	OnOverloaded func(c echo.Context) error
+}
+
+// BbrWithConfig sheds load by BbrLimiter
+func BbrWithConfig(conf BbrConfig) echo.MiddlewareFunc {
+	if conf.Skipper == nil {
+		conf.Skipper = middleware.DefaultSkipper
+	}
+	if conf.OnOverloaded == nil {
+		conf.OnOverloaded = func(c echo.Context) error {
+			return httpx.SendResp(c, errcode.ErrServiceUnavailable().WithError(ErrOverloaded))
+		}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if conf.Skipper(c) {
+				return next(c)
+			}
+
+			done, err := conf.Limiter.Allow()
+			if err != nil {
+				return conf.OnOverloaded(c)
+			}
+			defer done()
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package rate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo"
+	"github.com/madlabx/pkgx/httpx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQpsLimiterDecideConcurrently(t *testing.T) {
+	l := NewQpsLimiter(QpsLimitOpt(EnumLevelGlobal, 0.001, 100), QpsLimitOpt(EnumLevelUser, 0.001, 10))
+
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		allowed = map[string]int{}
+	)
+	for i := 0; i < 50; i++ {
+		for _, user := range []string{"u1", "u2"} {
+			wg.Add(1)
+			go func(user string) {
+				defer wg.Done()
+				if l.Decide(user, "", "").Allowed {
+					mutex.Lock()
+					allowed[user]++
+					mutex.Unlock()
+				}
+			}(user)
+		}
+	}
+	wg.Wait()
+	require.Equal(t, map[string]int{"u1": 10, "u2": 10}, allowed)
+
+	d := l.Decide("u1", "", "")
+	require.False(t, d.Allowed)
+	require.Equal(t, EnumLevelUser, d.Level)
+	require.Equal(t, 10, d.Limit)
+	require.Greater(t, d.RetryAfter.Seconds(), 900.0)
+
+	// no key, only global is checked
+	d = l.Decide("", "", "")
+	require.True(t, d.Allowed)
+	require.Equal(t, EnumLevelGlobal, d.Level)
+	require.Equal(t, 79, d.Remaining)
+}
+
+func TestLimitMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user", &jwt.Token{Claims: jwt.MapClaims{"sub": c.Request().Header.Get("X-Sub")}})
+			return next(c)
+		}
+	})
+	e.Use(LimitWithConfig(LimitConfig{
+		Profiles: map[string]Decider{
+			"strict": NewQpsLimiter(QpsLimitOpt(EnumLevelUser, 0.5, 1)),
+			"loose":  NewQpsLimiter(QpsLimitOpt(EnumLevelClientIp, 0.5, 3)),
+		},
+		Routes: []RouteProfile{
+			{Pattern: "POST /v1/orders/*", Profile: "strict"},
+		},
+		DefaultProfile: "loose",
+		User:           KeyFromJwtClaim("user", "sub"),
+	}))
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.POST("/v1/orders/:id", ok)
+	e.GET("/v1/orders/:id", ok)
+
+	do := func(method, target, sub string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("X-Sub", sub)
+		req.Header.Set("X-Real-IP", "10.0.0.1")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/v1/orders/1", "alice")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "1", rec.Header().Get(HeaderRateLimitLimit))
+	require.Equal(t, "0", rec.Header().Get(HeaderRateLimitRemaining))
+	require.Equal(t, "2", rec.Header().Get(HeaderRateLimitReset))
+
+	rec = do(http.MethodPost, "/v1/orders/2", "alice")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "2", rec.Header().Get(HeaderRetryAfter))
+	require.Contains(t, rec.Body.String(), "TooManyRequests")
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/v1/orders/1", "bob").Code)
+
+	// GET falls to the default profile limited by ip
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/orders/1", "alice").Code)
+	}
+	rec = do(http.MethodGet, "/v1/orders/1", "bob")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "3", rec.Header().Get(HeaderRateLimitLimit))
+}
+
+func TestLimitByPrincipal(t *testing.T) {
+	keys, err := httpx.NewApiKeyAuthenticator(httpx.ApiKeyConfig{Keys: []httpx.ApiKey{{Key: "k1", Id: "svc-a"}, {Key: "k2", Id: "svc-b"}}})
+	require.Nil(t, err)
+
+	e := echo.New()
+	e.Use(httpx.AuthWithConfig(httpx.AuthConfig{Authenticators: []httpx.Authenticator{keys}}))
+	e.Use(LimitWithConfig(LimitConfig{
+		Profiles:       map[string]Decider{"user": NewQpsLimiter(QpsLimitOpt(EnumLevelUser, 0.001, 1))},
+		DefaultProfile: "user",
+	}))
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	do := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Api-Key", key)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	require.Equal(t, http.StatusOK, do("k1"))
+	require.Equal(t, http.StatusTooManyRequests, do("k1"))
+	require.Equal(t, http.StatusOK, do("k2"))
+}
+
+// like *jwt.Token of github.com/dgrijalva/jwt-go, set by the jwt middleware of echo v3
+type foreignClaims map[string]any
+
+type foreignToken struct {
+	Claims any
+}
+
+func TestClaimsOf(t *testing.T) {
+	require.Equal(t, "u1", claimsOf(&jwt.Token{Claims: jwt.MapClaims{"sub": "u1"}})["sub"])
+	require.Equal(t, "u1", claimsOf(map[string]any{"sub": "u1"})["sub"])
+	require.Equal(t, "u1", claimsOf(&foreignToken{Claims: foreignClaims{"sub": "u1"}})["sub"])
+	require.Nil(t, claimsOf(&foreignToken{}))
+	require.Nil(t, claimsOf("token"))
+	require.Nil(t, claimsOf(nil))
+}
@@ -1,9 +1,8 @@
 package rate
 
 import (
-	"sync"
+	"time"
 
-	"github.com/madlabx/pkgx/log"
 	"golang.org/x/time/rate"
 )
 
@@ -11,8 +10,9 @@ type QpsOption func(*QpsLimiter)
 
 // options of bbr limiter.
 type qpsOptions struct {
-	qps   float64
-	burst int
+	qps    float64
+	burst  int
+	keyMap limiterMapOptions
 }
 
 type LimitLevel int
@@ -24,19 +24,15 @@ const (
 	EnumLevelClientIp
 )
 
-// WithWindow with window size.
-func QpsLimitOpt(level LimitLevel, qps float64, burst int) QpsOption {
+// QpsLimitOpt configures qps and burst of a level.
+// Limiters of keys are bounded by WithMaxKeys and WithIdleTimeout
+func QpsLimitOpt(level LimitLevel, qps float64, burst int, opts ...LimiterMapOption) QpsOption {
 	return func(o *QpsLimiter) {
-		switch level {
-		case EnumLevelGlobal:
-			o.global = &qpsOptions{qps, burst}
-		case EnumLevelUser:
-			o.user = &qpsOptions{qps, burst}
-		case EnumLevelClientId:
-			o.deviceId = &qpsOptions{qps, burst}
-		case EnumLevelClientIp:
-			o.deviceIp = &qpsOptions{qps, burst}
+		qo := &qpsOptions{qps: qps, burst: burst, keyMap: defaultLimiterMapOptions}
+		for _, opt := range opts {
+			opt(&qo.keyMap)
 		}
+		o.levels[level] = qo
 	}
 }
 
@@ -46,130 +42,157 @@ type QpsLimiterItem struct {
 }
 
 type QpsLimiter struct {
-	global   *qpsOptions
-	user     *qpsOptions
-	deviceId *qpsOptions
-	deviceIp *qpsOptions
-
-	globalMutex     sync.Mutex
-	globalLimiter   *rate.Limiter
-	userMutex       sync.Mutex
-	userLimiter     map[string]*rate.Limiter
-	deviceIdMutex   sync.Mutex
-	deviceIdLimiter map[string]*rate.Limiter
-	deviceIpMutex   sync.Mutex
-	deviceIpLimiter map[string]*rate.Limiter
-
-	okMutex sync.RWMutex
-	ok      bool
+	levels   map[LimitLevel]*qpsOptions
+	limiters map[LimitLevel]*limiterMap
 }
 
 // Server ratelimiter middleware
 func NewQpsLimiter(opts ...QpsOption) *QpsLimiter {
 
-	opt := &QpsLimiter{ok: true}
+	opt := &QpsLimiter{
+		levels:   make(map[LimitLevel]*qpsOptions),
+		limiters: make(map[LimitLevel]*limiterMap),
+	}
 	for _, o := range opts {
 		o(opt)
 	}
 
+	for level, o := range opt.levels {
+		opt.limiters[level] = newLimiterMap(o)
+	}
+
 	return opt
 }
 
-func (l *QpsLimiter) OK() bool {
-	ok := l.ok
-	l.ok = true
-	return ok
+// Decision of a request checked by all levels
+type Decision struct {
+	Allowed    bool
+	Level      LimitLevel    // the level rejecting, or the level with the least remaining if allowed
+	Limit      int           // burst of Level, 0 if no level is checked
+	Remaining  int           // tokens left of Level
+	Reset      time.Duration // until tokens of Level are full
+	RetryAfter time.Duration // until the request could be allowed, 0 if allowed or never
 }
-func (l *QpsLimiter) GetOk() bool {
-	l.okMutex.RLock()
-	defer l.okMutex.RUnlock()
-	return l.ok
+
+// Decider decides a request by levels, implemented by QpsLimiter and DistQpsLimiter
+type Decider interface {
+	Decide(user, clientId, clientIp string) Decision
 }
-func (l *QpsLimiter) SetOk(ok bool) {
-	l.okMutex.Lock()
-	defer l.okMutex.Unlock()
-	l.ok = ok
+
+// Decide checks levels from Global to ClientIp and stops at the first level rejecting, safe for concurrent use.
+// A rejected request takes no token of any level. Levels not configured or with empty key are skipped
+func (l *QpsLimiter) Decide(user, clientId, clientIp string) Decision {
+	return l.GlobalAllow().UserAllow(user).ClientIdAllow(clientId).ClientIpAllow(clientIp).Decision()
 }
-func (l *QpsLimiter) GlobalAllow() *QpsLimiter {
-	if !l.GetOk() || l.global == nil {
-		return l
-	}
 
-	l.globalMutex.Lock()
-	if l.globalLimiter == nil {
-		l.globalLimiter = rate.NewLimiter(rate.Limit(l.global.qps), l.global.burst)
-	}
-	l.SetOk(l.globalLimiter.Allow())
+// QpsReservation holds tokens taken by a single call of the chain
+//
+//	r := l.GlobalAllow().UserAllow(user).ClientIpAllow(ip)
+//	if !r.OK() {...}
+//
+// Each chain has its own decision, so a QpsLimiter is safe for concurrent use,
+// while a QpsReservation is not
+type QpsReservation struct {
+	l        *QpsLimiter
+	now      time.Time
+	reserved []*rate.Reservation
+	d        Decision
+}
 
-	log.Errorf("globalLimiter:%#v", l.globalLimiter)
-	return l
+// GlobalAllow starts a chain by checking EnumLevelGlobal
+func (l *QpsLimiter) GlobalAllow() *QpsReservation {
+	return l.reserve().GlobalAllow()
 }
 
-func (l *QpsLimiter) UserAllow(user string) *QpsLimiter {
-	log.Errorf("user:%v", user)
-	if !l.GetOk() || user == "" || l.user == nil {
-		return l
-	}
+func (l *QpsLimiter) UserAllow(user string) *QpsReservation {
+	return l.reserve().UserAllow(user)
+}
 
-	ul, ok := l.userLimiter[user]
-	if ok {
-		log.Errorf("userLimiter:%#v", ul)
-		l.SetOk(ul.Allow())
-	} else {
-		l.userMutex.Lock()
-		if l.userLimiter == nil {
-			l.userLimiter = make(map[string]*rate.Limiter)
-		}
-		ul = rate.NewLimiter(rate.Limit(l.user.qps), l.user.burst)
-		l.userLimiter[user] = ul
-		log.Errorf("userLimiter:%#v", ul)
-		l.userMutex.Unlock()
+func (l *QpsLimiter) ClientIdAllow(clientId string) *QpsReservation {
+	return l.reserve().ClientIdAllow(clientId)
+}
 
-		l.SetOk(l.userLimiter[user].Allow())
-	}
+func (l *QpsLimiter) ClientIpAllow(clientIp string) *QpsReservation {
+	return l.reserve().ClientIpAllow(clientIp)
+}
+
+func (l *QpsLimiter) reserve() *QpsReservation {
+	return &QpsReservation{l: l, now: time.Now(), d: Decision{Allowed: true}}
+}
+
+func (r *QpsReservation) GlobalAllow() *QpsReservation {
+	return r.allow(EnumLevelGlobal, "")
+}
+
+func (r *QpsReservation) UserAllow(user string) *QpsReservation {
+	return r.allow(EnumLevelUser, user)
+}
 
-	return l
+func (r *QpsReservation) ClientIdAllow(clientId string) *QpsReservation {
+	return r.allow(EnumLevelClientId, clientId)
 }
 
-func (l *QpsLimiter) ClientIdAllow(deviceId string) *QpsLimiter {
-	if !l.GetOk() || deviceId == "" || l.deviceId == nil {
-		return l
+func (r *QpsReservation) ClientIpAllow(clientIp string) *QpsReservation {
+	return r.allow(EnumLevelClientIp, clientIp)
+}
+
+// allow does nothing once rejected, tokens taken by previous levels are given back on rejection
+func (r *QpsReservation) allow(level LimitLevel, key string) *QpsReservation {
+	lm, ok := r.l.limiters[level]
+	if !r.d.Allowed || !ok || (key == "" && level != EnumLevelGlobal) {
+		return r
 	}
 
-	dl, ok := l.deviceIdLimiter[deviceId]
-	if ok {
-		l.SetOk(dl.Allow())
-	} else {
-		l.deviceIdMutex.Lock()
-		if l.deviceIdLimiter == nil {
-			l.deviceIdLimiter = make(map[string]*rate.Limiter)
-		}
-		l.deviceIdLimiter[deviceId] = rate.NewLimiter(rate.Limit(l.deviceId.qps), l.deviceId.burst)
-		l.deviceIdMutex.Unlock()
+	lim := lm.get(key)
+	res := lim.ReserveN(r.now, 1)
+	if !res.OK() {
+		r.Cancel()
+		r.d = Decision{Level: level, Limit: lm.o.burst}
+		return r
+	}
+	if delay := res.DelayFrom(r.now); delay > 0 {
+		res.CancelAt(r.now)
+		r.Cancel()
+		r.d = Decision{Level: level, Limit: lm.o.burst, Reset: lm.o.fullAfter(lim.TokensAt(r.now)), RetryAfter: delay}
+		return r
+	}
+	r.reserved = append(r.reserved, res)
 
-		l.SetOk(l.deviceIdLimiter[deviceId].Allow())
+	tokens := lim.TokensAt(r.now)
+	if r.d.Limit == 0 || int(tokens) < r.d.Remaining {
+		r.d = Decision{Allowed: true, Level: level, Limit: lm.o.burst, Remaining: int(tokens), Reset: lm.o.fullAfter(tokens)}
 	}
+	return r
+}
+
+func (r *QpsReservation) OK() bool {
+	return r.d.Allowed
+}
 
-	return l
+func (r *QpsReservation) Decision() Decision {
+	return r.d
 }
 
-func (l *QpsLimiter) ClientIpAllow(deviceIp string) *QpsLimiter {
-	if !l.GetOk() || deviceIp == "" || l.deviceIp == nil {
-		return l
+// Cancel gives back tokens taken, e.g. the request is rejected by checks after the limiter
+func (r *QpsReservation) Cancel() {
+	for _, res := range r.reserved {
+		res.CancelAt(r.now)
 	}
-	dl, ok := l.deviceIpLimiter[deviceIp]
-	if ok {
-		l.SetOk(dl.Allow())
-	} else {
-		l.deviceIpMutex.Lock()
-		if l.deviceIpLimiter == nil {
-			l.deviceIpLimiter = make(map[string]*rate.Limiter)
-		}
-		l.deviceIpLimiter[deviceIp] = rate.NewLimiter(rate.Limit(l.deviceIp.qps), l.deviceIp.burst)
-		l.deviceIpMutex.Unlock()
+	r.reserved = nil
+}
 
-		l.SetOk(l.deviceIpLimiter[deviceIp].Allow())
+func (o *qpsOptions) fullAfter(tokens float64) time.Duration {
+	if o.qps <= 0 || tokens >= float64(o.burst) {
+		return 0
 	}
+	return time.Duration((float64(o.burst) - tokens) / o.qps * float64(time.Second))
+}
 
-	return l
+// Stats of limiters of keys by level
+func (l *QpsLimiter) Stats() map[LimitLevel]LimiterStats {
+	stats := make(map[LimitLevel]LimiterStats, len(l.limiters))
+	for level, lm := range l.limiters {
+		stats[level] = lm.stats()
+	}
+	return stats
 }
@@ -0,0 +1,61 @@
+package rate
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQpsReservationChain(t *testing.T) {
+	l := NewQpsLimiter(QpsLimitOpt(EnumLevelGlobal, 0.001, 3), QpsLimitOpt(EnumLevelClientIp, 0.001, 1))
+
+	r := l.GlobalAllow().UserAllow("u1").ClientIdAllow("").ClientIpAllow("ip1")
+	require.True(t, r.OK())
+	require.Equal(t, EnumLevelClientIp, r.Decision().Level)
+	require.Equal(t, 0, r.Decision().Remaining)
+
+	// rejected by ip, the global token is given back
+	r = l.GlobalAllow().ClientIpAllow("ip1")
+	require.False(t, r.OK())
+	d := r.Decision()
+	require.Equal(t, EnumLevelClientIp, d.Level)
+	require.Greater(t, d.RetryAfter, 10*time.Minute)
+
+	// a request rejected later gives back its tokens by Cancel
+	r = l.GlobalAllow().ClientIpAllow("ip2")
+	require.True(t, r.OK())
+	r.Cancel()
+	require.True(t, l.ClientIpAllow("ip2").OK())
+
+	// global: 3 tokens, 1 taken by ip1, the token of ip2 is given back
+	r = l.GlobalAllow()
+	require.True(t, r.OK())
+	require.Equal(t, 1, r.Decision().Remaining)
+	require.True(t, l.GlobalAllow().OK())
+	require.False(t, l.GlobalAllow().OK())
+}
+
+func TestQpsReservationConcurrent(t *testing.T) {
+	l := NewQpsLimiter(QpsLimitOpt(EnumLevelUser, 0.001, 5))
+
+	var (
+		wg      sync.WaitGroup
+		allowed atomic.Int64
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// rejections of others never leak into this decision
+			if l.GlobalAllow().UserAllow("u1").OK() {
+				allowed.Add(1)
+			}
+			require.True(t, l.GlobalAllow().UserAllow("").OK())
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int64(5), allowed.Load())
+}
@@ -1,17 +1,23 @@
 package rate
 
 import (
+	"container/list"
+	"context"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/log"
 )
 
 type TpsOption func(*TpsLimiter)
 
 // options of bbr limiter.
 type tpsOptions struct {
-	tag   string
-	paral int
+	tag            string
+	paral          int
+	acquireTimeout time.Duration
 }
 
 // WithWindow with window size.
@@ -27,34 +33,234 @@ func WithParal(paral int) TpsOption {
 	}
 }
 
+// WithAcquireTimeout Acquire fails after waiting for timeout, 0 means waiting until ctx is done
+func WithAcquireTimeout(timeout time.Duration) TpsOption {
+	return func(o *TpsLimiter) {
+		o.acquireTimeout = timeout
+	}
+}
+
+// TpsLimiter is a weighted semaphore named by tag, waiters are served in FIFO order
 type TpsLimiter struct {
 	tpsOptions
-	sem semaphore.Semaphore
+
+	mutex   sync.Mutex
+	inUse   int
+	waiters list.List // of *tpsWaiter
+
+	// metrics
+	acquired  uint64
+	rejected  uint64
+	waitTotal time.Duration
+	waitMax   time.Duration
 }
 
+type tpsWaiter struct {
+	n     int
+	ready chan struct{} // closed once permits are granted, or err is set
+	err   error
+}
+
+// TpsStats metrics of a TpsLimiter
+type TpsStats struct {
+	Tag       string
+	Paral     int
+	InUse     int
+	Waiting   int
+	Acquired  uint64        // times of successful acquire
+	Rejected  uint64        // times of failed TryAcquire and canceled Acquire
+	WaitTotal time.Duration // total time waited by successful Acquire
+	WaitMax   time.Duration
+}
+
+// TryAcquire acquires n permits without waiting, fails if others are waiting
 func (tl *TpsLimiter) TryAcquire(n int) bool {
-	return tl.sem.TryAcquire(n)
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+
+	if tl.waiters.Len() == 0 && tl.inUse+n <= tl.paral {
+		tl.inUse += n
+		tl.acquired++
+		return true
+	}
+
+	tl.rejected++
+	return false
+}
+
+// Acquire waits for n permits until ctx is done or the acquire timeout.
+// Fails with ErrExceedParal at once if n is larger than paral, which would never be granted
+func (tl *TpsLimiter) Acquire(ctx context.Context, n int) error {
+	tl.mutex.Lock()
+	if n > tl.paral {
+		tl.rejected++
+		paral := tl.paral
+		tl.mutex.Unlock()
+		return errors.Wrapf(ErrExceedParal, "n:%v, paral:%v, tag:%v", n, paral, tl.tag)
+	}
+	if tl.waiters.Len() == 0 && tl.inUse+n <= tl.paral {
+		tl.inUse += n
+		tl.acquired++
+		tl.mutex.Unlock()
+		return nil
+	}
+
+	w := &tpsWaiter{n: n, ready: make(chan struct{})}
+	elem := tl.waiters.PushBack(w)
+	tl.mutex.Unlock()
+
+	if tl.acquireTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tl.acquireTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	select {
+	case <-w.ready:
+		tl.mutex.Lock()
+		defer tl.mutex.Unlock()
+		if w.err != nil {
+			tl.rejected++
+			return w.err
+		}
+		tl.recordWait(time.Since(start))
+		return nil
+
+	case <-ctx.Done():
+		tl.mutex.Lock()
+		select {
+		case <-w.ready:
+			if w.err != nil {
+				tl.rejected++
+				tl.mutex.Unlock()
+				return w.err
+			}
+			// granted while canceling, give back
+			tl.inUse -= n
+		default:
+			tl.waiters.Remove(elem)
+		}
+		tl.rejected++
+		// waiters behind this one may fit now
+		tl.notifyWaiters()
+		tl.mutex.Unlock()
+		return errors.Wrap(ctx.Err())
+	}
+}
+
+// recordWait must be called with mutex held
+func (tl *TpsLimiter) recordWait(d time.Duration) {
+	tl.acquired++
+	tl.waitTotal += d
+	tl.waitMax = max(tl.waitMax, d)
+}
+
+// notifyWaiters grants permits to waiters in FIFO order, must be called with mutex held
+func (tl *TpsLimiter) notifyWaiters() {
+	for {
+		front := tl.waiters.Front()
+		if front == nil {
+			return
+		}
+
+		w := front.Value.(*tpsWaiter)
+		if tl.inUse+w.n > tl.paral {
+			// keep FIFO, later waiters do not overtake
+			return
+		}
+
+		tl.inUse += w.n
+		tl.waiters.Remove(front)
+		close(w.ready)
+	}
 }
 
+// Release returns the count of permits still acquired, permits released more than held are ignored
 func (tl *TpsLimiter) Release(n int) int {
-	return tl.sem.Release(n)
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+
+	tl.inUse -= n
+	if tl.inUse < 0 {
+		log.Errorf("TpsLimiter released more than held, tag:%v, n:%v, inUse:%v", tl.tag, n, tl.inUse+n)
+		tl.inUse = 0
+	}
+	tl.notifyWaiters()
+	return tl.inUse
+}
+
+// Do runs fn with one permit, the permit is released even if fn panics
+func (tl *TpsLimiter) Do(ctx context.Context, fn func() error) error {
+	if err := tl.Acquire(ctx, 1); err != nil {
+		return err
+	}
+	defer tl.Release(1)
+
+	return fn()
+}
+
+// Resize changes paral at runtime, permits already acquired are kept even if exceeding the new paral.
+// Waiters of more than the new paral fail with ErrExceedParal, they would block all waiters behind otherwise
+func (tl *TpsLimiter) Resize(paral int) {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+
+	tl.paral = paral
+	for elem := tl.waiters.Front(); elem != nil; {
+		next := elem.Next()
+		if w := elem.Value.(*tpsWaiter); w.n > paral {
+			w.err = errors.Wrapf(ErrExceedParal, "n:%v, paral:%v, tag:%v", w.n, paral, tl.tag)
+			tl.waiters.Remove(elem)
+			close(w.ready)
+		}
+		elem = next
+	}
+	tl.notifyWaiters()
+}
+
+func (tl *TpsLimiter) Stats() TpsStats {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+
+	return TpsStats{
+		Tag:       tl.tag,
+		Paral:     tl.paral,
+		InUse:     tl.inUse,
+		Waiting:   tl.waiters.Len(),
+		Acquired:  tl.acquired,
+		Rejected:  tl.rejected,
+		WaitTotal: tl.waitTotal,
+		WaitMax:   tl.waitMax,
+	}
 }
 
 var tpsMap sync.Map
 
+// GetTpsLimiter returns the limiter of the tag, created by opts at the first call
 func GetTpsLimiter(opts ...TpsOption) (*TpsLimiter, error) {
 
-	opt := TpsLimiter{}
+	opt := &TpsLimiter{}
 	for _, o := range opts {
-		o(&opt)
+		o(opt)
 	}
 
 	if opt.tag == "" {
 		return nil, errors.New("tag is empty")
 	}
 
-	opt.sem = semaphore.New(opt.paral)
-
-	tl, _ := tpsMap.LoadOrStore(opt.tag, &opt)
+	tl, _ := tpsMap.LoadOrStore(opt.tag, opt)
 	return tl.(*TpsLimiter), nil
 }
+
+// ListTpsStats stats of all TpsLimiters, sorted by tag
+func ListTpsStats() []TpsStats {
+	var stats []TpsStats
+	tpsMap.Range(func(_, v any) bool {
+		stats = append(stats, v.(*TpsLimiter).Stats())
+		return true
+	})
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Tag < stats[j].Tag })
+	return stats
+}
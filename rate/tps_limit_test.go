@@ -1,9 +1,15 @@
 package rate
 
 import (
+	"context"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/madlabx/pkgx/errors"
+	"github.com/stretchr/testify/require"
+
 	"github.com/madlabx/pkgx/errcode"
 	"github.com/madlabx/pkgx/log"
 )
@@ -94,3 +100,123 @@ func TestStack(t *testing.T) {
 
 	time.Sleep(3 * time.Second)
 }
+
+func TestTpsLimiterAcquireFifo(t *testing.T) {
+	tl, err := GetTpsLimiter(WithTag("fifo"), WithParal(2))
+	require.Nil(t, err)
+	require.True(t, tl.TryAcquire(2))
+
+	// a large waiter blocks the smaller ones behind it
+	order := make(chan int, 3)
+	var wg sync.WaitGroup
+	for i, n := range []int{2, 1, 1} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.Nil(t, tl.Acquire(context.Background(), n))
+			order <- i
+		}()
+		require.Eventually(t, func() bool { return tl.Stats().Waiting == i+1 }, time.Second, time.Millisecond)
+	}
+	require.False(t, tl.TryAcquire(1))
+
+	require.Equal(t, 1, tl.Release(1))
+	require.Equal(t, 3, tl.Stats().Waiting)
+	// granted to the first waiter at once
+	require.Equal(t, 2, tl.Release(1))
+	require.Equal(t, 0, <-order)
+
+	require.Equal(t, 2, tl.Release(2))
+	wg.Wait()
+	require.ElementsMatch(t, []int{1, 2}, []int{<-order, <-order})
+
+	st := tl.Stats()
+	require.Equal(t, 2, st.InUse)
+	require.Equal(t, uint64(4), st.Acquired)
+	require.Equal(t, uint64(1), st.Rejected)
+	require.Greater(t, st.WaitMax, time.Duration(0))
+	tl.Release(2)
+}
+
+func TestTpsLimiterTimeoutAndResize(t *testing.T) {
+	errBusy := errors.New("busy")
+	tl, err := GetTpsLimiter(WithTag("resize"), WithParal(1), WithAcquireTimeout(20*time.Millisecond))
+	require.Nil(t, err)
+	require.Nil(t, tl.Acquire(context.Background(), 1))
+
+	err = tl.Acquire(context.Background(), 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, 0, tl.Stats().Waiting)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, tl.Do(ctx, func() error { return nil }), context.Canceled)
+
+	// more permits for waiters
+	done := make(chan error)
+	go func() {
+		done <- tl.Do(context.Background(), func() error { return errBusy })
+	}()
+	require.Eventually(t, func() bool { return tl.Stats().Waiting == 1 }, time.Second, time.Millisecond)
+	tl.Resize(2)
+	require.Equal(t, errBusy, <-done)
+	require.Equal(t, 1, tl.Stats().InUse)
+
+	// released even if fn panics
+	require.Panics(t, func() {
+		_ = tl.Do(context.Background(), func() error { panic("boom") })
+	})
+	require.Equal(t, 1, tl.Stats().InUse)
+	require.Equal(t, 0, tl.Release(1))
+}
+
+func TestTpsLimiterExceedParalAndOverRelease(t *testing.T) {
+	tl, err := GetTpsLimiter(WithTag("exceed"), WithParal(2))
+	require.Nil(t, err)
+
+	// fails at once even without timeout
+	require.ErrorIs(t, tl.Acquire(context.Background(), 3), ErrExceedParal)
+	require.Equal(t, 0, tl.Stats().Waiting)
+	require.Equal(t, uint64(1), tl.Stats().Rejected)
+
+	require.Nil(t, tl.Acquire(context.Background(), 1))
+	require.NotPanics(t, func() { require.Equal(t, 0, tl.Release(2)) })
+	require.True(t, tl.TryAcquire(2))
+	require.Equal(t, 0, tl.Release(2))
+}
+
+func TestTpsLimiterResizeFailsLargeWaiters(t *testing.T) {
+	tl, err := GetTpsLimiter(WithTag("shrink"), WithParal(4))
+	require.Nil(t, err)
+	require.True(t, tl.TryAcquire(4))
+
+	large, small := make(chan error), make(chan error)
+	go func() { large <- tl.Acquire(context.Background(), 3) }()
+	require.Eventually(t, func() bool { return tl.Stats().Waiting == 1 }, time.Second, time.Millisecond)
+	go func() { small <- tl.Acquire(context.Background(), 1) }()
+	require.Eventually(t, func() bool { return tl.Stats().Waiting == 2 }, time.Second, time.Millisecond)
+
+	tl.Resize(2)
+	require.ErrorIs(t, <-large, ErrExceedParal)
+	require.Equal(t, 1, tl.Stats().Waiting)
+
+	// granted to the small waiter at once
+	require.Equal(t, 2, tl.Release(3))
+	require.Nil(t, <-small)
+	require.Equal(t, 0, tl.Release(2))
+}
+
+func TestListTpsStats(t *testing.T) {
+	_, err := GetTpsLimiter(WithTag("list_b"), WithParal(3))
+	require.Nil(t, err)
+	_, err = GetTpsLimiter(WithTag("list_a"), WithParal(1))
+	require.Nil(t, err)
+
+	var tags []string
+	for _, st := range ListTpsStats() {
+		if strings.HasPrefix(st.Tag, "list_") {
+			tags = append(tags, st.Tag)
+		}
+	}
+	require.Equal(t, []string{"list_a", "list_b"}, tags)
+}
@@ -0,0 +1,158 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"time"
+
+	"github.com/madlabx/pkgx/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ConstModeStandalone = "standalone"
+	ConstModeSentinel   = "sentinel"
+	ConstModeCluster    = "cluster"
+)
+
+type Config struct {
+	Mode string `vx_default:"standalone" vx_range:"oneof=standalone sentinel cluster"`
+	// Addr is used in standalone mode, or as the only node if Addrs is empty
+	Addr string `vx_default:"127.0.0.1:6379"`
+	// Addrs are sentinel addresses in sentinel mode, or seed nodes in cluster mode
+	Addrs            []string
+	MasterName       string // required in sentinel mode
+	Username         string `json:"-"`
+	Password         string `json:"-"`
+	SentinelUsername string `json:"-"`
+	SentinelPassword string `json:"-"`
+	DB               int    `vx_default:"0"` // ignored in cluster mode
+	TLS              TLSConfig
+	Pool             PoolConfig
+}
+
+type TLSConfig struct {
+	Enable             bool `vx_default:"false"`
+	CaFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool `vx_default:"false"`
+}
+
+// PoolConfig zero value means the default of go-redis
+type PoolConfig struct {
+	PoolSize        int `vx_default:"0"` // 0 means 10 connections per cpu
+	MinIdleConns    int `vx_default:"0"`
+	MaxIdleConns    int `vx_default:"0"`
+	MaxRetries      int `vx_default:"3"`
+	PoolTimeout     int `vx_default:"4000"` //in ms
+	DialTimeout     int `vx_default:"5000"` //in ms
+	ReadTimeout     int `vx_default:"3000"` //in ms
+	WriteTimeout    int `vx_default:"3000"` //in ms
+	ConnMaxIdleTime int `vx_default:"1800"` //in sec
+}
+
+func (tc TLSConfig) ToTlsConfig() (*tls.Config, error) {
+	if !tc.Enable {
+		return nil, nil
+	}
+
+	conf := &tls.Config{
+		ServerName:         tc.ServerName,
+		InsecureSkipVerify: tc.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if tc.CaFile != "" {
+		ca, err := os.ReadFile(tc.CaFile)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("no valid certificate in CaFile:%v", tc.CaFile)
+		}
+	}
+
+	if tc.CertFile != "" || tc.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+}
+
+func msToDuration(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+func (c Config) ToUniversalOptions() (*redis.UniversalOptions, error) {
+	tlsConf, err := c.TLS.ToTlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := c.Addrs
+	if len(addrs) == 0 && c.Addr != "" {
+		addrs = []string{c.Addr}
+	}
+
+	switch c.Mode {
+	default:
+		return nil, errors.Errorf("wrong redis mode:[%v]", c.Mode)
+	case "", ConstModeStandalone:
+		if len(addrs) > 1 {
+			return nil, errors.Errorf("only one addr is allowed in standalone mode, addrs:%v", addrs)
+		}
+	case ConstModeSentinel:
+		if c.MasterName == "" {
+			return nil, errors.New("MasterName is required in sentinel mode")
+		}
+	case ConstModeCluster:
+		if c.DB != 0 {
+			return nil, errors.Errorf("DB should be 0 in cluster mode, DB:%v", c.DB)
+		}
+	}
+
+	return &redis.UniversalOptions{
+		Addrs:            addrs,
+		MasterName:       c.MasterName,
+		Username:         c.Username,
+		Password:         c.Password,
+		SentinelUsername: c.SentinelUsername,
+		SentinelPassword: c.SentinelPassword,
+		DB:               c.DB,
+		TLSConfig:        tlsConf,
+		PoolSize:         c.Pool.PoolSize,
+		MinIdleConns:     c.Pool.MinIdleConns,
+		MaxIdleConns:     c.Pool.MaxIdleConns,
+		MaxRetries:       c.Pool.MaxRetries,
+		PoolTimeout:      msToDuration(c.Pool.PoolTimeout),
+		DialTimeout:      msToDuration(c.Pool.DialTimeout),
+		ReadTimeout:      msToDuration(c.Pool.ReadTimeout),
+		WriteTimeout:     msToDuration(c.Pool.WriteTimeout),
+		ConnMaxIdleTime:  time.Duration(c.Pool.ConnMaxIdleTime) * time.Second,
+	}, nil
+}
+
+// NewUniversalClient creates the client matching c.Mode, instead of guessing from Addrs like redis.NewUniversalClient
+func (c Config) NewUniversalClient() (redis.UniversalClient, error) {
+	opts, err := c.ToUniversalOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.Mode {
+	case ConstModeSentinel:
+		return redis.NewFailoverClient(opts.Failover()), nil
+	case ConstModeCluster:
+		return redis.NewClusterClient(opts.Cluster()), nil
+	default:
+		return redis.NewClient(opts.Simple()), nil
+	}
+}
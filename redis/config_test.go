@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigToUniversalOptions(t *testing.T) {
+	opts, err := Config{
+		Addr: "127.0.0.1:6379",
+		DB:   2,
+		Pool: PoolConfig{PoolSize: 20, ReadTimeout: 1500, ConnMaxIdleTime: 60},
+	}.ToUniversalOptions()
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6379"}, opts.Addrs)
+	require.Equal(t, 2, opts.DB)
+	require.Equal(t, 20, opts.PoolSize)
+	require.Equal(t, 1500*time.Millisecond, opts.ReadTimeout)
+	require.Equal(t, time.Minute, opts.ConnMaxIdleTime)
+	require.Nil(t, opts.TLSConfig)
+
+	_, err = Config{Mode: ConstModeStandalone, Addrs: []string{"a:1", "b:2"}}.ToUniversalOptions()
+	require.NotNil(t, err)
+
+	_, err = Config{Mode: ConstModeSentinel, Addrs: []string{"a:26379"}}.ToUniversalOptions()
+	require.NotNil(t, err)
+
+	_, err = Config{Mode: ConstModeCluster, Addrs: []string{"a:1", "b:2"}, DB: 1}.ToUniversalOptions()
+	require.NotNil(t, err)
+
+	_, err = Config{Mode: "unknown"}.ToUniversalOptions()
+	require.NotNil(t, err)
+
+	_, err = Config{TLS: TLSConfig{Enable: true, CaFile: "/not/exist/ca.pem"}}.ToUniversalOptions()
+	require.NotNil(t, err)
+
+	opts, err = Config{TLS: TLSConfig{Enable: true, ServerName: "redis.local"}}.ToUniversalOptions()
+	require.Nil(t, err)
+	require.Equal(t, "redis.local", opts.TLSConfig.ServerName)
+}
+
+func TestNewUniversalClientByMode(t *testing.T) {
+	for _, conf := range []Config{
+		{Mode: ConstModeStandalone, Addr: "127.0.0.1:6379"},
+		{Mode: ConstModeSentinel, Addrs: []string{"127.0.0.1:26379"}, MasterName: "mymaster"},
+		{Mode: ConstModeCluster, Addrs: []string{"127.0.0.1:7000", "127.0.0.1:7001"}},
+	} {
+		c, err := conf.NewUniversalClient()
+		require.Nil(t, err)
+		require.Nil(t, c.Close())
+	}
+}
+
+func TestNewClientWithDb(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	rc, err := NewClient(context.Background(), Config{Addr: mr.Addr(), DB: 3})
+	require.Nil(t, err)
+	require.Nil(t, rc.Set(&RefreshTokenMock{IKey: "key1"}, 100))
+
+	mr.Select(3)
+	require.True(t, mr.Exists("refresh_token_mock_key1"))
+	mr.Select(0)
+	require.False(t, mr.Exists("refresh_token_mock_key1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = NewClient(ctx, Config{Addr: mr.Addr()})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,11 @@
+package redis
+
+import (
+	"errors"
+)
+
+var (
+	ErrLockNotObtained = errors.New("lock not obtained")
+	ErrLockNotHeld     = errors.New("lock not held")
+	ErrJobAbandoned    = errors.New("job not acked within ClaimIdle, its worker may have crashed")
+)
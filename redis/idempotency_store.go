@@ -0,0 +1,45 @@
+package redis
+
+import (
+	"time"
+
+	"github.com/madlabx/pkgx/cachestore"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ cachestore.IdempotencyStore = (*idempotencyStore)(nil)
+
+type idempotencyStore struct {
+	rc *Client
+}
+
+// NewIdempotencyStore shares idempotency entries of httpx.IdempotencyWithConfig among replicas
+func NewIdempotencyStore(rc *Client) cachestore.IdempotencyStore {
+	return &idempotencyStore{rc: rc}
+}
+
+func idempotencyKey(key string) string {
+	return "idem_" + key
+}
+
+func (s *idempotencyStore) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	ok, err := s.rc.rc.SetNX(s.rc.ctx, idempotencyKey(key), value, ttl).Result()
+	return ok, errors.Wrap(err)
+}
+
+func (s *idempotencyStore) Get(key string) ([]byte, error) {
+	value, err := s.rc.rc.Get(s.rc.ctx, idempotencyKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return value, errors.Wrap(err)
+}
+
+func (s *idempotencyStore) Set(key string, value []byte, ttl time.Duration) error {
+	return errors.Wrap(s.rc.rc.Set(s.rc.ctx, idempotencyKey(key), value, ttl).Err())
+}
+
+func (s *idempotencyStore) Delete(key string) error {
+	return errors.Wrap(s.rc.rc.Del(s.rc.ctx, idempotencyKey(key)).Err())
+}
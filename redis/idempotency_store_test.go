@@ -0,0 +1,37 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := NewIdempotencyStore(newMiniRedisClient(t, mr))
+
+	ok, err := s.SetNX("k1", []byte("pending"), time.Minute)
+	require.Nil(t, err)
+	require.True(t, ok)
+	ok, err = s.SetNX("k1", []byte("other"), time.Minute)
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	require.Nil(t, s.Set("k1", []byte("done"), time.Minute))
+	v, err := s.Get("k1")
+	require.Nil(t, err)
+	require.Equal(t, "done", string(v))
+
+	mr.FastForward(2 * time.Minute)
+	v, err = s.Get("k1")
+	require.Nil(t, err)
+	require.Nil(t, v)
+
+	require.Nil(t, s.Set("k2", []byte("done"), time.Minute))
+	require.Nil(t, s.Delete("k2"))
+	v, err = s.Get("k2")
+	require.Nil(t, err)
+	require.Nil(t, v)
+}
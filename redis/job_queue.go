@@ -0,0 +1,266 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+type JobQueueConf struct {
+	Name         string
+	Group        string `vx_default:"workers"`
+	Workers      int    `vx_default:"4"`
+	MaxAttempts  int    `vx_default:"5"`      // moved to the dead-letter stream after MaxAttempts failures
+	BackoffBase  int    `vx_default:"1000"`   //in ms, delay before the first retry, doubled for each further retry
+	BackoffMax   int    `vx_default:"300000"` //in ms
+	PollInterval int    `vx_default:"1000"`   //in ms, block time of reading and interval of promoting delayed jobs
+	ClaimIdle    int    `vx_default:"60000"`  //in ms, jobs delivered to a consumer but not acked for so long are claimed by others, 0 to disable
+	DeadMaxLen   int64  `vx_default:"10000"`  // approximate max length of the dead-letter stream, 0 means unlimited
+}
+
+// Job is a payload of type T delivered to JobHandler
+type Job[T any] struct {
+	Id         string
+	Attempt    int // starts from 1
+	EnqueuedAt time.Time
+	LastError  string // error of the previous attempt, or why a dead letter can not be decoded
+	Payload    T
+	// Raw values of a dead letter which can not be decoded, remove it by DeleteDead
+	Raw map[string]any
+
+	entryId string
+}
+
+// JobHandler processes a job, the job is retried with backoff if an error is returned
+type JobHandler[T any] func(ctx context.Context, job *Job[T]) error
+
+// JobQueue 基于redis stream的任务队列.
+// 任务由消费组内的worker处理, 失败后按指数退避放入延迟队列(sorted set)重试,
+// 超过MaxAttempts后移入死信stream. 每个队列只有一个消费组, 任务完成后即从stream删除.
+// 所有key使用同一hash tag, 以支持cluster模式
+type JobQueue[T any] struct {
+	rc   *Client
+	conf JobQueueConf
+}
+
+// jobEnvelope is stored in the "job" field of stream entries and as members of the delayed set
+type jobEnvelope struct {
+	Id         string          `json:"id"`
+	Attempt    int             `json:"attempt"`
+	EnqueuedAt int64           `json:"enqueued_at"` //in ms
+	LastError  string          `json:"last_error,omitempty"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+const jobField = "job"
+
+// KEYS[1]: delayed set, KEYS[2]: stream, ARGV[1]: now in ms, ARGV[2]: max jobs to promote
+var promoteScript = redis.NewScript(`
+local jobs = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, job in ipairs(jobs) do
+	redis.call("XADD", KEYS[2], "*", "job", job)
+	redis.call("ZREM", KEYS[1], job)
+end
+return #jobs
+`)
+
+func NewJobQueue[T any](rc *Client, conf JobQueueConf) (*JobQueue[T], error) {
+	if conf.Name == "" || conf.Group == "" {
+		return nil, errors.Errorf("Name and Group are required, name:%v, group:%v", conf.Name, conf.Group)
+	}
+	if conf.Workers <= 0 || conf.MaxAttempts <= 0 || conf.PollInterval <= 0 || conf.BackoffBase < 0 || conf.BackoffMax < conf.BackoffBase {
+		return nil, errors.Errorf("invalid job queue conf:%+v", conf)
+	}
+
+	return &JobQueue[T]{rc: rc, conf: conf}, nil
+}
+
+func (q *JobQueue[T]) streamKey() string {
+	return "jobq_{" + q.conf.Name + "}"
+}
+
+func (q *JobQueue[T]) delayedKey() string {
+	return "jobq_delayed_{" + q.conf.Name + "}"
+}
+
+func (q *JobQueue[T]) deadKey() string {
+	return "jobq_dead_{" + q.conf.Name + "}"
+}
+
+// Enqueue adds a job to be processed as soon as possible, returns the job id
+func (q *JobQueue[T]) Enqueue(payload T) (string, error) {
+	env, err := newJobEnvelope(payload)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	err = q.rc.rc.XAdd(q.rc.ctx, &redis.XAddArgs{
+		Stream: q.streamKey(),
+		Values: []any{jobField, string(data)},
+	}).Err()
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	return env.Id, nil
+}
+
+// EnqueueAt adds a job to be processed not earlier than at
+func (q *JobQueue[T]) EnqueueAt(payload T, at time.Time) (string, error) {
+	env, err := newJobEnvelope(payload)
+	if err != nil {
+		return "", err
+	}
+
+	if err = q.schedule(q.rc.rc, env, at); err != nil {
+		return "", err
+	}
+
+	return env.Id, nil
+}
+
+// EnqueueIn adds a job to be processed after delay
+func (q *JobQueue[T]) EnqueueIn(payload T, delay time.Duration) (string, error) {
+	return q.EnqueueAt(payload, time.Now().Add(delay))
+}
+
+// DelayedLen number of jobs waiting in the delayed set, including retries
+func (q *JobQueue[T]) DelayedLen() (int64, error) {
+	n, err := q.rc.rc.ZCard(q.rc.ctx, q.delayedKey()).Result()
+	return n, errors.Wrap(err)
+}
+
+// DeadLetters returns at most count oldest jobs in the dead-letter stream,
+// Attempt of them is the number of failed attempts. Entries which can not be decoded
+// are returned with Raw set
+func (q *JobQueue[T]) DeadLetters(count int64) ([]*Job[T], error) {
+	msgs, err := q.rc.rc.XRangeN(q.rc.ctx, q.deadKey(), "-", "+", count).Result()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	jobs := make([]*Job[T], 0, len(msgs))
+	for _, msg := range msgs {
+		job, env, err := decodeJob[T](msg)
+		if err != nil {
+			job = &Job[T]{LastError: err.Error(), Raw: msg.Values, entryId: msg.ID}
+			if env != nil {
+				job.Id = env.Id
+			}
+		} else {
+			job.Attempt = env.Attempt
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// DeleteDead removes a job returned by DeadLetters from the dead-letter stream
+func (q *JobQueue[T]) DeleteDead(job *Job[T]) error {
+	return errors.Wrap(q.rc.rc.XDel(q.rc.ctx, q.deadKey(), job.entryId).Err())
+}
+
+// RetryDead moves a job from the dead-letter stream back to the queue with attempts reset
+func (q *JobQueue[T]) RetryDead(job *Job[T]) error {
+	if job.Raw != nil {
+		return errors.Errorf("undecodable job can not be retried, entry:%v", job.entryId)
+	}
+
+	msgs, err := q.rc.rc.XRangeN(q.rc.ctx, q.deadKey(), job.entryId, job.entryId, 1).Result()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	if len(msgs) == 0 {
+		return errors.Errorf("job not found in dead-letter stream, id:%v", job.Id)
+	}
+
+	env, err := decodeJobEnvelope(msgs[0])
+	if err != nil {
+		return err
+	}
+	env.Attempt = 0
+
+	_, err = q.rc.rc.TxPipelined(q.rc.ctx, func(pipe redis.Pipeliner) error {
+		if err := q.schedule(pipe, env, time.Now()); err != nil {
+			return err
+		}
+		pipe.XDel(q.rc.ctx, q.deadKey(), job.entryId)
+		return nil
+	})
+	return errors.Wrap(err)
+}
+
+func (q *JobQueue[T]) schedule(cmd redis.Cmdable, env *jobEnvelope, at time.Time) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return errors.Wrap(cmd.ZAdd(q.rc.ctx, q.delayedKey(), redis.Z{Score: float64(at.UnixMilli()), Member: string(data)}).Err())
+}
+
+// backoff returns the delay before the next attempt after attempt failed
+func (q *JobQueue[T]) backoff(attempt int) time.Duration {
+	delay := msToDuration(q.conf.BackoffBase)
+	for i := 1; i < attempt && delay < msToDuration(q.conf.BackoffMax); i++ {
+		delay *= 2
+	}
+	return min(delay, msToDuration(q.conf.BackoffMax))
+}
+
+func newJobEnvelope(payload any) (*jobEnvelope, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return &jobEnvelope{
+		Id:         uuid.New().String(),
+		EnqueuedAt: time.Now().UnixMilli(),
+		Payload:    data,
+	}, nil
+}
+
+func decodeJobEnvelope(msg redis.XMessage) (*jobEnvelope, error) {
+	data, ok := msg.Values[jobField].(string)
+	if !ok {
+		return nil, errors.Errorf("no job in stream entry:%v", msg.ID)
+	}
+
+	env := &jobEnvelope{}
+	if err := json.Unmarshal([]byte(data), env); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return env, nil
+}
+
+func decodeJob[T any](msg redis.XMessage) (*Job[T], *jobEnvelope, error) {
+	env, err := decodeJobEnvelope(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	job := &Job[T]{
+		Id:         env.Id,
+		Attempt:    env.Attempt + 1,
+		EnqueuedAt: time.UnixMilli(env.EnqueuedAt),
+		LastError:  env.LastError,
+		entryId:    msg.ID,
+	}
+	if err = json.Unmarshal(env.Payload, &job.Payload); err != nil {
+		return nil, env, errors.Wrap(err)
+	}
+
+	return job, env, nil
+}
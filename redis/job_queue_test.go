@@ -0,0 +1,317 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+type emailJob struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+}
+
+func newTestJobQueue(t *testing.T, rc *Client) *JobQueue[emailJob] {
+	q, err := NewJobQueue[emailJob](rc, JobQueueConf{
+		Name:         "email",
+		Group:        "workers",
+		Workers:      3,
+		MaxAttempts:  3,
+		BackoffBase:  10,
+		BackoffMax:   40,
+		PollInterval: 20,
+		ClaimIdle:    100,
+		DeadMaxLen:   100,
+	})
+	require.Nil(t, err)
+	return q
+}
+
+func launchWorker(t *testing.T, q *JobQueue[emailJob], handler JobHandler[emailJob]) *JobWorker[emailJob] {
+	w := q.NewWorker(handler)
+	require.Nil(t, w.Launch(context.Background(), nil))
+	t.Cleanup(func() { _ = w.Stop() })
+	return w
+}
+
+func TestJobQueueProcess(t *testing.T) {
+	mr := miniredis.RunT(t)
+	q := newTestJobQueue(t, newMiniRedisClient(t, mr))
+
+	var (
+		mutex sync.Mutex
+		got   = map[string]emailJob{}
+	)
+	launchWorker(t, q, func(ctx context.Context, job *Job[emailJob]) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		got[job.Id] = job.Payload
+		return nil
+	})
+
+	ids := map[string]string{}
+	for _, to := range []string{"a@x.com", "b@x.com", "c@x.com", "d@x.com", "e@x.com"} {
+		id, err := q.Enqueue(emailJob{To: to, Subject: "hi"})
+		require.Nil(t, err)
+		ids[id] = to
+	}
+
+	require.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(got) == len(ids)
+	}, 2*time.Second, 10*time.Millisecond)
+	for id, to := range ids {
+		require.Equal(t, emailJob{To: to, Subject: "hi"}, got[id])
+	}
+
+	// acked jobs are removed from the stream
+	require.Eventually(t, func() bool {
+		entries, err := mr.Stream(q.streamKey())
+		return err == nil && len(entries) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestJobQueueRetryAndDeadLetter(t *testing.T) {
+	q := newTestJobQueue(t, newMiniRedisClient(t, miniredis.RunT(t)))
+
+	var (
+		succeed  atomic.Bool
+		attempts = make(chan *Job[emailJob], 10)
+	)
+	launchWorker(t, q, func(ctx context.Context, job *Job[emailJob]) error {
+		attempts <- job
+		if succeed.Load() {
+			return nil
+		}
+		if job.Attempt == 2 {
+			panic("boom")
+		}
+		return errors.New("smtp down")
+	})
+
+	id, err := q.Enqueue(emailJob{To: "a@x.com"})
+	require.Nil(t, err)
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case job := <-attempts:
+			require.Equal(t, id, job.Id)
+			require.Equal(t, i, job.Attempt)
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "job not retried", "attempt:%v", i)
+		}
+	}
+
+	var dead []*Job[emailJob]
+	require.Eventually(t, func() bool {
+		dead, err = q.DeadLetters(10)
+		return err == nil && len(dead) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, id, dead[0].Id)
+	require.Equal(t, 3, dead[0].Attempt)
+	require.Equal(t, "smtp down", dead[0].LastError)
+	require.Equal(t, "a@x.com", dead[0].Payload.To)
+
+	succeed.Store(true)
+	require.Nil(t, q.RetryDead(dead[0]))
+	select {
+	case job := <-attempts:
+		require.Equal(t, id, job.Id)
+		require.Equal(t, 1, job.Attempt)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "dead job not retried")
+	}
+
+	dead, err = q.DeadLetters(10)
+	require.Nil(t, err)
+	require.Empty(t, dead)
+}
+
+func TestJobQueueDelayed(t *testing.T) {
+	q := newTestJobQueue(t, newMiniRedisClient(t, miniredis.RunT(t)))
+
+	done := make(chan time.Time, 1)
+	launchWorker(t, q, func(ctx context.Context, job *Job[emailJob]) error {
+		done <- time.Now()
+		return nil
+	})
+
+	start := time.Now()
+	_, err := q.EnqueueIn(emailJob{To: "a@x.com"}, 300*time.Millisecond)
+	require.Nil(t, err)
+
+	n, err := q.DelayedLen()
+	require.Nil(t, err)
+	require.Equal(t, int64(1), n)
+
+	select {
+	case at := <-done:
+		require.GreaterOrEqual(t, at.Sub(start), 300*time.Millisecond)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "delayed job not processed")
+	}
+
+	n, err = q.DelayedLen()
+	require.Nil(t, err)
+	require.Equal(t, int64(0), n)
+}
+
+func TestJobQueueClaimStale(t *testing.T) {
+	rc := newMiniRedisClient(t, miniredis.RunT(t))
+	q := newTestJobQueue(t, rc)
+
+	require.Nil(t, rc.rc.XGroupCreateMkStream(rc.ctx, q.streamKey(), q.conf.Group, "0").Err())
+	id, err := q.Enqueue(emailJob{To: "a@x.com"})
+	require.Nil(t, err)
+
+	// delivered to a consumer which crashes before ack
+	streams, err := rc.rc.XReadGroup(rc.ctx, &redis.XReadGroupArgs{
+		Group:    q.conf.Group,
+		Consumer: "crashed",
+		Streams:  []string{q.streamKey(), ">"},
+		Count:    1,
+	}).Result()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(streams[0].Messages))
+
+	done := make(chan *Job[emailJob], 1)
+	launchWorker(t, q, func(ctx context.Context, job *Job[emailJob]) error {
+		done <- job
+		return nil
+	})
+
+	// the crashed delivery counts as a failed attempt
+	select {
+	case job := <-done:
+		require.Equal(t, id, job.Id)
+		require.Equal(t, 2, job.Attempt)
+		require.Equal(t, ErrJobAbandoned.Error(), job.LastError)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "stale job not claimed")
+	}
+}
+
+func TestJobQueueCrashingJobDeadLetter(t *testing.T) {
+	rc := newMiniRedisClient(t, miniredis.RunT(t))
+	q := newTestJobQueue(t, rc)
+
+	id, err := q.Enqueue(emailJob{To: "a@x.com"})
+	require.Nil(t, err)
+
+	require.Nil(t, rc.rc.XGroupCreateMkStream(rc.ctx, q.streamKey(), q.conf.Group, "0").Err())
+	streams, err := rc.rc.XReadGroup(rc.ctx, &redis.XReadGroupArgs{
+		Group:    q.conf.Group,
+		Consumer: "crashed",
+		Streams:  []string{q.streamKey(), ">"},
+		Count:    1,
+	}).Result()
+	require.Nil(t, err)
+	entryId := streams[0].Messages[0].ID
+
+	// each consumer crashes in the handler, i.e. exits without ack
+	for i := 1; i < q.conf.MaxAttempts; i++ {
+		require.Nil(t, rc.rc.XClaim(rc.ctx, &redis.XClaimArgs{
+			Stream:   q.streamKey(),
+			Group:    q.conf.Group,
+			Consumer: "crashed",
+			Messages: []string{entryId},
+		}).Err())
+	}
+
+	var handled atomic.Bool
+	launchWorker(t, q, func(ctx context.Context, job *Job[emailJob]) error {
+		handled.Store(true)
+		return nil
+	})
+
+	var dead []*Job[emailJob]
+	require.Eventually(t, func() bool {
+		dead, err = q.DeadLetters(10)
+		return err == nil && len(dead) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, id, dead[0].Id)
+	require.Equal(t, q.conf.MaxAttempts, dead[0].Attempt)
+	require.Equal(t, ErrJobAbandoned.Error(), dead[0].LastError)
+	require.False(t, handled.Load())
+}
+
+func TestJobQueueUndecodableDeadLetter(t *testing.T) {
+	rc := newMiniRedisClient(t, miniredis.RunT(t))
+	q := newTestJobQueue(t, rc)
+
+	require.Nil(t, rc.rc.XAdd(rc.ctx, &redis.XAddArgs{Stream: q.deadKey(), Values: []any{"garbage", "x"}}).Err())
+	require.Nil(t, rc.rc.XAdd(rc.ctx, &redis.XAddArgs{Stream: q.deadKey(), Values: []any{jobField, `{"id":"1","payload":"not an object"}`}}).Err())
+
+	dead, err := q.DeadLetters(10)
+	require.Nil(t, err)
+	require.Len(t, dead, 2)
+	require.Equal(t, map[string]any{"garbage": "x"}, dead[0].Raw)
+	require.Equal(t, "1", dead[1].Id)
+	require.NotNil(t, dead[1].Raw)
+	require.NotNil(t, q.RetryDead(dead[0]))
+
+	for _, job := range dead {
+		require.Nil(t, q.DeleteDead(job))
+	}
+	dead, err = q.DeadLetters(10)
+	require.Nil(t, err)
+	require.Empty(t, dead)
+}
+
+func TestJobQueueStopRequeuesJob(t *testing.T) {
+	rc := newMiniRedisClient(t, miniredis.RunT(t))
+	q := newTestJobQueue(t, rc)
+
+	started := make(chan struct{})
+	w := launchWorker(t, q, func(ctx context.Context, job *Job[emailJob]) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	id, err := q.Enqueue(emailJob{To: "a@x.com"})
+	require.Nil(t, err)
+	<-started
+
+	require.Nil(t, w.Stop())
+
+	pending, err := rc.rc.XPending(rc.ctx, q.streamKey(), q.conf.Group).Result()
+	require.Nil(t, err)
+	require.Equal(t, int64(0), pending.Count)
+
+	// the interrupted delivery is not a failed attempt
+	done := make(chan *Job[emailJob], 1)
+	launchWorker(t, q, func(ctx context.Context, job *Job[emailJob]) error {
+		done <- job
+		return nil
+	})
+	select {
+	case job := <-done:
+		require.Equal(t, id, job.Id)
+		require.Equal(t, 1, job.Attempt)
+		require.Empty(t, job.LastError)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "interrupted job not requeued")
+	}
+}
+
+func TestJobQueueBackoff(t *testing.T) {
+	q, err := NewJobQueue[emailJob](nil, JobQueueConf{Name: "q", Group: "g", Workers: 1, MaxAttempts: 10, BackoffBase: 1000, BackoffMax: 5000, PollInterval: 1000})
+	require.Nil(t, err)
+	require.Equal(t, time.Second, q.backoff(1))
+	require.Equal(t, 2*time.Second, q.backoff(2))
+	require.Equal(t, 4*time.Second, q.backoff(3))
+	require.Equal(t, 5*time.Second, q.backoff(4))
+	require.Equal(t, 5*time.Second, q.backoff(100))
+
+	_, err = NewJobQueue[emailJob](nil, JobQueueConf{Name: "q", Group: "g"})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,323 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/madlabx/pkgx/dbc"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/graceful"
+	"github.com/madlabx/pkgx/log"
+	"github.com/madlabx/pkgx/servicex"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	_ graceful.GracefulService = (*JobWorker[any])(nil)
+	_ servicex.ServiceIf       = (*JobWorker[any])(nil)
+)
+
+const promoteBatch = 100
+
+// JobWorker runs conf.Workers consumers of the queue in the consumer group conf.Group.
+// It implements graceful.GracefulService and servicex.ServiceIf
+type JobWorker[T any] struct {
+	q        *JobQueue[T]
+	handler  JobHandler[T]
+	consumer string
+
+	mutex  sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (q *JobQueue[T]) NewWorker(handler JobHandler[T]) *JobWorker[T] {
+	host, _ := os.Hostname()
+	return &JobWorker[T]{
+		q:        q,
+		handler:  handler,
+		consumer: host + "-" + uuid.New().String(),
+	}
+}
+
+// Launch creates the consumer group if not exists and starts consumers in background, implements servicex.ServiceIf
+func (w *JobWorker[T]) Launch(pCtx context.Context, _ *dbc.DbClient) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.cancel != nil {
+		return errors.Errorf("job worker %v already launched", w.Name())
+	}
+
+	rc := w.q.rc
+	err := rc.rc.XGroupCreateMkStream(rc.ctx, w.q.streamKey(), w.q.conf.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return errors.Wrap(err)
+	}
+
+	var ctx context.Context
+	ctx, w.cancel = context.WithCancel(pCtx)
+	for i := 0; i < w.q.conf.Workers; i++ {
+		w.wg.Add(1)
+		go w.consumeLoop(ctx)
+	}
+	w.wg.Add(1)
+	go w.maintainLoop(ctx)
+
+	return nil
+}
+
+func (w *JobWorker[T]) GetName() string {
+	return w.Name()
+}
+
+func (w *JobWorker[T]) Name() string {
+	return "jobq_" + w.q.conf.Name
+}
+
+// Stop stops fetching jobs, cancels ctx of running handlers and waits for them to return.
+// Jobs interrupted are queued again without counting the attempt
+func (w *JobWorker[T]) Stop() error {
+	w.mutex.Lock()
+	cancel := w.cancel
+	w.mutex.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	w.wg.Wait()
+	return nil
+}
+
+func (w *JobWorker[T]) pollInterval() time.Duration {
+	return msToDuration(w.q.conf.PollInterval)
+}
+
+func (w *JobWorker[T]) consumeLoop(ctx context.Context) {
+	defer w.wg.Done()
+
+	rc := w.q.rc
+	for ctx.Err() == nil {
+		streams, err := rc.rc.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    w.q.conf.Group,
+			Consumer: w.consumer,
+			Streams:  []string{w.q.streamKey(), ">"},
+			Count:    1,
+			Block:    w.pollInterval(),
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+
+			log.Errorf("Failed to read jobs, queue:%v, err:%v", w.q.conf.Name, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(w.pollInterval()):
+			}
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				w.process(ctx, msg)
+			}
+		}
+	}
+}
+
+// maintainLoop moves due jobs from the delayed set to the stream, and claims jobs of dead consumers
+func (w *JobWorker[T]) maintainLoop(ctx context.Context) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.pollInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := w.promote(); err != nil {
+			log.Errorf("Failed to promote delayed jobs, queue:%v, err:%v", w.q.conf.Name, err)
+		}
+
+		if w.q.conf.ClaimIdle > 0 {
+			if err := w.claim(ctx); err != nil {
+				log.Errorf("Failed to claim stale jobs, queue:%v, err:%v", w.q.conf.Name, err)
+			}
+		}
+	}
+}
+
+func (w *JobWorker[T]) promote() error {
+	rc := w.q.rc
+	for {
+		n, err := promoteScript.Run(rc.ctx, rc.rc, []string{w.q.delayedKey(), w.q.streamKey()}, time.Now().UnixMilli(), promoteBatch).Int()
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		if n < promoteBatch {
+			return nil
+		}
+	}
+}
+
+func (w *JobWorker[T]) claim(ctx context.Context) error {
+	rc := w.q.rc
+	start := "0-0"
+	for ctx.Err() == nil {
+		msgs, next, err := rc.rc.XAutoClaim(rc.ctx, &redis.XAutoClaimArgs{
+			Stream:   w.q.streamKey(),
+			Group:    w.q.conf.Group,
+			Consumer: w.consumer,
+			MinIdle:  msToDuration(w.q.conf.ClaimIdle),
+			Start:    start,
+			Count:    10,
+		}).Result()
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		for _, msg := range msgs {
+			w.reclaim(msg)
+		}
+
+		if next == "0-0" {
+			return nil
+		}
+		start = next
+	}
+	return nil
+}
+
+// reclaim counts deliveries not acked as failed attempts, so that a job crashing its worker
+// is retried with backoff and ends in the dead-letter stream
+func (w *JobWorker[T]) reclaim(msg redis.XMessage) {
+	env, err := decodeJobEnvelope(msg)
+	if err != nil {
+		w.buryMalformed(msg, err)
+		return
+	}
+
+	// the claim itself is a delivery, not an attempt
+	deliveries := int64(2)
+	rc := w.q.rc
+	pending, err := rc.rc.XPendingExt(rc.ctx, &redis.XPendingExtArgs{
+		Stream: w.q.streamKey(),
+		Group:  w.q.conf.Group,
+		Start:  msg.ID,
+		End:    msg.ID,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		log.Errorf("Failed to get deliveries of claimed job, queue:%v, entry:%v, err:%v", w.q.conf.Name, msg.ID, err)
+	} else if len(pending) == 1 && pending[0].RetryCount > deliveries {
+		deliveries = pending[0].RetryCount
+	}
+
+	env.Attempt += int(deliveries - 1)
+	log.Warnf("Claimed stale job, queue:%v, id:%v, entry:%v, attempt:%v", w.q.conf.Name, env.Id, msg.ID, env.Attempt)
+	w.retryOrBury(msg.ID, env, ErrJobAbandoned)
+}
+
+func (w *JobWorker[T]) buryMalformed(msg redis.XMessage, err error) {
+	// malformed jobs would never succeed
+	log.Errorf("Failed to decode job, queue:%v, entry:%v, err:%v", w.q.conf.Name, msg.ID, err)
+	w.finish(msg.ID, func(pipe redis.Pipeliner) error {
+		return w.bury(pipe, msg.Values)
+	})
+}
+
+func (w *JobWorker[T]) process(ctx context.Context, msg redis.XMessage) {
+	job, env, err := decodeJob[T](msg)
+	if err != nil {
+		w.buryMalformed(msg, err)
+		return
+	}
+
+	err = w.handle(ctx, job)
+	if err == nil {
+		w.finish(msg.ID, nil)
+		return
+	}
+
+	if ctx.Err() != nil {
+		// not a failure of the job, if not requeued it is left pending and claimed as abandoned
+		log.Warnf("Job interrupted by stop, requeued, queue:%v, id:%v, err:%v", w.q.conf.Name, job.Id, err)
+		w.finish(msg.ID, func(pipe redis.Pipeliner) error {
+			return w.q.schedule(pipe, env, time.Now())
+		})
+		return
+	}
+
+	env.Attempt = job.Attempt
+	w.retryOrBury(msg.ID, env, err)
+}
+
+// retryOrBury schedules the failed attempt env.Attempt to be retried with backoff, or moves it
+// to the dead-letter stream after MaxAttempts
+func (w *JobWorker[T]) retryOrBury(entryId string, env *jobEnvelope, err error) {
+	env.LastError = err.Error()
+	if env.Attempt >= w.q.conf.MaxAttempts {
+		log.Errorf("Job failed and moved to dead-letter stream, queue:%v, id:%v, attempt:%v, err:%v", w.q.conf.Name, env.Id, env.Attempt, err)
+		w.finish(entryId, func(pipe redis.Pipeliner) error {
+			data, err := json.Marshal(env)
+			if err != nil {
+				return errors.Wrap(err)
+			}
+			return w.bury(pipe, []any{jobField, string(data)})
+		})
+		return
+	}
+
+	delay := w.q.backoff(env.Attempt)
+	log.Warnf("Job failed, will retry, queue:%v, id:%v, attempt:%v, delay:%v, err:%v", w.q.conf.Name, env.Id, env.Attempt, delay, err)
+	w.finish(entryId, func(pipe redis.Pipeliner) error {
+		return w.q.schedule(pipe, env, time.Now().Add(delay))
+	})
+}
+
+func (w *JobWorker[T]) handle(ctx context.Context, job *Job[T]) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic in job handler: %v", r)
+		}
+	}()
+
+	return w.handler(ctx, job)
+}
+
+func (w *JobWorker[T]) bury(pipe redis.Pipeliner, values any) error {
+	return pipe.XAdd(w.q.rc.ctx, &redis.XAddArgs{
+		Stream: w.q.deadKey(),
+		MaxLen: w.q.conf.DeadMaxLen,
+		Approx: true,
+		Values: values,
+	}).Err()
+}
+
+// finish acks and deletes the entry together with fn, so that a job is neither lost nor duplicated
+func (w *JobWorker[T]) finish(entryId string, fn func(pipe redis.Pipeliner) error) {
+	rc := w.q.rc
+	_, err := rc.rc.TxPipelined(rc.ctx, func(pipe redis.Pipeliner) error {
+		if fn != nil {
+			if err := fn(pipe); err != nil {
+				return err
+			}
+		}
+		pipe.XAck(rc.ctx, w.q.streamKey(), w.q.conf.Group, entryId)
+		pipe.XDel(rc.ctx, w.q.streamKey(), entryId)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Failed to finish job, queue:%v, entry:%v, err:%v", w.q.conf.Name, entryId, err)
+	}
+}
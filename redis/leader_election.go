@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/madlabx/pkgx/dbc"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/graceful"
+	"github.com/madlabx/pkgx/log"
+	"github.com/madlabx/pkgx/servicex"
+)
+
+var (
+	_ graceful.GracefulService = (*LeaderElection)(nil)
+	_ servicex.ServiceIf       = (*LeaderElection)(nil)
+)
+
+// LeaderJobFunc runs while leading, ctx is cancelled once the leadership is lost or the election stops.
+// fence is the fencing token of the underlying lock
+type LeaderJobFunc func(ctx context.Context, fence int64) error
+
+// LeaderElection makes sure only one replica runs job at a time.
+// It implements graceful.GracefulService and servicex.ServiceIf
+type LeaderElection struct {
+	rc   *Client
+	name string
+	job  LeaderJobFunc
+	opts []LockOption
+
+	isLeader atomic.Bool
+	mutex    sync.Mutex
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func NewLeaderElection(rc *Client, name string, job LeaderJobFunc, opts ...LockOption) *LeaderElection {
+	return &LeaderElection{
+		rc:   rc,
+		name: name,
+		job:  job,
+		opts: opts,
+	}
+}
+
+// Launch starts to campaign in background, implements servicex.ServiceIf
+func (le *LeaderElection) Launch(pCtx context.Context, _ *dbc.DbClient) error {
+	le.mutex.Lock()
+	defer le.mutex.Unlock()
+	if le.done != nil {
+		return errors.Errorf("leader election %v already launched", le.name)
+	}
+
+	var ctx context.Context
+	ctx, le.cancel = context.WithCancel(pCtx)
+	le.done = make(chan struct{})
+	go le.campaign(ctx)
+
+	return nil
+}
+
+func (le *LeaderElection) GetName() string {
+	return le.name
+}
+
+func (le *LeaderElection) Name() string {
+	return le.name
+}
+
+func (le *LeaderElection) IsLeader() bool {
+	return le.isLeader.Load()
+}
+
+// Stop cancels the job, releases the leadership and waits for the campaign to exit
+func (le *LeaderElection) Stop() error {
+	le.mutex.Lock()
+	cancel, done := le.cancel, le.done
+	le.mutex.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	<-done
+	return nil
+}
+
+func (le *LeaderElection) campaign(ctx context.Context) {
+	defer close(le.done)
+
+	o := newLockOptions(le.opts...)
+	for {
+		lock, err := le.rc.Lock(ctx, le.name, le.opts...)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Errorf("Failed to campaign, name:%v, err:%v", le.name, err)
+			time.Sleep(o.retryInterval)
+			continue
+		}
+
+		log.Infof("Become leader, name:%v, fence:%v", le.name, lock.FencingToken())
+		le.isLeader.Store(true)
+		le.lead(ctx, lock)
+		le.isLeader.Store(false)
+		log.Infof("Step down, name:%v", le.name)
+
+		if err = lock.Unlock(); err != nil && !errors.Is(err, ErrLockNotHeld) {
+			log.Errorf("Failed to release leadership, name:%v, err:%v", le.name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(o.retryInterval):
+		}
+	}
+}
+
+func (le *LeaderElection) lead(ctx context.Context, lock *Lock) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-lock.Lost():
+			cancel()
+		case <-jobCtx.Done():
+		}
+	}()
+
+	if err := le.job(jobCtx, lock.FencingToken()); err != nil {
+		log.Errorf("Leader job exits with error, name:%v, err:%v", le.name, err)
+	}
+}
@@ -0,0 +1,308 @@
+package redis
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/madlabx/pkgx/cachestore"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+type IndexMode int
+
+const (
+	// EnumIndexNone lists by SCAN over the whole keyspace
+	EnumIndexNone IndexMode = iota
+	// EnumIndexSortedSet keeps keys of a table in a sorted set, listed in key order
+	EnumIndexSortedSet
+	// EnumIndexHash keeps keys of a table in a hash, listed by HSCAN
+	EnumIndexHash
+)
+
+const defaultListPageSize = 100
+
+type ListOption func(*listOptions)
+
+type listOptions struct {
+	pageSize  int
+	mgetChunk int
+}
+
+// WithPageSize max keys fetched per page, it is a hint for SCAN/HSCAN
+func WithPageSize(pageSize int) ListOption {
+	return func(o *listOptions) {
+		o.pageSize = pageSize
+	}
+}
+
+// WithMGetChunk fetches values by MGET with at most chunk keys each, instead of pipelined GET.
+// Ignored in cluster mode since keys may belong to different slots
+func WithMGetChunk(chunk int) ListOption {
+	return func(o *listOptions) {
+		o.mgetChunk = chunk
+	}
+}
+
+func newListOptions(opts ...ListOption) listOptions {
+	o := listOptions{pageSize: defaultListPageSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.pageSize <= 0 {
+		o.pageSize = defaultListPageSize
+	}
+	return o
+}
+
+func indexKey(table string) string {
+	return "idx_" + table
+}
+
+func tablePattern(table string) string {
+	return table + "_*"
+}
+
+// EnableListIndex keeps keys of the table of nr in an index on Set/Delete,
+// so that listing the table does not need to SCAN the whole keyspace.
+// Keys set before enabling are not indexed
+func (rc *Client) EnableListIndex(nr cachestore.Record, mode IndexMode) {
+	rc.indexes.Store(nr.TableName(), mode)
+}
+
+func (rc *Client) indexMode(table string) IndexMode {
+	mode, ok := rc.indexes.Load(table)
+	if !ok {
+		return EnumIndexNone
+	}
+	return mode.(IndexMode)
+}
+
+func (rc *Client) indexAdd(pipe redis.Pipeliner, table, key string) {
+	switch rc.indexMode(table) {
+	case EnumIndexSortedSet:
+		pipe.ZAdd(rc.ctx, indexKey(table), redis.Z{Member: key})
+	case EnumIndexHash:
+		pipe.HSet(rc.ctx, indexKey(table), key, "")
+	default:
+	}
+}
+
+func (rc *Client) indexRemove(pipe redis.Pipeliner, table string, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	switch rc.indexMode(table) {
+	case EnumIndexSortedSet:
+		members := make([]any, len(keys))
+		for i, key := range keys {
+			members[i] = key
+		}
+		pipe.ZRem(rc.ctx, indexKey(table), members...)
+	case EnumIndexHash:
+		pipe.HDel(rc.ctx, indexKey(table), keys...)
+	default:
+	}
+}
+
+func (rc *Client) isCluster() bool {
+	_, ok := rc.rc.(*redis.ClusterClient)
+	return ok
+}
+
+// keysPage returns keys of table from cursor, next is "" when done
+func (rc *Client) keysPage(table, cursor string, pageSize int) (keys []string, next string, err error) {
+	switch rc.indexMode(table) {
+	case EnumIndexSortedSet:
+		lexMin := "-"
+		if cursor != "" {
+			lexMin = "(" + cursor
+		}
+		keys, err = rc.rc.ZRangeByLex(rc.ctx, indexKey(table), &redis.ZRangeBy{Min: lexMin, Max: "+", Count: int64(pageSize)}).Result()
+		if err != nil {
+			return nil, "", errors.Wrap(err)
+		}
+		if len(keys) == pageSize {
+			next = keys[len(keys)-1]
+		}
+		return keys, next, nil
+
+	case EnumIndexHash:
+		c, err := parseCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		// fields and values are interleaved
+		kvs, nc, err := rc.rc.HScan(rc.ctx, indexKey(table), c, "", int64(pageSize)).Result()
+		if err != nil {
+			return nil, "", errors.Wrap(err)
+		}
+		for i := 0; i < len(kvs); i += 2 {
+			keys = append(keys, kvs[i])
+		}
+		return keys, formatCursor(nc), nil
+
+	default:
+		if rc.isCluster() {
+			return nil, "", errors.New("cursor is not supported in cluster mode without index")
+		}
+		c, err := parseCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		keys, nc, err := rc.rc.Scan(rc.ctx, c, tablePattern(table), int64(pageSize)).Result()
+		if err != nil {
+			return nil, "", errors.Wrap(err)
+		}
+		return keys, formatCursor(nc), nil
+	}
+}
+
+func parseCursor(cursor string) (uint64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	c, err := strconv.ParseUint(cursor, 10, 64)
+	return c, errors.Wrap(err)
+}
+
+func formatCursor(c uint64) string {
+	if c == 0 {
+		return ""
+	}
+	return strconv.FormatUint(c, 10)
+}
+
+// fetch gets values of keys, keys vanished since listed are skipped and removed from index
+func fetch[T cachestore.Record](rc *Client, nr T, keys []string, o listOptions) ([]T, error) {
+	values := make([]any, 0, len(keys))
+	if o.mgetChunk > 0 && !rc.isCluster() {
+		for start := 0; start < len(keys); start += o.mgetChunk {
+			end := min(start+o.mgetChunk, len(keys))
+			chunk, err := rc.rc.MGet(rc.ctx, keys[start:end]...).Result()
+			if err != nil {
+				return nil, errors.Wrap(err)
+			}
+			values = append(values, chunk...)
+		}
+	} else {
+		cmds, err := rc.rc.Pipelined(rc.ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				pipe.Get(rc.ctx, key)
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, errors.Wrap(err)
+		}
+		for _, cmd := range cmds {
+			v, err := cmd.(*redis.StringCmd).Result()
+			if errors.Is(err, redis.Nil) {
+				values = append(values, nil)
+			} else if err != nil {
+				return nil, errors.Wrap(err)
+			} else {
+				values = append(values, v)
+			}
+		}
+	}
+
+	var (
+		records  []T
+		vanished []string
+	)
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			vanished = append(vanished, keys[i])
+			continue
+		}
+
+		tmp := nr.Clone()
+		if err := tmp.Unmarshal(s); err != nil {
+			return nil, errors.Wrap(err)
+		}
+		records = append(records, tmp.(T))
+	}
+
+	if len(vanished) > 0 && rc.indexMode(nr.TableName()) != EnumIndexNone {
+		_, err := rc.rc.Pipelined(rc.ctx, func(pipe redis.Pipeliner) error {
+			rc.indexRemove(pipe, nr.TableName(), vanished...)
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+	}
+
+	return records, nil
+}
+
+// ListPage returns records of the table of nr from cursor, pass "" to start.
+// nextCursor is "" once all records are listed. A page may be smaller than the page size
+// since keys expired or deleted during listing are skipped
+func ListPage[T cachestore.Record](rc *Client, nr T, cursor string, opts ...ListOption) (records []T, nextCursor string, err error) {
+	o := newListOptions(opts...)
+
+	var keys []string
+	for {
+		keys, nextCursor, err = rc.keysPage(nr.TableName(), cursor, o.pageSize)
+		if err != nil {
+			return nil, "", err
+		}
+		// SCAN may return empty pages before the end
+		if len(keys) > 0 || nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	if len(keys) == 0 {
+		return nil, nextCursor, nil
+	}
+
+	records, err = fetch(rc, nr, keys, o)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return records, nextCursor, nil
+}
+
+// ListEach calls fn with records of the table of nr page by page, stops on the first error of fn
+func ListEach[T cachestore.Record](rc *Client, nr T, fn func([]T) error, opts ...ListOption) error {
+	o := newListOptions(opts...)
+
+	if rc.isCluster() && rc.indexMode(nr.TableName()) == EnumIndexNone {
+		var mutex sync.Mutex
+		return rc.scan(tablePattern(nr.TableName()), func(keys []string) error {
+			records, err := fetch(rc, nr, keys, o)
+			if err != nil || len(records) == 0 {
+				return err
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			return fn(records)
+		})
+	}
+
+	cursor := ""
+	for {
+		records, next, err := ListPage(rc, nr, cursor, opts...)
+		if err != nil {
+			return err
+		}
+
+		if len(records) > 0 {
+			if err = fn(records); err != nil {
+				return err
+			}
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
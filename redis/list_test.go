@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// OtherTableMock is stored in the same keyspace as RefreshTokenMock
+type OtherTableMock struct {
+	RefreshTokenMock
+}
+
+func (r *OtherTableMock) TableName() string { return "other_token_mock" }
+
+func listAllPages(t *testing.T, rc *Client, opts ...ListOption) []string {
+	var (
+		keys   []string
+		cursor string
+	)
+	for {
+		records, next, err := ListPage(rc, &RefreshTokenMock{}, cursor, opts...)
+		require.Nil(t, err)
+		for _, r := range records {
+			keys = append(keys, r.IKey)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func expectedKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%02d", i)
+	}
+	return keys
+}
+
+func TestListPage(t *testing.T) {
+	for name, mode := range map[string]IndexMode{
+		"scan":      EnumIndexNone,
+		"sortedSet": EnumIndexSortedSet,
+		"hash":      EnumIndexHash,
+	} {
+		t.Run(name, func(t *testing.T) {
+			rc := newMiniRedisClient(t, miniredis.RunT(t))
+			rc.EnableListIndex(&RefreshTokenMock{}, mode)
+
+			for _, k := range expectedKeys(25) {
+				require.Nil(t, rc.Set(&RefreshTokenMock{IKey: k, IValue: k}, 100))
+			}
+			require.Nil(t, rc.Set(&OtherTableMock{RefreshTokenMock{IKey: "other"}}, 100))
+
+			require.Equal(t, expectedKeys(25), listAllPages(t, rc, WithPageSize(10)))
+			require.Equal(t, expectedKeys(25), listAllPages(t, rc, WithPageSize(7), WithMGetChunk(3)))
+
+			records, err := List(rc, &RefreshTokenMock{})
+			require.Nil(t, err)
+			require.Equal(t, 25, len(records))
+		})
+	}
+}
+
+func TestListSkipVanishedKeys(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rc := newMiniRedisClient(t, mr)
+	rc.EnableListIndex(&RefreshTokenMock{}, EnumIndexSortedSet)
+
+	for _, k := range expectedKeys(5) {
+		require.Nil(t, rc.Set(&RefreshTokenMock{IKey: k}, 1))
+	}
+	require.Nil(t, rc.Set(&RefreshTokenMock{IKey: "long"}, 100))
+	require.Nil(t, rc.Delete(&RefreshTokenMock{IKey: "key00"}))
+
+	members, err := mr.ZMembers(indexKey("refresh_token_mock"))
+	require.Nil(t, err)
+	require.Equal(t, 5, len(members))
+
+	mr.FastForward(2 * time.Second)
+
+	for _, opts := range [][]ListOption{nil, {WithMGetChunk(2)}} {
+		records, err := List(rc, &RefreshTokenMock{}, opts...)
+		require.Nil(t, err)
+		require.Equal(t, 1, len(records))
+		require.Equal(t, "long", records[0].IKey)
+	}
+
+	// vanished keys are removed from index
+	members, err = mr.ZMembers(indexKey("refresh_token_mock"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"refresh_token_mock_long"}, members)
+}
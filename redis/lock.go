@@ -0,0 +1,265 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/log"
+	"github.com/redis/go-redis/v9"
+)
+
+type LockOption func(*lockOptions)
+
+type lockOptions struct {
+	ttl           time.Duration
+	retryInterval time.Duration
+	autoRenew     bool
+}
+
+// WithLeaseTtl lease of the lock, the lock is released by redis if not renewed within ttl
+func WithLeaseTtl(ttl time.Duration) LockOption {
+	return func(o *lockOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithRetryInterval interval between two attempts of Lock
+func WithRetryInterval(interval time.Duration) LockOption {
+	return func(o *lockOptions) {
+		o.retryInterval = interval
+	}
+}
+
+// WithAutoRenew renew the lease every ttl/3 while the lock is held
+func WithAutoRenew(autoRenew bool) LockOption {
+	return func(o *lockOptions) {
+		o.autoRenew = autoRenew
+	}
+}
+
+// KEYS[1]: lock key, KEYS[2]: fencing key, ARGV[1]: token, ARGV[2]: ttl in ms
+var acquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+var refreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Lock is a lease owned by token, see Client.TryLock
+type Lock struct {
+	rc    *Client
+	name  string
+	token string
+	fence int64
+	opts  lockOptions
+	// acquiredAt is taken before the lock is requested, the lease ends no earlier than acquiredAt+ttl
+	acquiredAt time.Time
+
+	mutex    sync.Mutex
+	released bool
+	stopCh   chan struct{}
+	lostCh   chan struct{}
+	lostOnce sync.Once
+}
+
+// lockKey and fenceKey share the hash tag, so that acquireScript works in cluster mode
+func lockKey(name string) string {
+	return "lock_{" + name + "}"
+}
+
+func fenceKey(name string) string {
+	return "fence_{" + name + "}"
+}
+
+func newLockOptions(opts ...LockOption) lockOptions {
+	o := lockOptions{
+		ttl:           10 * time.Second,
+		retryInterval: 100 * time.Millisecond,
+		autoRenew:     true,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// TryLock tries to obtain lock name once, returns ErrLockNotObtained if held by others
+func (rc *Client) TryLock(name string, opts ...LockOption) (*Lock, error) {
+	return rc.tryLock(name, newLockOptions(opts...))
+}
+
+// Lock blocks until lock name is obtained or ctx is done
+func (rc *Client) Lock(ctx context.Context, name string, opts ...LockOption) (*Lock, error) {
+	o := newLockOptions(opts...)
+
+	ticker := time.NewTicker(o.retryInterval)
+	defer ticker.Stop()
+	for {
+		l, err := rc.tryLock(name, o)
+		if !errors.Is(err, ErrLockNotObtained) {
+			return l, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (rc *Client) tryLock(name string, o lockOptions) (*Lock, error) {
+	token := uuid.New().String()
+	acquiredAt := time.Now()
+	fence, err := acquireScript.Run(rc.ctx, rc.rc, []string{lockKey(name), fenceKey(name)}, token, o.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if fence == 0 {
+		return nil, ErrLockNotObtained
+	}
+
+	l := &Lock{
+		rc:         rc,
+		name:       name,
+		token:      token,
+		fence:      fence,
+		opts:       o,
+		acquiredAt: acquiredAt,
+		stopCh:     make(chan struct{}),
+		lostCh:     make(chan struct{}),
+	}
+
+	if o.autoRenew {
+		go l.renewLoop()
+	}
+
+	return l, nil
+}
+
+func (l *Lock) Name() string {
+	return l.name
+}
+
+// FencingToken increases each time the lock is obtained, pass it to the protected
+// resource to reject writes from a previous holder whose lease has expired
+func (l *Lock) FencingToken() int64 {
+	return l.fence
+}
+
+// Lost is closed once the lease can not be renewed any more, or may have expired since
+// the last renewal is older than ttl minus a safety margin
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lostCh
+}
+
+// Refresh extends the lease to ttl, returns ErrLockNotHeld if it is not owned by l any more
+func (l *Lock) Refresh() error {
+	return l.refresh(l.rc.ctx)
+}
+
+func (l *Lock) refresh(ctx context.Context) error {
+	ret, err := refreshScript.Run(ctx, l.rc.rc, []string{lockKey(l.name)}, l.token, l.opts.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if ret == 0 {
+		return ErrLockNotHeld
+	}
+
+	return nil
+}
+
+// Unlock releases the lock only if it is still owned by l
+func (l *Lock) Unlock() error {
+	l.mutex.Lock()
+	if l.released {
+		l.mutex.Unlock()
+		return ErrLockNotHeld
+	}
+	l.released = true
+	close(l.stopCh)
+	l.mutex.Unlock()
+
+	ret, err := releaseScript.Run(l.rc.ctx, l.rc.rc, []string{lockKey(l.name)}, l.token).Int64()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if ret == 0 {
+		return ErrLockNotHeld
+	}
+
+	return nil
+}
+
+func (l *Lock) markLost() {
+	l.lostOnce.Do(func() {
+		close(l.lostCh)
+	})
+}
+
+// safeLease is the lease taken as held after a renewal is sent, the margin covers clock drift of redis
+func (l *Lock) safeLease() time.Duration {
+	return l.opts.ttl - l.opts.ttl/5
+}
+
+func (l *Lock) renewLoop() {
+	ticker := time.NewTicker(l.opts.ttl / 3)
+	defer ticker.Stop()
+
+	leaseEnd := l.acquiredAt.Add(l.safeLease())
+	expire := time.NewTimer(time.Until(leaseEnd))
+	defer expire.Stop()
+	for {
+		select {
+		case <-l.stopCh:
+			return
+		case <-l.rc.ctx.Done():
+			l.markLost()
+			return
+		case <-expire.C:
+			log.Errorf("Lost lock, lease not renewed in time, name:%v", l.name)
+			l.markLost()
+			return
+		case <-ticker.C:
+			// a slow redis must not hold the loop beyond the lease
+			sent := time.Now()
+			ctx, cancel := context.WithDeadline(l.rc.ctx, leaseEnd)
+			err := l.refresh(ctx)
+			cancel()
+			if err == nil {
+				leaseEnd = sent.Add(l.safeLease())
+				expire.Reset(time.Until(leaseEnd))
+				continue
+			}
+
+			if errors.Is(err, ErrLockNotHeld) || !time.Now().Before(leaseEnd) {
+				log.Errorf("Lost lock, name:%v, err:%v", l.name, err)
+				l.markLost()
+				return
+			}
+
+			log.Errorf("Failed to renew lock, will retry, name:%v, err:%v", l.name, err)
+		}
+	}
+}
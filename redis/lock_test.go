@@ -0,0 +1,135 @@
+package redis
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTryLock(t *testing.T) {
+	rc := newMiniRedisClient(t, miniredis.RunT(t))
+
+	l1, err := rc.TryLock("job", WithAutoRenew(false))
+	require.Nil(t, err)
+
+	_, err = rc.TryLock("job", WithAutoRenew(false))
+	require.True(t, errors.Is(err, ErrLockNotObtained))
+
+	require.Nil(t, l1.Unlock())
+	require.True(t, errors.Is(l1.Unlock(), ErrLockNotHeld))
+
+	l2, err := rc.TryLock("job", WithAutoRenew(false))
+	require.Nil(t, err)
+	require.Greater(t, l2.FencingToken(), l1.FencingToken())
+	require.Nil(t, l2.Unlock())
+}
+
+func TestLockExpiredNotReleasedByFormerOwner(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rc := newMiniRedisClient(t, mr)
+
+	l1, err := rc.TryLock("job", WithLeaseTtl(time.Second), WithAutoRenew(false))
+	require.Nil(t, err)
+
+	mr.FastForward(2 * time.Second)
+
+	l2, err := rc.TryLock("job", WithLeaseTtl(time.Second), WithAutoRenew(false))
+	require.Nil(t, err)
+
+	require.True(t, errors.Is(l1.Refresh(), ErrLockNotHeld))
+	require.True(t, errors.Is(l1.Unlock(), ErrLockNotHeld))
+	require.Nil(t, l2.Refresh())
+	require.Nil(t, l2.Unlock())
+}
+
+func TestLockBlockingAndAutoRenew(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rc := newMiniRedisClient(t, mr)
+
+	l1, err := rc.TryLock("job", WithLeaseTtl(300*time.Millisecond))
+	require.Nil(t, err)
+
+	// renewed by l1, miniredis only expires keys on FastForward
+	mr.FastForward(200 * time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	require.Greater(t, mr.TTL(lockKey("job")), 200*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = rc.Lock(ctx, "job", WithRetryInterval(20*time.Millisecond))
+	require.NotNil(t, err)
+
+	mr.Del(lockKey("job"))
+	select {
+	case <-l1.Lost():
+	case <-time.After(time.Second):
+		t.Fatalf("lock should be lost")
+	}
+
+	l2, err := rc.Lock(context.Background(), "job", WithRetryInterval(20*time.Millisecond))
+	require.Nil(t, err)
+	require.Nil(t, l2.Unlock())
+}
+
+func TestLeaderElection(t *testing.T) {
+	rc := newMiniRedisClient(t, miniredis.RunT(t))
+
+	var running atomic.Int32
+	var maxRunning atomic.Int32
+	job := func(ctx context.Context, fence int64) error {
+		n := running.Add(1)
+		if n > maxRunning.Load() {
+			maxRunning.Store(n)
+		}
+		<-ctx.Done()
+		running.Add(-1)
+		return nil
+	}
+
+	le1 := NewLeaderElection(rc, "cron", job, WithLeaseTtl(300*time.Millisecond), WithRetryInterval(20*time.Millisecond))
+	le2 := NewLeaderElection(rc, "cron", job, WithLeaseTtl(300*time.Millisecond), WithRetryInterval(20*time.Millisecond))
+	require.Nil(t, le1.Launch(context.Background(), nil))
+	require.Nil(t, le2.Launch(context.Background(), nil))
+
+	require.Eventually(t, func() bool { return le1.IsLeader() || le2.IsLeader() }, time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, int32(1), maxRunning.Load())
+
+	leader, follower := le1, le2
+	if le2.IsLeader() {
+		leader, follower = le2, le1
+	}
+	require.False(t, follower.IsLeader())
+
+	require.Nil(t, leader.Stop())
+	require.False(t, leader.IsLeader())
+	require.Eventually(t, follower.IsLeader, time.Second, 10*time.Millisecond)
+
+	require.Nil(t, follower.Stop())
+	require.Equal(t, int32(0), running.Load())
+	require.Equal(t, int32(1), maxRunning.Load())
+}
+
+func TestLockLostBeforeLeaseExpires(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rc := newMiniRedisClient(t, mr)
+
+	ttl := 300 * time.Millisecond
+	start := time.Now()
+	l, err := rc.TryLock("job", WithLeaseTtl(ttl))
+	require.Nil(t, err)
+
+	// renewals keep failing, the holder must be told before the lease may expire
+	mr.SetError("unreachable")
+	select {
+	case <-l.Lost():
+		require.Less(t, time.Since(start), ttl)
+	case <-time.After(time.Second):
+		t.Fatalf("lock should be lost")
+	}
+}
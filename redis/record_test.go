@@ -38,3 +38,18 @@ func (r *RefreshTokenMock) SetExpireAt(expireInSec int64) {
 }
 
 func (r *RefreshTokenMock) TableName() string { return "refresh_token_mock" }
+
+var _ cachestore.TaggedRecord = &TaggedRefreshTokenMock{}
+
+// TaggedRefreshTokenMock 用于测试按tag失效
+type TaggedRefreshTokenMock struct {
+	RefreshTokenMock
+	ITags []string `json:"-"`
+}
+
+func (r *TaggedRefreshTokenMock) Clone() cachestore.Record {
+	n := *r
+	return &n
+}
+
+func (r *TaggedRefreshTokenMock) GetTags() []string { return r.ITags }
@@ -2,52 +2,43 @@ package redis
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/madlabx/pkgx/cachestore"
+	"github.com/madlabx/pkgx/dbc"
 	"github.com/madlabx/pkgx/errcode"
 	"github.com/madlabx/pkgx/errors"
+	"github.com/redis/go-redis/v9"
 )
 
-type Config struct {
-	Addr     string
-	Password string
-}
+var _ dbc.RowCacheIf = (*Client)(nil)
 
 type Client struct {
-	ctx   context.Context
-	rc    *redis.Client
-	query string
+	ctx     context.Context
+	rc      redis.UniversalClient
+	query   string
+	indexes sync.Map //table name -> IndexMode
 }
 
-func NewTestClient(pCtx context.Context, dbc *redis.Client) *Client {
-	ctx, _ := context.WithCancel(pCtx)
+func NewTestClient(pCtx context.Context, dbc redis.UniversalClient) *Client {
 	return &Client{
-		ctx: ctx,
+		ctx: pCtx,
 		rc:  dbc,
 	}
 }
 
-func NewClient(pCtx context.Context, conf Config) (*Client, error) {
-	ctx, _ := context.WithCancel(pCtx)
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     conf.Addr,     // Redis服务器地址
-		Password: conf.Password, // 密码，没有则留空
-		DB:       0,             // 选择哪个数据库，0是默认
-	},
-	)
+func NewClient(ctx context.Context, conf Config) (*Client, error) {
+	rdb, err := conf.NewUniversalClient()
+	if err != nil {
+		return nil, err
+	}
 
 	// 测试连接
-	_, err := rdb.Ping(context.Background()).Result()
-	if err != nil {
+	if _, err = rdb.Ping(ctx).Result(); err != nil {
+		_ = rdb.Close()
 		return nil, errors.Wrap(err)
 	}
-	//
-	//// 设置一个键值对
-	//err = rdb.Set(ctx, "key", "value", 0).Err()
-	//if err != nil {
-	//	return nil, errors.Wrap(err)
-	//}
 
 	return &Client{
 		ctx: ctx,
@@ -59,20 +50,159 @@ type RecordUnmarshaler interface {
 	Unmarshal(string) error
 }
 
-func (rc *Client) GetRaw() *redis.Client {
+// Ping checks the connection, usable as httpx.ReadyChecker
+func (rc *Client) Ping(ctx context.Context) error {
+	return errors.Wrap(rc.rc.Ping(ctx).Err())
+}
+
+func (rc *Client) GetRaw() redis.UniversalClient {
 	return rc.rc
 }
 
-func (rc *Client) ResetClient(realDbClient *redis.Client) {
+func (rc *Client) ResetClient(realDbClient redis.UniversalClient) {
 	rc.rc = realDbClient
 }
 
+// KEYS[1]: tag set, ARGV[1]: key, ARGV[2]: ttl of key in sec, 0 never expire.
+// The tag set lives as long as its longest-lived key
+var tagScript = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+local cur = redis.call("TTL", KEYS[1])
+redis.call("SADD", KEYS[1], ARGV[1])
+if ttl <= 0 then
+	redis.call("PERSIST", KEYS[1])
+elseif cur == -2 or (cur >= 0 and cur < ttl) then
+	redis.call("EXPIRE", KEYS[1], ttl)
+end
+return 1
+`)
+
 func (rc *Client) Set(nr cachestore.Record, expireAfterInSec int64) error {
-	return rc.rc.Set(rc.ctx, cachestore.UniqCacheKey(nr), nr.GetValue(), time.Second*time.Duration(expireAfterInSec)).Err()
+	key := cachestore.UniqCacheKey(nr)
+	tr, tagged := nr.(cachestore.TaggedRecord)
+	if (!tagged || len(tr.GetTags()) == 0) && rc.indexMode(nr.TableName()) == EnumIndexNone {
+		return rc.rc.Set(rc.ctx, key, nr.GetValue(), time.Second*time.Duration(expireAfterInSec)).Err()
+	}
+
+	_, err := rc.rc.TxPipelined(rc.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(rc.ctx, key, nr.GetValue(), time.Second*time.Duration(expireAfterInSec))
+		if tagged {
+			for _, tag := range tr.GetTags() {
+				tagScript.Eval(rc.ctx, pipe, []string{cachestore.UniqTagKey(tag)}, key, expireAfterInSec)
+			}
+		}
+		rc.indexAdd(pipe, nr.TableName(), key)
+		return nil
+	})
+	return errors.Wrap(err)
 }
 
+// Del
+// Deprecated: use Delete
 func (rc *Client) Del(nr cachestore.Record) error {
-	return rc.rc.Del(rc.ctx, cachestore.UniqCacheKey(nr)).Err()
+	return rc.Delete(nr)
+}
+
+func (rc *Client) Delete(nr cachestore.Record) error {
+	key := cachestore.UniqCacheKey(nr)
+	if rc.indexMode(nr.TableName()) == EnumIndexNone {
+		return rc.rc.Del(rc.ctx, key).Err()
+	}
+
+	_, err := rc.rc.TxPipelined(rc.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(rc.ctx, key)
+		rc.indexRemove(pipe, nr.TableName(), key)
+		return nil
+	})
+	return errors.Wrap(err)
+}
+
+// DeleteWithKeyPrefix deletes all keys of the table of nr starting with nr.GetKey()
+func (rc *Client) DeleteWithKeyPrefix(nr cachestore.Record) error {
+	return rc.scan(cachestore.UniqCacheKey(nr)+"*", func(keys []string) error {
+		if err := rc.unlink(keys); err != nil {
+			return err
+		}
+
+		if rc.indexMode(nr.TableName()) == EnumIndexNone {
+			return nil
+		}
+		_, err := rc.rc.Pipelined(rc.ctx, func(pipe redis.Pipeliner) error {
+			rc.indexRemove(pipe, nr.TableName(), keys...)
+			return nil
+		})
+		return errors.Wrap(err)
+	})
+}
+
+// InvalidateTag deletes all keys set with any of tags, see cachestore.TaggedRecord
+func (rc *Client) InvalidateTag(tags ...string) error {
+	for _, tag := range tags {
+		tagKey := cachestore.UniqTagKey(tag)
+		keys, err := rc.rc.SMembers(rc.ctx, tagKey).Result()
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if err = rc.unlink(append(keys, tagKey)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RunScript runs script by EVALSHA, and falls back to EVAL if the script is not loaded
+func (rc *Client) RunScript(script *redis.Script, keys []string, args ...any) *redis.Cmd {
+	return script.Run(rc.ctx, rc.rc, keys, args...)
+}
+
+// unlink deletes keys one by one in a pipeline, since keys may belong to different slots in cluster mode
+func (rc *Client) unlink(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := rc.rc.Pipelined(rc.ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Unlink(rc.ctx, key)
+		}
+		return nil
+	})
+	return errors.Wrap(err)
+}
+
+// scan calls fn with each batch of keys matching pattern.
+// In cluster mode every master is scanned, and fn may be called concurrently
+func (rc *Client) scan(pattern string, fn func(keys []string) error) error {
+	if cc, ok := rc.rc.(*redis.ClusterClient); ok {
+		return errors.Wrap(cc.ForEachMaster(rc.ctx, func(ctx context.Context, node *redis.Client) error {
+			return scanNode(ctx, node, pattern, fn)
+		}))
+	}
+
+	return scanNode(rc.ctx, rc.rc, pattern, fn)
+}
+
+func scanNode(ctx context.Context, node redis.Cmdable, pattern string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, pattern, 0).Result()
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if len(keys) > 0 {
+			if err = fn(keys); err != nil {
+				return err
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
 }
 
 func (rc *Client) Where(query string) *Client {
@@ -113,53 +243,15 @@ func (rc *Client) Update(nr cachestore.Record) error {
 	return rc.rc.Set(rc.ctx, cachestore.UniqCacheKey(nr), nr.GetValue(), ttl).Err()
 }
 
-func List[T cachestore.Record](rc *Client, nr T) ([]T, error) {
-
-	var cursor uint64
-	pattern := nr.TableName() + "*"
-	var keys []string
+// List returns all records of the table of nr, see ListEach to list page by page
+func List[T cachestore.Record](rc *Client, nr T, opts ...ListOption) ([]T, error) {
 	var records []T
-
-	for {
-		var err error
-		var ckeys []string
-		ckeys, cursor, err = rc.rc.Scan(rc.ctx, cursor, pattern, 0).Result()
-		if err != nil {
-			return nil, errors.Wrap(err)
-		}
-
-		if len(ckeys) > 0 {
-			keys = append(keys, ckeys...)
-		}
-		if cursor == 0 {
-			break
-		}
-
-	}
-	// 使用Pipeline批量获取key的值
-	//var pipe redis.Pipeliner
-	pipe := rc.GetRaw().Pipeline()
-	for _, key := range keys {
-		pipe.Get(rc.ctx, key)
-	}
-
-	results, err := pipe.Exec(rc.ctx)
+	err := ListEach(rc, nr, func(page []T) error {
+		records = append(records, page...)
+		return nil
+	}, opts...)
 	if err != nil {
-		return nil, errors.Wrap(err)
-	}
-
-	// 打印结果
-	for _, result := range results {
-		if result.Err() != nil {
-			return nil, errors.Wrap(err)
-		}
-		tmp := nr.Clone()
-		err = tmp.Unmarshal(result.(*redis.StringCmd).Val())
-		if err != nil {
-			return nil, errors.Wrap(err)
-		}
-
-		records = append(records, tmp.(T))
+		return nil, err
 	}
 
 	return records, nil
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/madlabx/pkgx/cachestore"
 	"github.com/madlabx/pkgx/errcode"
 	"github.com/madlabx/pkgx/testingx"
@@ -167,3 +168,48 @@ func TestUpdateTTL(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, time.Duration(-1), ttl)
 }
+
+func TestDeleteWithKeyPrefix(t *testing.T) {
+	rc := newMiniRedisClient(t, miniredis.RunT(t))
+
+	keyPreview := utils.RandomString(10)
+	keys := []string{"key1", "key2", "key3"}
+	for _, k := range keys {
+		require.Nil(t, rc.Set(&RefreshTokenMock{IKey: keyPreview + k}, 1000))
+	}
+	require.Nil(t, rc.Set(&RefreshTokenMock{IKey: "other" + keyPreview}, 1000))
+	defer func() {
+		require.Nil(t, rc.Delete(&RefreshTokenMock{IKey: "other" + keyPreview}))
+	}()
+
+	require.Nil(t, rc.DeleteWithKeyPrefix(&RefreshTokenMock{IKey: keyPreview}))
+
+	for _, k := range keys {
+		require.True(t, errcode.IsNotFound(rc.Get(&RefreshTokenMock{IKey: keyPreview + k})))
+	}
+	require.Nil(t, rc.Get(&RefreshTokenMock{IKey: "other" + keyPreview}))
+}
+
+func TestInvalidateTag(t *testing.T) {
+	rc := newMiniRedisClient(t, miniredis.RunT(t))
+
+	keyPreview := utils.RandomString(10)
+	tag1, tag2 := keyPreview+"tag1", keyPreview+"tag2"
+	require.Nil(t, rc.Set(&TaggedRefreshTokenMock{RefreshTokenMock: RefreshTokenMock{IKey: keyPreview + "key1"}, ITags: []string{tag1}}, 1000))
+	require.Nil(t, rc.Set(&TaggedRefreshTokenMock{RefreshTokenMock: RefreshTokenMock{IKey: keyPreview + "key2"}, ITags: []string{tag1, tag2}}, 100))
+	require.Nil(t, rc.Set(&TaggedRefreshTokenMock{RefreshTokenMock: RefreshTokenMock{IKey: keyPreview + "key3"}, ITags: []string{tag2}}, 10))
+
+	// tag sets expire with their longest-lived keys
+	ttl, err := rc.rc.TTL(context.Background(), cachestore.UniqTagKey(tag1)).Result()
+	require.Nil(t, err)
+	require.Equal(t, 1000*time.Second, ttl)
+	ttl, err = rc.rc.TTL(context.Background(), cachestore.UniqTagKey(tag2)).Result()
+	require.Nil(t, err)
+	require.Equal(t, 100*time.Second, ttl)
+
+	require.Nil(t, rc.InvalidateTag(tag1))
+
+	require.True(t, errcode.IsNotFound(rc.Get(&TaggedRefreshTokenMock{RefreshTokenMock: RefreshTokenMock{IKey: keyPreview + "key1"}})))
+	require.True(t, errcode.IsNotFound(rc.Get(&TaggedRefreshTokenMock{RefreshTokenMock: RefreshTokenMock{IKey: keyPreview + "key2"}})))
+	require.Nil(t, rc.Get(&TaggedRefreshTokenMock{RefreshTokenMock: RefreshTokenMock{IKey: keyPreview + "key3"}}))
+}
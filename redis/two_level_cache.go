@@ -0,0 +1,300 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/madlabx/pkgx/cachestore"
+	"github.com/madlabx/pkgx/errcode"
+	"github.com/madlabx/pkgx/errors"
+	"github.com/madlabx/pkgx/log"
+	"github.com/madlabx/pkgx/memkv"
+	"github.com/redis/go-redis/v9"
+)
+
+type TwoLevelConf struct {
+	L1      memkv.CacheConf
+	L1Ttl   int64  `vx_default:"60"` //in sec
+	Channel string `vx_default:"pkgx_cache_invalidation"`
+}
+
+// TwoLevelCache 本地memkv作为L1, redis作为L2.
+// 每次写入递增redis中的版本号并通过pub/sub通知其他节点丢弃L1,
+// 版本号用于丢弃乱序到达的失效通知及过期的回填
+type TwoLevelCache struct {
+	ctx    context.Context
+	l1     *memkv.Cache
+	l2     *Client
+	conf   TwoLevelConf
+	nodeId string
+
+	mutex    sync.Mutex
+	versions map[string]*versionEntry
+}
+
+type versionEntry struct {
+	version  int64
+	record   cachestore.Record //nil if not in l1
+	expireAt int64
+}
+
+type invalidation struct {
+	Node    string `json:"node"`
+	Key     string `json:"key"`
+	Version int64  `json:"version"`
+}
+
+func NewTwoLevelCache(pCtx context.Context, l2 *Client, conf TwoLevelConf) (*TwoLevelCache, error) {
+	if conf.L1Ttl <= 0 {
+		return nil, errors.Errorf("invalid L1Ttl:%v", conf.L1Ttl)
+	}
+
+	tc := &TwoLevelCache{
+		ctx:      pCtx,
+		l1:       memkv.NewCache(pCtx, nil, conf.L1),
+		l2:       l2,
+		conf:     conf,
+		nodeId:   uuid.New().String(),
+		versions: make(map[string]*versionEntry),
+	}
+
+	sub := l2.rc.Subscribe(pCtx, conf.Channel)
+	// wait for confirmation, or invalidations published right after return would be lost
+	if _, err := sub.Receive(pCtx); err != nil {
+		_ = sub.Close()
+		return nil, errors.Wrap(err)
+	}
+
+	go tc.subscribeLoop(sub)
+	go tc.gcLoop()
+
+	return tc, nil
+}
+
+func (tc *TwoLevelCache) Get(nr cachestore.Record) error {
+	key := cachestore.UniqCacheKey(nr)
+	if tc.inL1(key) {
+		if _, err := tc.l1.Get(nr); err == nil {
+			return nil
+		}
+	}
+
+	value, version, err := tc.l2.getWithVersion(key)
+	if err != nil {
+		return err
+	}
+
+	if err = nr.Unmarshal(value); err != nil {
+		return errors.Wrap(err)
+	}
+
+	tc.fill(nr, version)
+	return nil
+}
+
+func (tc *TwoLevelCache) Set(nr cachestore.Record, expireAfterInSec int64) error {
+	version, err := tc.l2.setWithVersion(nr, expireAfterInSec, tc.conf.L1Ttl)
+	if err != nil {
+		return err
+	}
+
+	tc.fill(nr, version)
+	return tc.publish(cachestore.UniqCacheKey(nr), version)
+}
+
+func (tc *TwoLevelCache) Delete(nr cachestore.Record) error {
+	key := cachestore.UniqCacheKey(nr)
+	version, err := tc.l2.deleteWithVersion(nr, tc.conf.L1Ttl)
+	if err != nil {
+		return err
+	}
+
+	tc.invalidate(key, version)
+	return tc.publish(key, version)
+}
+
+func (tc *TwoLevelCache) inL1(key string) bool {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	ve, ok := tc.versions[key]
+	return ok && ve.record != nil && time.Now().Unix() <= ve.expireAt
+}
+
+// fill stores nr into l1 unless a newer version has been seen
+func (tc *TwoLevelCache) fill(nr cachestore.Record, version int64) {
+	key := cachestore.UniqCacheKey(nr)
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	if ve, ok := tc.versions[key]; ok && ve.version > version {
+		return
+	}
+
+	record := nr.Clone()
+	if err := tc.l1.Set(record, tc.conf.L1Ttl); err != nil {
+		log.Errorf("Failed to fill l1, key:%v, err:%v", key, err)
+		return
+	}
+
+	tc.versions[key] = &versionEntry{
+		version:  version,
+		record:   record,
+		expireAt: time.Now().Unix() + tc.conf.L1Ttl,
+	}
+}
+
+// invalidate drops key from l1 unless the version in l1 is not older than version
+func (tc *TwoLevelCache) invalidate(key string, version int64) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	ve, ok := tc.versions[key]
+	if ok && ve.version >= version {
+		return
+	}
+
+	if ok && ve.record != nil {
+		_ = tc.l1.Delete(ve.record)
+	}
+
+	// keep the version to reject stale fills in flight
+	tc.versions[key] = &versionEntry{
+		version:  version,
+		expireAt: time.Now().Unix() + tc.conf.L1Ttl,
+	}
+}
+
+func (tc *TwoLevelCache) publish(key string, version int64) error {
+	msg, err := json.Marshal(&invalidation{Node: tc.nodeId, Key: key, Version: version})
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return errors.Wrap(tc.l2.rc.Publish(tc.ctx, tc.conf.Channel, msg).Err())
+}
+
+func (tc *TwoLevelCache) subscribeLoop(sub *redis.PubSub) {
+	defer func() { _ = sub.Close() }()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-tc.ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			var inv invalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+				log.Errorf("Ignore invalid invalidation, payload:%v, err:%v", msg.Payload, err)
+				continue
+			}
+
+			if inv.Node == tc.nodeId {
+				continue
+			}
+
+			tc.invalidate(inv.Key, inv.Version)
+		}
+	}
+}
+
+func (tc *TwoLevelCache) gcLoop() {
+	ticker := time.NewTicker(time.Second * time.Duration(tc.conf.L1Ttl))
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now().Unix()
+			tc.mutex.Lock()
+			for key, ve := range tc.versions {
+				if now > ve.expireAt {
+					if ve.record != nil {
+						_ = tc.l1.Delete(ve.record)
+					}
+					delete(tc.versions, key)
+				}
+			}
+			tc.mutex.Unlock()
+
+		case <-tc.ctx.Done():
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// versionKey uses hash tag to keep key and its version in the same slot in cluster mode
+func versionKey(key string) string {
+	return "ver_{" + key + "}"
+}
+
+func (rc *Client) getWithVersion(key string) (string, int64, error) {
+	values, err := rc.rc.MGet(rc.ctx, key, versionKey(key)).Result()
+	if err != nil {
+		return "", 0, errors.Wrap(err)
+	}
+
+	value, ok := values[0].(string)
+	if !ok {
+		return "", 0, errcode.ErrObjectNotExist()
+	}
+
+	var version int64
+	if s, ok := values[1].(string); ok {
+		if version, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return "", 0, errors.Wrap(err)
+		}
+	}
+
+	return value, version, nil
+}
+
+// setWithVersion sets nr and increases its version atomically.
+// The version outlives the value by extraTtlInSec so that stale l1 fills can still be detected
+func (rc *Client) setWithVersion(nr cachestore.Record, expireAfterInSec, extraTtlInSec int64) (int64, error) {
+	key := cachestore.UniqCacheKey(nr)
+
+	var incr *redis.IntCmd
+	_, err := rc.rc.TxPipelined(rc.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(rc.ctx, key, nr.GetValue(), time.Second*time.Duration(expireAfterInSec))
+		rc.indexAdd(pipe, nr.TableName(), key)
+		incr = pipe.Incr(rc.ctx, versionKey(key))
+		if expireAfterInSec > 0 {
+			pipe.Expire(rc.ctx, versionKey(key), time.Second*time.Duration(expireAfterInSec+extraTtlInSec))
+		} else {
+			pipe.Persist(rc.ctx, versionKey(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	return incr.Val(), nil
+}
+
+func (rc *Client) deleteWithVersion(nr cachestore.Record, extraTtlInSec int64) (int64, error) {
+	key := cachestore.UniqCacheKey(nr)
+
+	var incr *redis.IntCmd
+	_, err := rc.rc.TxPipelined(rc.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(rc.ctx, key)
+		rc.indexRemove(pipe, nr.TableName(), key)
+		incr = pipe.Incr(rc.ctx, versionKey(key))
+		pipe.Expire(rc.ctx, versionKey(key), time.Second*time.Duration(extraTtlInSec))
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	return incr.Val(), nil
+}
@@ -0,0 +1,93 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/madlabx/pkgx/cachestore"
+	"github.com/madlabx/pkgx/errcode"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func newMiniRedisClient(t *testing.T, mr *miniredis.Miniredis) *Client {
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return NewTestClient(context.Background(), rdb)
+}
+
+func newTwoLevelCachePair(t *testing.T) (*TwoLevelCache, *TwoLevelCache) {
+	mr := miniredis.RunT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	conf := TwoLevelConf{L1Ttl: 60, Channel: "test_invalidation"}
+	tc1, err := NewTwoLevelCache(ctx, newMiniRedisClient(t, mr), conf)
+	require.Nil(t, err)
+	tc2, err := NewTwoLevelCache(ctx, newMiniRedisClient(t, mr), conf)
+	require.Nil(t, err)
+
+	return tc1, tc2
+}
+
+func TestTwoLevelCacheInvalidation(t *testing.T) {
+	tc1, tc2 := newTwoLevelCachePair(t)
+
+	require.Nil(t, tc1.Set(&RefreshTokenMock{IKey: "key1", IValue: "v1"}, 100))
+
+	rt := &RefreshTokenMock{IKey: "key1"}
+	require.Nil(t, tc2.Get(rt))
+	require.Equal(t, "v1", rt.IValue)
+	require.True(t, tc2.inL1(cachestore.UniqCacheKey(rt)))
+
+	require.Nil(t, tc1.Set(&RefreshTokenMock{IKey: "key1", IValue: "v2"}, 100))
+	require.Eventually(t, func() bool { return !tc2.inL1(cachestore.UniqCacheKey(rt)) }, time.Second, 10*time.Millisecond)
+
+	rt = &RefreshTokenMock{IKey: "key1"}
+	require.Nil(t, tc2.Get(rt))
+	require.Equal(t, "v2", rt.IValue)
+
+	require.Nil(t, tc1.Delete(&RefreshTokenMock{IKey: "key1"}))
+	require.Eventually(t, func() bool { return !tc2.inL1(cachestore.UniqCacheKey(rt)) }, time.Second, 10*time.Millisecond)
+	require.True(t, errcode.IsNotFound(tc2.Get(&RefreshTokenMock{IKey: "key1"})))
+	require.True(t, errcode.IsNotFound(tc1.Get(&RefreshTokenMock{IKey: "key1"})))
+}
+
+func TestTwoLevelCacheOutOfOrder(t *testing.T) {
+	tc1, _ := newTwoLevelCachePair(t)
+	key := cachestore.UniqCacheKey(&RefreshTokenMock{IKey: "key2"})
+
+	// invalidation of version 3 arrives before the fill of version 2
+	tc1.invalidate(key, 3)
+	tc1.fill(&RefreshTokenMock{IKey: "key2", IValue: "stale"}, 2)
+	require.False(t, tc1.inL1(key))
+
+	tc1.fill(&RefreshTokenMock{IKey: "key2", IValue: "fresh"}, 3)
+	require.True(t, tc1.inL1(key))
+
+	// older invalidation must not drop the newer value
+	tc1.invalidate(key, 2)
+	require.True(t, tc1.inL1(key))
+}
+
+func TestTwoLevelCacheL1Ttl(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tc, err := NewTwoLevelCache(ctx, newMiniRedisClient(t, mr), TwoLevelConf{L1Ttl: 1, Channel: "test_invalidation"})
+	require.Nil(t, err)
+
+	rt := &RefreshTokenMock{IKey: "key3", IValue: "v3"}
+	require.Nil(t, tc.Set(rt, 100))
+	require.True(t, tc.inL1(cachestore.UniqCacheKey(rt)))
+
+	time.Sleep(2100 * time.Millisecond)
+	require.False(t, tc.inL1(cachestore.UniqCacheKey(rt)))
+
+	got := &RefreshTokenMock{IKey: "key3"}
+	require.Nil(t, tc.Get(got))
+	require.Equal(t, "v3", got.IValue)
+}
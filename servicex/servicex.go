@@ -2,7 +2,7 @@ package servicex
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"reflect"
 	"sync"
 
@@ -3,9 +3,8 @@ package testingx
 import (
 	"testing"
 
+	"github.com/madlabx/pkgx/log"
 	"github.com/stretchr/testify/require"
-
-	"log"
 )
 
 func NilAndLog(t *testing.T, err error) {
@@ -0,0 +1,212 @@
+package tracex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/madlabx/pkgx/errors"
+)
+
+// Exporter receives ended and sampled spans in batches from a single goroutine
+type Exporter interface {
+	Export(ctx context.Context, spans []*Span) error
+	Shutdown(ctx context.Context) error
+}
+
+// InMemoryExporter keeps spans for tests
+type InMemoryExporter struct {
+	mutex sync.Mutex
+	spans []*Span
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) Export(_ context.Context, spans []*Span) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *InMemoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = nil
+}
+
+type OtlpConfig struct {
+	// Endpoint of OTLP/HTTP traces, e.g. http://otel-collector:4318/v1/traces
+	Endpoint    string
+	ServiceName string
+	Headers     map[string]string // e.g. authorization of the collector
+	Timeout     int               `vx_default:"10"` //in sec
+}
+
+// OtlpExporter posts spans to a collector by OTLP/HTTP in JSON encoding, without the OpenTelemetry SDK
+type OtlpExporter struct {
+	conf   OtlpConfig
+	client *http.Client
+}
+
+func NewOtlpExporter(conf OtlpConfig) (*OtlpExporter, error) {
+	if conf.Endpoint == "" {
+		return nil, errors.New("empty endpoint of otlp exporter")
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 10
+	}
+	return &OtlpExporter{
+		conf:   conf,
+		client: &http.Client{Timeout: time.Duration(conf.Timeout) * time.Second},
+	}, nil
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // int64 is a string in JSON of protobuf
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Flags             uint32         `json:"flags,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func toOtlpValue(v any) otlpValue {
+	switch x := v.(type) {
+	case string:
+		return otlpValue{StringValue: &x}
+	case bool:
+		return otlpValue{BoolValue: &x}
+	case int:
+		s := strconv.Itoa(x)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &x}
+	default:
+		s := fmt.Sprint(x)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+func toOtlpSpan(s *Span) otlpSpan {
+	os := otlpSpan{
+		TraceId:           s.TraceId.String(),
+		SpanId:            s.SpanId.String(),
+		TraceState:        s.TraceState,
+		Flags:             uint32(s.Flags),
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+		Status:            otlpStatus{Code: s.StatusCode, Message: s.StatusMessage},
+	}
+	if s.ParentSpanId.IsValid() {
+		os.ParentSpanId = s.ParentSpanId.String()
+	}
+	for k, v := range s.Attributes {
+		os.Attributes = append(os.Attributes, otlpKeyValue{Key: k, Value: toOtlpValue(v)})
+	}
+	return os
+}
+
+func (e *OtlpExporter) Export(ctx context.Context, spans []*Span) error {
+	ss := otlpScopeSpans{Spans: make([]otlpSpan, 0, len(spans))}
+	ss.Scope.Name = "github.com/madlabx/pkgx/tracex"
+	for _, s := range spans {
+		ss.Spans = append(ss.Spans, toOtlpSpan(s))
+	}
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{ss}}
+	rs.Resource.Attributes = []otlpKeyValue{{Key: "service.name", Value: toOtlpValue(e.conf.ServiceName)}}
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{rs}}
+
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, e.conf.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	for k, v := range e.conf.Headers {
+		hr.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(hr)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.Errorf("export %v spans to %v, status:%v, body:%s", len(spans), e.conf.Endpoint, resp.StatusCode, msg)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (e *OtlpExporter) Shutdown(context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
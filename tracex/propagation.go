@@ -0,0 +1,42 @@
+package tracex
+
+import (
+	"context"
+	"net/http"
+)
+
+// Inject writes traceparent and tracestate of the current span of ctx into h, nothing if not traced
+func Inject(ctx context.Context, h http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	h.Set(HeaderTraceparent, sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Set(HeaderTracestate, sc.TraceState)
+	} else {
+		h.Del(HeaderTracestate)
+	}
+}
+
+// Extract reads the parent from h, false if absent or invalid, then a new trace should be started
+func Extract(h http.Header) (SpanContext, bool) {
+	tp := h.Get(HeaderTraceparent)
+	if tp == "" {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(tp)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	// multiple tracestate headers are combined as a list
+	if values := h.Values(HeaderTracestate); len(values) > 0 {
+		ts := values[0]
+		for _, v := range values[1:] {
+			ts += "," + v
+		}
+		sc.TraceState = normalizeTracestate(ts)
+	}
+	sc.Remote = true
+	return sc, true
+}
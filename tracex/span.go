@@ -0,0 +1,272 @@
+package tracex
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type SpanKind int
+
+// values of OTLP
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+type StatusCode int
+
+// values of OTLP
+const (
+	StatusUnset StatusCode = 0
+	StatusOk    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Span fields must not be changed after End, exporters read them without lock
+type Span struct {
+	SpanContext
+	ParentSpanId  SpanId
+	Name          string
+	Kind          SpanKind
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    map[string]any
+	StatusCode    StatusCode
+	StatusMessage string
+
+	tracer *Tracer
+	mutex  sync.Mutex
+	ended  bool
+}
+
+// SetAttribute values of string, bool, int, int64 and float64 are exported as typed, others as strings
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ended {
+		return
+	}
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]any)
+	}
+	s.Attributes[key] = value
+}
+
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.ended {
+		s.StatusCode, s.StatusMessage = code, msg
+	}
+}
+
+// SetError marks the span failed if err is not nil
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.SetStatus(StatusError, err.Error())
+	}
+}
+
+// End records the end time and exports the span if sampled, only the first call takes effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mutex.Unlock()
+
+	if s.IsSampled() {
+		s.tracer.enqueue(s)
+	}
+}
+
+type Config struct {
+	// SampleRatio of new traces, a remote parent's decision is always followed. All are sampled if <= 0
+	SampleRatio   float64 `vx_default:"1"`
+	BatchSize     int     `vx_default:"512"`
+	QueueSize     int     `vx_default:"2048"` // spans are dropped if the queue is full
+	FlushInterval int     `vx_default:"5"`    //in sec
+	// OnError receives export errors, failed batches are dropped
+	OnError func(err error) `json:"-"`
+}
+
+// Tracer starts spans and exports ended ones in batches. Spans are not exported if exporter is nil,
+// but ids are still generated and propagated
+type Tracer struct {
+	conf     Config
+	exporter Exporter
+	queue    chan *Span
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	dropped  atomic.Uint64
+}
+
+func NewTracer(ctx context.Context, conf Config, exporter Exporter) *Tracer {
+	if conf.SampleRatio <= 0 || conf.SampleRatio > 1 {
+		conf.SampleRatio = 1
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = 512
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = 2048
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = 5
+	}
+
+	t := &Tracer{
+		conf:     conf,
+		exporter: exporter,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if exporter == nil {
+		close(t.done)
+		return t
+	}
+	t.queue = make(chan *Span, conf.QueueSize)
+	go t.exportLoop(ctx)
+	return t
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+func init() {
+	defaultTracer.Store(NewTracer(context.Background(), Config{}, nil))
+}
+
+// Default is used if no span in the context, it exports nothing unless replaced by SetDefault
+func Default() *Tracer {
+	return defaultTracer.Load()
+}
+
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Start starts a span by the tracer of the current span in ctx, or by Default
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	t := Default()
+	if parent := SpanFromContext(ctx); parent != nil {
+		t = parent.tracer
+	}
+	return t.Start(ctx, name, kind)
+}
+
+// Start starts a child of the span in ctx, or a new trace, the returned context carries the new span
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := &Span{Name: name, Kind: kind, StartTime: time.Now(), tracer: t}
+
+	if parent := SpanContextFromContext(ctx); parent.IsValid() {
+		span.TraceId = parent.TraceId
+		span.ParentSpanId = parent.SpanId
+		span.Flags = parent.Flags
+		span.TraceState = parent.TraceState
+	} else {
+		span.TraceId = newTraceId()
+		if rand.Float64() < t.conf.SampleRatio {
+			span.Flags = FlagSampled
+		}
+	}
+	span.SpanId = newSpanId()
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// Dropped spans because the queue is full
+func (t *Tracer) Dropped() uint64 {
+	return t.dropped.Load()
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	if t.queue == nil {
+		return
+	}
+	select {
+	case t.queue <- s:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+func (t *Tracer) exportLoop(ctx context.Context) {
+	defer close(t.done)
+
+	ticker := time.NewTicker(time.Duration(t.conf.FlushInterval) * time.Second)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, t.conf.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(context.Background(), batch); err != nil && t.conf.OnError != nil {
+			t.conf.OnError(err)
+		}
+		batch = make([]*Span, 0, t.conf.BatchSize)
+	}
+	drain := func() {
+		for {
+			select {
+			case s := <-t.queue:
+				batch = append(batch, s)
+				if len(batch) >= t.conf.BatchSize {
+					flush()
+				}
+			default:
+				flush()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= t.conf.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.quit:
+			drain()
+			return
+		case <-ctx.Done():
+			drain()
+			return
+		}
+	}
+}
+
+// Shutdown exports queued spans, then shuts the exporter down. Spans ended later are dropped
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.stopOnce.Do(func() { close(t.quit) })
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if t.exporter == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}
@@ -0,0 +1,148 @@
+package tracex
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand/v2"
+	"strings"
+
+	"github.com/madlabx/pkgx/errors"
+)
+
+// W3C trace context, see https://www.w3.org/TR/trace-context/
+const (
+	HeaderTraceparent = "traceparent"
+	HeaderTracestate  = "tracestate"
+
+	FlagSampled byte = 0x01
+
+	maxTracestateLen     = 512
+	maxTracestateMembers = 32
+)
+
+type TraceId [16]byte
+
+type SpanId [8]byte
+
+func (t TraceId) String() string { return hex.EncodeToString(t[:]) }
+func (t TraceId) IsValid() bool  { return t != TraceId{} }
+func (s SpanId) String() string  { return hex.EncodeToString(s[:]) }
+func (s SpanId) IsValid() bool   { return s != SpanId{} }
+
+func newTraceId() TraceId {
+	var t TraceId
+	for !t.IsValid() {
+		putUint64(t[:8], rand.Uint64())
+		putUint64(t[8:], rand.Uint64())
+	}
+	return t
+}
+
+func newSpanId() SpanId {
+	var s SpanId
+	for !s.IsValid() {
+		putUint64(s[:], rand.Uint64())
+	}
+	return s
+}
+
+func putUint64(b []byte, v uint64) {
+	for i := range 8 {
+		b[i] = byte(v >> (56 - 8*i))
+	}
+}
+
+// SpanContext the propagated part of a span
+type SpanContext struct {
+	TraceId    TraceId
+	SpanId     SpanId
+	Flags      byte
+	TraceState string
+	Remote     bool // extracted from a request
+}
+
+func (sc SpanContext) IsValid() bool   { return sc.TraceId.IsValid() && sc.SpanId.IsValid() }
+func (sc SpanContext) IsSampled() bool { return sc.Flags&FlagSampled != 0 }
+
+// Traceparent formats sc in version 00
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceId.String() + "-" + sc.SpanId.String() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTraceparent parses version-traceid-parentid-flags. Fields appended by future versions are ignored
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	s = strings.TrimSpace(s)
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, errors.Errorf("invalid traceparent %q", s)
+	}
+	version := s[:2]
+	if !isLowerHex(version) || version == "ff" {
+		return sc, errors.Errorf("invalid traceparent version %q", version)
+	}
+	if len(s) > 55 && (version == "00" || s[55] != '-') {
+		return sc, errors.Errorf("invalid traceparent %q", s)
+	}
+
+	traceId, spanId, flags := s[3:35], s[36:52], s[53:55]
+	if !isLowerHex(traceId) || !isLowerHex(spanId) || !isLowerHex(flags) {
+		return sc, errors.Errorf("invalid traceparent %q", s)
+	}
+	_, _ = hex.Decode(sc.TraceId[:], []byte(traceId))
+	_, _ = hex.Decode(sc.SpanId[:], []byte(spanId))
+	var f [1]byte
+	_, _ = hex.Decode(f[:], []byte(flags))
+	sc.Flags = f[0]
+
+	if !sc.IsValid() {
+		return sc, errors.Errorf("all zero id in traceparent %q", s)
+	}
+	return sc, nil
+}
+
+// normalizeTracestate drops tracestate violating limits, which may be discarded by the spec
+func normalizeTracestate(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > maxTracestateLen || strings.Count(s, ",")+1 > maxTracestateMembers {
+		return ""
+	}
+	return s
+}
+
+type ctxKeySpan struct{}
+type ctxKeyRemote struct{}
+
+// ContextWithSpan sets span as the parent of spans started with the returned context
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, ctxKeySpan{}, span)
+}
+
+// SpanFromContext returns nil if no span is started in this process
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(ctxKeySpan{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext sets the parent extracted from a request
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	sc.Remote = true
+	return context.WithValue(ctx, ctxKeyRemote{}, sc)
+}
+
+// SpanContextFromContext the current span, or the remote parent, invalid if none
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext
+	}
+	sc, _ := ctx.Value(ctxKeyRemote{}).(SpanContext)
+	return sc
+}
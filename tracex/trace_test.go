@@ -0,0 +1,118 @@
+package tracex
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(tp)
+	require.NoError(t, err)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceId.String())
+	require.Equal(t, "00f067aa0ba902b7", sc.SpanId.String())
+	require.True(t, sc.IsSampled())
+	require.Equal(t, tp, sc.Traceparent())
+
+	// future versions may append fields
+	_, err = ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	require.NoError(t, err)
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, err = ParseTraceparent(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	h := http.Header{}
+	h.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Add(HeaderTracestate, "congo=t61rcWkgMzE")
+	h.Add(HeaderTracestate, "rojo=00f067aa0ba902b7")
+
+	parent, ok := Extract(h)
+	require.True(t, ok)
+	require.True(t, parent.Remote)
+	require.Equal(t, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", parent.TraceState)
+
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(context.Background(), Config{}, exporter)
+	ctx, span := tracer.Start(ContextWithRemoteSpanContext(context.Background(), parent), "server", SpanKindServer)
+	require.Equal(t, parent.TraceId, span.TraceId)
+	require.Equal(t, parent.SpanId, span.ParentSpanId)
+	require.NotEqual(t, parent.SpanId, span.SpanId)
+
+	out := http.Header{}
+	Inject(ctx, out)
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanId.String()+"-01", out.Get(HeaderTraceparent))
+	require.Equal(t, parent.TraceState, out.Get(HeaderTracestate))
+
+	// a remote decision of not sampled is followed
+	h.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	parent, _ = Extract(h)
+	_, unsampled := tracer.Start(ContextWithRemoteSpanContext(context.Background(), parent), "server", SpanKindServer)
+	unsampled.End()
+	span.End()
+	span.End()
+
+	require.NoError(t, tracer.Shutdown(context.Background()))
+	spans := exporter.Spans()
+	require.Len(t, spans, 1)
+	require.Equal(t, span.SpanId, spans[0].SpanId)
+
+	_, ok = Extract(http.Header{HeaderTraceparent: []string{"garbage"}})
+	require.False(t, ok)
+	Inject(context.Background(), out)
+}
+
+func TestOtlpExporter(t *testing.T) {
+	var received otlpRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "token", r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	exporter, err := NewOtlpExporter(OtlpConfig{Endpoint: srv.URL, ServiceName: "svc", Headers: map[string]string{"Authorization": "token"}})
+	require.NoError(t, err)
+	tracer := NewTracer(context.Background(), Config{FlushInterval: 1}, exporter)
+
+	ctx, root := tracer.Start(context.Background(), "root", SpanKindServer)
+	_, child := Start(ctx, "child", SpanKindClient)
+	child.SetAttribute("http.response.status_code", 502)
+	child.SetError(io.ErrUnexpectedEOF)
+	child.End()
+	root.End()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, tracer.Shutdown(shutdownCtx))
+
+	require.Len(t, received.ResourceSpans, 1)
+	require.Equal(t, "svc", *received.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	spans := received.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	require.Equal(t, "child", spans[0].Name)
+	require.Equal(t, root.SpanId.String(), spans[0].ParentSpanId)
+	require.Equal(t, root.TraceId.String(), spans[0].TraceId)
+	require.Equal(t, StatusError, spans[0].Status.Code)
+	require.Equal(t, "502", *spans[0].Attributes[0].Value.IntValue)
+	require.Empty(t, spans[1].ParentSpanId)
+}